@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+Admin HTTP API of the CC, served next to /metrics on -listen-address. It is
+not authenticated, so the address should only be reachable by the operators.
+
+	GET    /admin/topology        nodes with their pods and cgroup paths
+	GET    /admin/solver          last solver input and output
+	GET    /admin/sent            last request of each kind sent to each node
+	GET    /admin/enforcement     whether enforcement is paused
+	POST   /admin/pause           stop sending decisions to the host agents
+	POST   /admin/resume          send them again from the next round
+	GET    /admin/pins            pinned tenants
+	PUT    /admin/pins            pin a tenant (body: Pin)
+	DELETE /admin/pins/{tenant}   clear the tenant's pin
+
+While paused, the rounds still run and are logged, so the decisions can be
+inspected without being applied.
+
+A tenant with pinned LB weights is left out of the optimization: its load is
+split among its pods by the pinned weights (see getPinnedLoads) and taken
+off its nodes' capacity before the other tenants are solved for. A pod with
+a pinned quota is never assigned more load than the quota allows. Pinned
+weights and quotas are enforced as they are. Pins are kept in memory until
+cleared.
+*/
+
+// Pin fixes the LB weights and/or the CPU quotas of a tenant's pods.
+type Pin struct {
+	Tenant string `json:"tenant"`
+	// pod -> LB weight; normalized to 100 over the tenant's pods, pods
+	// left out get 0
+	LBWeights map[string]float64 `json:"lbWeights,omitempty"`
+	// pod -> cpu.cfs_quota_us (-1 = no quota)
+	CPUQuotas map[string]int64 `json:"cpuQuotas,omitempty"`
+}
+
+// solverCall is the input and output of one solve
+type solverCall struct {
+	Time     time.Time             `json:"time"`
+	Hosts    []HostJSON            `json:"hosts"`
+	Tenants  []TenantJSON          `json:"tenants"`
+	Pods     []PodJSON             `json:"pods"`
+	Response GurobiGenericResponse `json:"response"`
+	Error    string                `json:"error,omitempty"`
+	// PRIMARY, or FALLBACK if the response is the heuristic allocator's
+	// after the solver failed with Error (see Fallback.go)
+	SolvedBy string `json:"solvedBy"`
+	// what the response leaves, none if the solver found no allocation
+	Allocation *Allocation `json:"allocation,omitempty"`
+}
+
+// Allocation is the load a solve assigned to each tenant and the capacity it
+// left spare on each node.
+type Allocation struct {
+	Assigned map[string]float64 `json:"assigned"`
+	Spare    map[string]float64 `json:"spare"`
+}
+
+// getAllocation returns the allocation of response over the hosts with
+// capacities hostCaps, or nil if the solver found none.
+func getAllocation(hostCaps map[string]float64, pods []PodJSON,
+	response GurobiGenericResponse) *Allocation {
+
+	if !hasSolution(response.Status) {
+		return nil
+	}
+
+	allocation := Allocation{
+		Assigned: make(map[string]float64),
+		Spare:    maps.Clone(hostCaps),
+	}
+	for _, pod := range pods {
+		load := response.Result[pod.Tenant][pod.Name]
+		allocation.Spare[pod.Host] -= load
+		allocation.Assigned[pod.Tenant] += load
+	}
+	return &allocation
+}
+
+// sentRequest is the last request of a kind sent to a node
+type sentRequest struct {
+	Time    time.Time   `json:"time"`
+	Request interface{} `json:"request"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type adminState struct {
+	mu        sync.Mutex
+	paused    bool
+	pins      map[string]Pin
+	lastSolve *solverCall
+	lastSent  map[string]map[string]sentRequest
+}
+
+// admin is the state shared by the admin API and the rounds
+var admin = &adminState{
+	pins:     make(map[string]Pin),
+	lastSent: make(map[string]map[string]sentRequest),
+}
+
+func (a *adminState) IsPaused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
+
+func (a *adminState) setPaused(paused bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.paused = paused
+}
+
+// Pins returns a snapshot of the pins by tenant
+func (a *adminState) Pins() map[string]Pin {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pins := make(map[string]Pin, len(a.pins))
+	for tenant, pin := range a.pins {
+		pins[tenant] = pin
+	}
+	return pins
+}
+
+func (a *adminState) recordSolve(call solverCall) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastSolve = &call
+}
+
+// LastSolve returns the last solve, nil before the first one
+func (a *adminState) LastSolve() *solverCall {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastSolve
+}
+
+func (a *adminState) recordSent(
+	nodeName, method string, req interface{}, err error) {
+
+	sent := sentRequest{Time: time.Now(), Request: req}
+	if err != nil {
+		sent.Error = err.Error()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastSent[nodeName] == nil {
+		a.lastSent[nodeName] = make(map[string]sentRequest)
+	}
+	a.lastSent[nodeName][method] = sent
+}
+
+// serveAdmin adds the admin API to mux.
+func serveAdmin(mux *http.ServeMux, topology *Topology) {
+
+	mux.HandleFunc("GET /admin/topology",
+		func(w http.ResponseWriter, r *http.Request) {
+			type nodeView struct {
+				Node
+				Healthy bool
+				Removed bool
+			}
+			nodes := topology.Nodes()
+			views := make([]nodeView, len(nodes))
+			for i, node := range nodes {
+				views[i] = nodeView{node, node.IsHealthy(), node.IsRemoved()}
+			}
+			writeJSON(w, http.StatusOK, views)
+		})
+
+	mux.HandleFunc("GET /admin/solver",
+		func(w http.ResponseWriter, r *http.Request) {
+			admin.mu.Lock()
+			defer admin.mu.Unlock()
+			writeJSON(w, http.StatusOK, admin.lastSolve)
+		})
+
+	mux.HandleFunc("GET /admin/sent",
+		func(w http.ResponseWriter, r *http.Request) {
+			admin.mu.Lock()
+			defer admin.mu.Unlock()
+			writeJSON(w, http.StatusOK, admin.lastSent)
+		})
+
+	mux.HandleFunc("GET /admin/enforcement", serveEnforcementState)
+	mux.HandleFunc("POST /admin/pause",
+		func(w http.ResponseWriter, r *http.Request) {
+			slog.Info("Enforcement paused from the admin API")
+			admin.setPaused(true)
+			serveEnforcementState(w, r)
+		})
+	mux.HandleFunc("POST /admin/resume",
+		func(w http.ResponseWriter, r *http.Request) {
+			slog.Info("Enforcement resumed from the admin API")
+			admin.setPaused(false)
+			serveEnforcementState(w, r)
+		})
+
+	mux.HandleFunc("GET /admin/pins",
+		func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, admin.Pins())
+		})
+
+	mux.HandleFunc("PUT /admin/pins",
+		func(w http.ResponseWriter, r *http.Request) {
+			var pin Pin
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&pin)
+			if err == nil {
+				err = validatePin(pin)
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest,
+					map[string]string{"error": err.Error()})
+				return
+			}
+
+			slog.Info(fmt.Sprintf("Pinned tenant %s: %+v", pin.Tenant, pin))
+			admin.mu.Lock()
+			admin.pins[pin.Tenant] = pin
+			admin.mu.Unlock()
+			writeJSON(w, http.StatusOK, pin)
+		})
+
+	mux.HandleFunc("DELETE /admin/pins/{tenant}",
+		func(w http.ResponseWriter, r *http.Request) {
+			tenant := r.PathValue("tenant")
+			admin.mu.Lock()
+			_, ok := admin.pins[tenant]
+			delete(admin.pins, tenant)
+			admin.mu.Unlock()
+
+			if !ok {
+				writeJSON(w, http.StatusNotFound,
+					map[string]string{"error": "tenant " + tenant + " is not pinned"})
+				return
+			}
+			slog.Info("Cleared the pin of tenant " + tenant)
+			w.WriteHeader(http.StatusNoContent)
+		})
+}
+
+func serveEnforcementState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": admin.IsPaused()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		slog.Warn("Failed to write admin API response: " + err.Error())
+	}
+}
+
+func validatePin(pin Pin) error {
+	if pin.Tenant == "" {
+		return fmt.Errorf("no tenant")
+	}
+	if len(pin.LBWeights) == 0 && len(pin.CPUQuotas) == 0 {
+		return fmt.Errorf("neither lbWeights nor cpuQuotas")
+	}
+
+	totalWeight := 0.0
+	for podName, weight := range pin.LBWeights {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("invalid LB weight %v of pod %s", weight, podName)
+		}
+		totalWeight += weight
+	}
+	if len(pin.LBWeights) > 0 && totalWeight == 0 {
+		return fmt.Errorf("all LB weights are 0")
+	}
+
+	for podName, quota := range pin.CPUQuotas {
+		if quota != -1 && quota < MINIMUM_CPU_QUOTA {
+			return fmt.Errorf("CPU quota %d of pod %s is below %d (or -1)",
+				quota, podName, MINIMUM_CPU_QUOTA)
+		}
+	}
+	return nil
+}
+
+// getPinnedLoads returns tenant -> pod -> load of the tenants whose LB
+// weights are pinned: the tenant's load split among its pods by the pinned
+// weights, capped at the pinned quotas.
+func getPinnedLoads(pods []PodJSON, appUtils map[string]float64,
+	pins map[string]Pin) map[string]map[string]float64 {
+
+	totalWeights := make(map[string]float64)
+	for _, pod := range pods {
+		totalWeights[pod.Tenant] += pins[pod.Tenant].LBWeights[pod.Name]
+	}
+
+	pinnedLoads := make(map[string]map[string]float64)
+	for _, pod := range pods {
+		pin, ok := pins[pod.Tenant]
+		if !ok || len(pin.LBWeights) == 0 {
+			continue
+		}
+		if pinnedLoads[pod.Tenant] == nil {
+			pinnedLoads[pod.Tenant] = make(map[string]float64)
+		}
+		load := 0.0
+		if totalWeights[pod.Tenant] > 0 {
+			load = appUtils[pod.Tenant] * pin.LBWeights[pod.Name] /
+				totalWeights[pod.Tenant]
+		}
+		if pod.MaxLoad > 0 {
+			load = math.Min(load, pod.MaxLoad)
+		}
+		pinnedLoads[pod.Tenant][pod.Name] = load
+	}
+	return pinnedLoads
+}
+
+// getPinnedMaxLoad returns the most load (percent of one core) the pod can
+// take under its pinned quota, or 0 if its quota is not pinned.
+func getPinnedMaxLoad(pod Pod, pins map[string]Pin) float64 {
+	quota, ok := pins[pod.Tenant].CPUQuotas[pod.Name]
+	if !ok || quota < 0 {
+		return 0
+	}
+	return float64(quota) / CFS_PERIOD_US * 100
+}
+
+// getLBWeightBasis returns the values the tenant's LB weights are
+// proportional to: the pinned weights of its pods if they are pinned, or
+// else the loads the solver assigned to them.
+func getLBWeightBasis(tenant string, podResult map[string]float64,
+	pins map[string]Pin) map[string]float64 {
+
+	pin, ok := pins[tenant]
+	if !ok || len(pin.LBWeights) == 0 {
+		return podResult
+	}
+	basis := make(map[string]float64, len(podResult))
+	for podName := range podResult {
+		basis[podName] = pin.LBWeights[podName]
+	}
+	return basis
+}
+
+// setPinnedCPUQuotas replaces the quotas of the pods with a pinned quota.
+func setPinnedCPUQuotas(nodes []Node, nodeCPUQuotas []map[string]int64,
+	pins map[string]Pin) {
+
+	for i, node := range nodes {
+		if i >= len(nodeCPUQuotas) || nodeCPUQuotas[i] == nil {
+			continue
+		}
+		for podName, pod := range node.Pods {
+			if quota, ok := pins[pod.Tenant].CPUQuotas[podName]; ok {
+				nodeCPUQuotas[i][podName] = quota
+			}
+		}
+	}
+}
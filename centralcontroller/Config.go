@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"protocol"
+)
+
+/*
+Config is the runtime configuration of the CC. Every field defaults to the
+constant of the same name in main.go, can be set in a JSON config file
+(-config, keys as in the json tags below) and can be overridden by the flag
+of the same name:
+
+	defaults < config file < flags
+
+Unknown keys in the config file and invalid values are reported at startup.
+*/
+type Config struct {
+	// enforcement mechanism(s), see Enforcer.go
+	Enforcement string `json:"enforcement"`
+	// label (or annotation) naming the tenant of a pod
+	TenantLabel string `json:"tenantLabel"`
+	// kubeconfig file of the cluster ("" = ~/.kube/config if there is one,
+	// else the in-cluster configuration of the CC's pod)
+	Kubeconfig string `json:"kubeconfig"`
+
+	// GO | GUROBI, and the Gurobi server's URL
+	Solver    string `json:"solver"`
+	SolverURL string `json:"solverURL"`
+	// deadline of a solve, after which the heuristic allocator takes over
+	// (see Fallback.go)
+	SolverTimeoutMs int `json:"solverTimeoutMs"`
+	// fairness objective of the GO solver (see Objective.go)
+	Objective string `json:"objective"`
+	// network bandwidth of every node, received and sent (0 = not
+	// constrained, see Resources.go)
+	NodeNetworkBytesPerSec float64 `json:"nodeNetworkBytesPerSec"`
+
+	// address of the HTTP server serving /metrics and the admin API
+	ListenAddress string `json:"listenAddress"`
+
+	// file the rounds are logged to ("" = stdout)
+	LogFile string `json:"logFile"`
+	// how long the CC runs before exiting (0 = forever)
+	RunDurationMs int `json:"runDurationMs"`
+
+	RoundDurationMs int `json:"roundDurationMs"`
+	// window of the CPU Utilizations pushed by the host agents
+	// (0 = one round)
+	CPUUtilizationWindowMs int `json:"cpuUtilizationWindowMs"`
+	// percentiles of the CPU Utilizations within the window, and more
+	// windows to average them over, pushed by the host agents for the
+	// StatisticsEstimators (see Estimator.go)
+	CPUUtilizationPercentiles []float64 `json:"cpuUtilizationPercentiles"`
+	CPUUtilizationWindowsMs   []int     `json:"cpuUtilizationWindowsMs"`
+	RollingAverageRounds      int       `json:"rollingAverageRounds"`
+
+	// load estimator of the tenants, and of single tenants by tenant
+	// (see Estimator.go), and the parameters of the estimators
+	Estimator           string            `json:"estimator"`
+	TenantEstimators    map[string]string `json:"tenantEstimators"`
+	EWMAHalfLifeRounds  float64           `json:"ewmaHalfLifeRounds"`
+	EstimatorPercentile float64           `json:"estimatorPercentile"`
+	HoltAlpha           float64           `json:"holtAlpha"`
+	HoltBeta            float64           `json:"holtBeta"`
+
+	// CPU added to the loads of the tenants, and of single tenants by
+	// tenant
+	OverheadPercent         float64            `json:"overheadPercent"`
+	TenantOverheadPercent   map[string]float64 `json:"tenantOverheadPercent"`
+	PodQuotaOverheadPercent float64            `json:"podQuotaOverheadPercent"`
+	NoisePercent            float64            `json:"noisePercent"`
+
+	// cpu.shares and CFS quotas (-1 = no quota) by pod, enforced instead of
+	// the solver's when usePresetShares is set
+	UsePresetShares  bool             `json:"usePresetShares"`
+	PresetCPUShares  map[string]int64 `json:"presetCPUShares"`
+	PresetCPUQuotas  map[string]int64 `json:"presetCPUQuotas"`
+	DefaultLBWeights string           `json:"defaultLBWeights"`
+
+	// damping of the LB weights (see Damping.go): changes below the
+	// threshold are ignored, no weight moves more than the max step in a
+	// round and a new target is reached in the interpolation rounds
+	LBWeightThreshold           float64 `json:"lbWeightThreshold"`
+	LBWeightMaxStep             float64 `json:"lbWeightMaxStep"`
+	LBWeightInterpolationRounds int     `json:"lbWeightInterpolationRounds"`
+
+	// JSON config file of the candidate policy of shadow mode, read over
+	// this one (see Shadow.go; "" = no shadow mode)
+	ShadowConfig string `json:"shadowConfig"`
+
+	NodeCallTimeoutMs      int `json:"nodeCallTimeoutMs"`
+	NodeStartupTimeoutMs   int `json:"nodeStartupTimeoutMs"`
+	TopologyResyncPeriodMs int `json:"topologyResyncPeriodMs"`
+}
+
+// config is the configuration of this run, set by main before anything else
+// starts.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Enforcement:                 ENFORCEMENT,
+		TenantLabel:                 TENANT_LABEL,
+		Kubeconfig:                  KUBECONFIG,
+		Solver:                      SOLVER,
+		SolverURL:                   GUROBI_SERVER_URL,
+		SolverTimeoutMs:             SOLVER_TIMEOUT_MS,
+		Objective:                   OBJECTIVE,
+		NodeNetworkBytesPerSec:      NODE_NETWORK_BYTES_PER_SEC,
+		ListenAddress:               LISTEN_ADDRESS,
+		LogFile:                     LOG_FILE,
+		RunDurationMs:               RUN_DURATION_MS,
+		RoundDurationMs:             DURATION_FOR_ONE_ROUND_MS,
+		CPUUtilizationWindowMs:      CPU_UTILIZATION_WINDOW_MS,
+		RollingAverageRounds:        ROUNDS_FOR_ROLLING_AVG_OF_CPU_UTILS,
+		Estimator:                   ESTIMATOR,
+		TenantEstimators:            make(map[string]string),
+		EWMAHalfLifeRounds:          EWMA_HALF_LIFE_ROUNDS,
+		EstimatorPercentile:         ESTIMATOR_PERCENTILE,
+		HoltAlpha:                   HOLT_ALPHA,
+		HoltBeta:                    HOLT_BETA,
+		OverheadPercent:             OVERHEAD,
+		TenantOverheadPercent:       make(map[string]float64),
+		PodQuotaOverheadPercent:     POD_QUOTA_OVERHEAD,
+		NoisePercent:                NOISE,
+		UsePresetShares:             USE_PRESET_SHARES,
+		PresetCPUShares:             make(map[string]int64),
+		PresetCPUQuotas:             make(map[string]int64),
+		DefaultLBWeights:            DEFAULT_LB_WEIGHTS,
+		LBWeightThreshold:           LB_WEIGHT_THRESHOLD,
+		LBWeightMaxStep:             LB_WEIGHT_MAX_STEP,
+		LBWeightInterpolationRounds: LB_WEIGHT_INTERPOLATION_ROUNDS,
+		ShadowConfig:                SHADOW_CONFIG,
+		NodeCallTimeoutMs:           NODE_CALL_TIMEOUT_MS,
+		NodeStartupTimeoutMs:        NODE_STARTUP_TIMEOUT_MS,
+		TopologyResyncPeriodMs:      TOPOLOGY_RESYNC_PERIOD_MS,
+	}
+}
+
+// registerFlags defines a flag for every field of c on fs, with the value of
+// the field as the default.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Enforcement, "enforcement", c.Enforcement, fmt.Sprintf(
+		"enforcement mechanism, one of %s or several joined by \"+\"",
+		strings.Join(EnforcerNames(), ", ")))
+	fs.StringVar(&c.TenantLabel, "tenant-label", c.TenantLabel,
+		"label or annotation naming the tenant of a pod; "+
+			"pods without it are background load")
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig,
+		"kubeconfig file of the cluster (\"\" = ~/.kube/config if there is "+
+			"one, else the in-cluster configuration)")
+	fs.StringVar(&c.Solver, "solver", c.Solver, "solver, GO or GUROBI")
+	fs.StringVar(&c.SolverURL, "solver-url", c.SolverURL,
+		"URL of the Gurobi server (solver GUROBI)")
+	fs.IntVar(&c.SolverTimeoutMs, "solver-timeout-ms", c.SolverTimeoutMs,
+		"deadline of a solve, after which the heuristic allocator takes over")
+	fs.StringVar(&c.Objective, "objective", c.Objective, fmt.Sprintf(
+		"fairness objective of the allocation, one of %s (GUROBI only has "+
+			"MIN_MAX_SPARE)", strings.Join(ObjectiveNames(), ", ")))
+	fs.Float64Var(&c.NodeNetworkBytesPerSec, "node-network-bytes-per-sec",
+		c.NodeNetworkBytesPerSec,
+		"network bandwidth of every node in bytes per second, received and "+
+			"sent (0 = not constrained)")
+	fs.StringVar(&c.ListenAddress, "listen-address", c.ListenAddress,
+		"address of the HTTP server serving /metrics and /admin")
+	fs.StringVar(&c.LogFile, "log-file", c.LogFile,
+		"file the rounds are logged to (\"\" = stdout)")
+	fs.IntVar(&c.RunDurationMs, "run-duration-ms", c.RunDurationMs,
+		"exit after this many ms (0 = run forever)")
+	fs.IntVar(&c.RoundDurationMs, "round-duration-ms", c.RoundDurationMs,
+		"duration of one optimization round")
+	fs.IntVar(&c.CPUUtilizationWindowMs, "cpu-utilization-window-ms",
+		c.CPUUtilizationWindowMs,
+		"window of the CPU Utilizations pushed by the host agents (0 = one round)")
+	fs.Var(float64ListFlag{&c.CPUUtilizationPercentiles},
+		"cpu-utilization-percentiles",
+		"percentiles of the CPU Utilizations within the window pushed by "+
+			"the host agents, e.g. \"50,90,99\" (SAMPLED_PERCENTILE)")
+	fs.Var(intListFlag{&c.CPUUtilizationWindowsMs},
+		"cpu-utilization-windows-ms",
+		"more windows of the CPU Utilizations pushed by the host agents, "+
+			"e.g. \"1000,5000,30000\" (WINDOW_MAX)")
+	fs.IntVar(&c.RollingAverageRounds, "rolling-average-rounds",
+		c.RollingAverageRounds,
+		"rounds in the window of the MEAN, MAX and PERCENTILE estimators")
+	fs.StringVar(&c.Estimator, "estimator", c.Estimator, fmt.Sprintf(
+		"load estimator of the tenants, one of %s",
+		strings.Join(EstimatorNames(), ", ")))
+	fs.Var(tenantEstimatorsFlag{&c.TenantEstimators}, "tenant-estimators",
+		"load estimators of single tenants, e.g. \"frontend=EWMA,profile=HOLT\"")
+	fs.Float64Var(&c.EWMAHalfLifeRounds, "ewma-half-life-rounds",
+		c.EWMAHalfLifeRounds, "rounds after which a load weighs half (EWMA)")
+	fs.Float64Var(&c.EstimatorPercentile, "estimator-percentile",
+		c.EstimatorPercentile, "percentile of the loads (PERCENTILE)")
+	fs.Float64Var(&c.HoltAlpha, "holt-alpha", c.HoltAlpha,
+		"smoothing of the level, in (0, 1] (HOLT)")
+	fs.Float64Var(&c.HoltBeta, "holt-beta", c.HoltBeta,
+		"smoothing of the trend, in (0, 1] (HOLT)")
+	fs.Float64Var(&c.OverheadPercent, "overhead", c.OverheadPercent,
+		"CPU (percent of one core) added to the loads for shares and quotas")
+	fs.Var(mapFlag[float64]{&c.TenantOverheadPercent, parseFloat64},
+		"tenant-overhead",
+		"overheads of single tenants, e.g. \"frontend=10,profile=5\"")
+	fs.Float64Var(&c.PodQuotaOverheadPercent, "pod-quota-overhead",
+		c.PodQuotaOverheadPercent,
+		"percent of the node's quota added to every pod's quota")
+	fs.Float64Var(&c.NoisePercent, "noise", c.NoisePercent,
+		"loads below this (percent of one core) are taken as 0")
+	fs.BoolVar(&c.UsePresetShares, "use-preset-shares", c.UsePresetShares,
+		"enforce the preset shares and quotas instead of the solver's")
+	fs.Var(mapFlag[int64]{&c.PresetCPUShares, parseInt64},
+		"preset-cpu-shares", "preset cpu.shares by pod, e.g. \"app1-node2=512\"")
+	fs.Var(mapFlag[int64]{&c.PresetCPUQuotas, parseInt64},
+		"preset-cpu-quotas",
+		"preset CFS quotas by pod (-1 = no quota), e.g. \"app1-node2=200000\"")
+	fs.StringVar(&c.DefaultLBWeights, "default-lb-weights", c.DefaultLBWeights,
+		"LB weights set before the first round (\"\" = leave as is)")
+	fs.Float64Var(&c.LBWeightThreshold, "lb-weight-threshold",
+		c.LBWeightThreshold,
+		"LB weight changes below this (of every weight) are ignored")
+	fs.Float64Var(&c.LBWeightMaxStep, "lb-weight-max-step", c.LBWeightMaxStep,
+		"most an LB weight moves in a round (100 = no limit)")
+	fs.IntVar(&c.LBWeightInterpolationRounds, "lb-weight-interpolation-rounds",
+		c.LBWeightInterpolationRounds,
+		"rounds to reach new LB weights in (1 = at once)")
+	fs.StringVar(&c.ShadowConfig, "shadow-config", c.ShadowConfig,
+		"JSON config file of a candidate policy to run in shadow mode "+
+			"(\"\" = none)")
+	fs.IntVar(&c.NodeCallTimeoutMs, "node-call-timeout-ms",
+		c.NodeCallTimeoutMs, "timeout of the calls to the host agents")
+	fs.IntVar(&c.NodeStartupTimeoutMs, "node-startup-timeout-ms",
+		c.NodeStartupTimeoutMs, "how long to wait for the host agents at startup")
+	fs.IntVar(&c.TopologyResyncPeriodMs, "topology-resync-period-ms",
+		c.TopologyResyncPeriodMs, "how often the informers re-list")
+}
+
+// tenantEstimatorsFlag is -tenant-estimators, the estimators of single
+// tenants as comma separated tenant=ESTIMATOR pairs.
+type tenantEstimatorsFlag struct {
+	estimators *map[string]string
+}
+
+func (f tenantEstimatorsFlag) String() string {
+	if f.estimators == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f.estimators))
+	for tenant, name := range *f.estimators {
+		pairs = append(pairs, tenant+"="+name)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f tenantEstimatorsFlag) Set(value string) error {
+	estimators := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		tenant, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not tenant=ESTIMATOR", pair)
+		}
+		estimators[strings.TrimSpace(tenant)] = strings.TrimSpace(name)
+	}
+	*f.estimators = estimators
+	return nil
+}
+
+// mapFlag is a map from tenant or pod names to values, as comma separated
+// name=value pairs.
+type mapFlag[V any] struct {
+	values *map[string]V
+	parse  func(string) (V, error)
+}
+
+func (f mapFlag[V]) String() string {
+	if f.values == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f.values))
+	for name, value := range *f.values {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f mapFlag[V]) Set(value string) error {
+	values := make(map[string]V)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, field, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not name=value", pair)
+		}
+		v, err := f.parse(strings.TrimSpace(field))
+		if err != nil {
+			return err
+		}
+		values[strings.TrimSpace(name)] = v
+	}
+	*f.values = values
+	return nil
+}
+
+func parseFloat64(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseInt64(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// float64ListFlag is a comma separated list of numbers.
+type float64ListFlag struct {
+	values *[]float64
+}
+
+func (f float64ListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	values := make([]string, len(*f.values))
+	for i, value := range *f.values {
+		values[i] = strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return strings.Join(values, ",")
+}
+
+func (f float64ListFlag) Set(value string) error {
+	values := make([]float64, 0)
+	for _, field := range strings.Split(value, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	*f.values = values
+	return nil
+}
+
+// intListFlag is a comma separated list of integers.
+type intListFlag struct {
+	values *[]int
+}
+
+func (f intListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	values := make([]string, len(*f.values))
+	for i, value := range *f.values {
+		values[i] = strconv.Itoa(value)
+	}
+	return strings.Join(values, ",")
+}
+
+func (f intListFlag) Set(value string) error {
+	values := make([]int, 0)
+	for _, field := range strings.Split(value, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	*f.values = values
+	return nil
+}
+
+// loadConfig returns the configuration from the defaults, the config file
+// named by -config (if any) and the flags in args, in that order. fs may
+// already hold other flags of the command.
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+
+	c := defaultConfig()
+
+	configFile := fs.String("config", "", "JSON config file")
+	c.registerFlags(fs)
+	fs.Parse(args)
+
+	if *configFile != "" {
+		// the flags set on the command line win over the config file
+		setFlags := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = f.Value.String()
+		})
+
+		err := c.readFile(*configFile)
+		if err != nil {
+			return c, err
+		}
+
+		for name, value := range setFlags {
+			fs.Set(name, value)
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// readFile sets the fields in the JSON config file path.
+func (c *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(c)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// usesEstimator returns whether the estimator name is the estimator of any
+// tenant.
+func (c *Config) usesEstimator(name string) bool {
+	if c.Estimator == name {
+		return true
+	}
+	for _, tenantEstimator := range c.TenantEstimators {
+		if tenantEstimator == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns all the invalid values of c.
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for _, name := range strings.Split(c.Enforcement, "+") {
+		if _, ok := enforcerFactories[strings.TrimSpace(name)]; !ok {
+			invalid("invalid enforcement %q (one of %s)",
+				name, strings.Join(EnforcerNames(), ", "))
+		}
+	}
+	if c.TenantLabel == "" {
+		invalid("tenantLabel is empty")
+	}
+
+	switch c.Solver {
+	case "GO":
+	case "GUROBI":
+		u, err := url.Parse(c.SolverURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("invalid solverURL %q", c.SolverURL)
+		}
+	default:
+		invalid("invalid solver %q (GO or GUROBI)", c.Solver)
+	}
+
+	if c.SolverTimeoutMs <= 0 {
+		invalid("solverTimeoutMs must be positive")
+	}
+	if _, ok := objectives[c.Objective]; !ok {
+		invalid("invalid objective %q (one of %s)",
+			c.Objective, strings.Join(ObjectiveNames(), ", "))
+	} else if c.Solver == "GUROBI" && c.Objective != OBJECTIVE {
+		invalid("objective %s needs solver GO (the Gurobi server only has %s)",
+			c.Objective, OBJECTIVE)
+	}
+
+	if c.NodeNetworkBytesPerSec < 0 {
+		invalid("nodeNetworkBytesPerSec is negative")
+	}
+
+	if c.ListenAddress == "" {
+		invalid("listenAddress is empty")
+	}
+	if c.RunDurationMs < 0 {
+		invalid("runDurationMs is negative")
+	}
+	if c.RoundDurationMs <= 0 {
+		invalid("roundDurationMs must be positive")
+	}
+	if c.CPUUtilizationWindowMs < 0 {
+		invalid("cpuUtilizationWindowMs is negative")
+	}
+	for _, p := range c.CPUUtilizationPercentiles {
+		if p < 0 || p > 100 {
+			invalid("cpuUtilizationPercentiles must be in [0, 100]")
+			break
+		}
+	}
+	for _, windowMs := range c.CPUUtilizationWindowsMs {
+		if windowMs <= 0 {
+			invalid("cpuUtilizationWindowsMs must be positive")
+			break
+		}
+	}
+	if c.RollingAverageRounds < 1 {
+		invalid("rollingAverageRounds must be at least 1")
+	}
+	if _, ok := estimatorFactories[c.Estimator]; !ok {
+		invalid("invalid estimator %q (one of %s)",
+			c.Estimator, strings.Join(EstimatorNames(), ", "))
+	}
+	for tenant, name := range c.TenantEstimators {
+		if _, ok := estimatorFactories[name]; !ok {
+			invalid("invalid estimator %q of tenant %s (one of %s)",
+				name, tenant, strings.Join(EstimatorNames(), ", "))
+		}
+	}
+	if c.EWMAHalfLifeRounds <= 0 {
+		invalid("ewmaHalfLifeRounds must be positive")
+	}
+	if c.EstimatorPercentile < 0 || c.EstimatorPercentile > 100 {
+		invalid("estimatorPercentile must be in [0, 100]")
+	}
+	if c.usesEstimator("SAMPLED_PERCENTILE") &&
+		!slices.Contains(c.CPUUtilizationPercentiles, c.EstimatorPercentile) {
+		invalid("estimator SAMPLED_PERCENTILE needs estimatorPercentile %v "+
+			"in cpuUtilizationPercentiles", c.EstimatorPercentile)
+	}
+	if c.usesEstimator("WINDOW_MAX") && len(c.CPUUtilizationWindowsMs) == 0 {
+		invalid("estimator WINDOW_MAX needs cpuUtilizationWindowsMs")
+	}
+	if c.HoltAlpha <= 0 || c.HoltAlpha > 1 || c.HoltBeta <= 0 || c.HoltBeta > 1 {
+		invalid("holtAlpha and holtBeta must be in (0, 1]")
+	}
+	if c.OverheadPercent < 0 || c.PodQuotaOverheadPercent < 0 ||
+		c.NoisePercent < 0 {
+		invalid("overheadPercent, podQuotaOverheadPercent and noisePercent " +
+			"must not be negative")
+	}
+	for tenant, overhead := range c.TenantOverheadPercent {
+		if overhead < 0 {
+			invalid("tenantOverheadPercent of tenant %s must not be negative",
+				tenant)
+		}
+	}
+	if c.UsePresetShares &&
+		len(c.PresetCPUShares) == 0 && len(c.PresetCPUQuotas) == 0 {
+		invalid("usePresetShares needs presetCPUShares or presetCPUQuotas")
+	}
+	for pod, shares := range c.PresetCPUShares {
+		if shares < 0 {
+			invalid("presetCPUShares of pod %s must not be negative", pod)
+		}
+	}
+	for pod, quota := range c.PresetCPUQuotas {
+		if quota != -1 && quota < MINIMUM_CPU_QUOTA {
+			invalid("presetCPUQuotas of pod %s must be -1 or at least %d",
+				pod, MINIMUM_CPU_QUOTA)
+		}
+	}
+	if _, err := protocol.ParseLBWeights(c.DefaultLBWeights); err != nil {
+		invalid("invalid defaultLBWeights: %w", err)
+	}
+	if c.LBWeightThreshold < 0 || c.LBWeightThreshold > 100 {
+		invalid("lbWeightThreshold must be in [0, 100]")
+	}
+	if c.LBWeightMaxStep <= 0 {
+		invalid("lbWeightMaxStep must be positive")
+	}
+	if c.LBWeightInterpolationRounds < 1 {
+		invalid("lbWeightInterpolationRounds must be at least 1")
+	}
+	if c.ShadowConfig != "" {
+		if _, err := c.shadowConfig(); err != nil {
+			invalid("invalid shadowConfig: %w", err)
+		}
+	}
+	if c.NodeCallTimeoutMs <= 0 || c.NodeStartupTimeoutMs <= 0 ||
+		c.TopologyResyncPeriodMs <= 0 {
+		invalid("nodeCallTimeoutMs, nodeStartupTimeoutMs and " +
+			"topologyResyncPeriodMs must be positive")
+	}
+
+	return errors.Join(errs...)
+}
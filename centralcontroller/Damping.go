@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+
+	"protocol"
+)
+
+/*
+weightDamper smooths the LB weights the solver asks for (the target) into the
+LB weights sent to the load balancers, so that the weights of a tenant do not
+flip between its replicas from one round to the next. Every round Damp moves
+the applied weights of each tenant toward its target:
+ 1. Changes of less than lbWeightThreshold (of every weight) are ignored, the
+    applied weights are kept
+ 2. A new target is reached in lbWeightInterpolationRounds rounds, moving the
+    same step every round (1 = at once)
+ 3. No weight moves more than lbWeightMaxStep in a round; the step of the
+    tenant is scaled down as a whole, so that its weights still add up to 100
+
+Tenants with pinned LB weights (see Admin.go) and tenants whose pods changed
+get their target at once.
+*/
+type weightDamper struct {
+	config Config
+	// applied weights, target and rounds left to reach it, by tenant
+	tenants map[string]*dampedWeights
+}
+
+// dampedWeights are the weights of the pods of a tenant, by pod.
+type dampedWeights struct {
+	applied    map[string]float64
+	target     map[string]float64
+	roundsLeft int
+}
+
+// Damp returns the LB weights to apply this round for the target LB weights
+// (both in the format of protocol.LBWeights).
+func (d *weightDamper) Damp(target string, pins map[string]Pin) string {
+	if d.tenants == nil {
+		d.tenants = make(map[string]*dampedWeights)
+	}
+
+	targetWeights := parseLBWeightStr(target)
+	for tenant := range d.tenants {
+		if _, ok := targetWeights[tenant]; !ok {
+			delete(d.tenants, tenant)
+		}
+	}
+
+	for tenant, weights := range targetWeights {
+		w, ok := d.tenants[tenant]
+		_, pinned := pins[tenant]
+		if !ok || pinned || !samePods(w.applied, weights) {
+			d.tenants[tenant] = &dampedWeights{
+				applied: weights, target: weights}
+			continue
+		}
+		w.damp(weights, d.config)
+	}
+
+	lbWeights := make(protocol.LBWeights, len(d.tenants))
+	for tenant, w := range d.tenants {
+		lbWeights[tenant] = w.applied
+	}
+	return lbWeights.String()
+}
+
+// damp moves the applied weights one round toward target, as configured by
+// c.
+func (w *dampedWeights) damp(target map[string]float64, c Config) {
+
+	// 1. ignore the changes below the threshold
+	if maxAbsDiff(target, w.applied) < c.LBWeightThreshold {
+		w.target = w.applied
+		w.roundsLeft = 0
+		return
+	}
+
+	// 2. a new target is reached in lbWeightInterpolationRounds rounds
+	if maxAbsDiff(target, w.target) > 0 || w.roundsLeft == 0 {
+		w.target = target
+		w.roundsLeft = c.LBWeightInterpolationRounds
+	}
+	step := make(map[string]float64, len(w.applied))
+	for podName := range w.applied {
+		step[podName] = (w.target[podName] - w.applied[podName]) /
+			float64(w.roundsLeft)
+	}
+	w.roundsLeft--
+
+	// 3. no weight moves more than lbWeightMaxStep
+	scale := 1.0
+	if maxStep := maxAbsDiff(step, make(map[string]float64)); maxStep >
+		c.LBWeightMaxStep {
+		scale = c.LBWeightMaxStep / maxStep
+		// the rest of the way is left for the rounds after
+		w.roundsLeft = max(w.roundsLeft, 1)
+	}
+
+	applied := make(map[string]float64, len(w.applied))
+	for podName := range w.applied {
+		applied[podName] = w.applied[podName] + step[podName]*scale
+	}
+	w.applied = applied
+}
+
+// maxAbsDiff returns the largest difference between the weights of the same
+// pod in a and b (missing = 0).
+func maxAbsDiff(a, b map[string]float64) float64 {
+	diff := 0.0
+	for podName := range a {
+		diff = math.Max(diff, math.Abs(a[podName]-b[podName]))
+	}
+	return diff
+}
+
+// samePods returns whether a and b have the weights of the same pods.
+func samePods(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for podName := range a {
+		if _, ok := b[podName]; !ok {
+			return false
+		}
+	}
+	return true
+}
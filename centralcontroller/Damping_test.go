@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"protocol"
+)
+
+// dampRound is a round of weightDamper.Damp: the target LB weights of the
+// pods of tenant p, whether p is pinned, and the weights applied.
+type dampRound struct {
+	target map[string]float64
+	pinned bool
+	want   map[string]float64
+}
+
+// lbWeightsOfP returns the LB weights string of tenant p's weights.
+func lbWeightsOfP(weights map[string]float64) string {
+	return protocol.LBWeights{"p": weights}.String()
+}
+
+func TestWeightDamper(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		maxStep   float64
+		rounds    int
+		damp      []dampRound
+	}{
+		{
+			name:      "below threshold",
+			threshold: 10,
+			maxStep:   100,
+			rounds:    1,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 50, "p-1": 50},
+					want: map[string]float64{"p-0": 50, "p-1": 50}},
+				// ignored: no weight moves 10
+				{target: map[string]float64{"p-0": 55, "p-1": 45},
+					want: map[string]float64{"p-0": 50, "p-1": 50}},
+				{target: map[string]float64{"p-0": 70, "p-1": 30},
+					want: map[string]float64{"p-0": 70, "p-1": 30}},
+			},
+		},
+		{
+			name:      "max step flip",
+			threshold: 0,
+			maxStep:   25,
+			rounds:    1,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 0, "p-1": 100},
+					want: map[string]float64{"p-0": 0, "p-1": 100}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 25, "p-1": 75}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 50, "p-1": 50}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 75, "p-1": 25}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 100, "p-1": 0}},
+			},
+		},
+		{
+			// the step of the tenant is scaled down as a whole
+			name:      "max step uneven",
+			threshold: 0,
+			maxStep:   20,
+			rounds:    1,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 0, "p-1": 50, "p-2": 50},
+					want: map[string]float64{"p-0": 0, "p-1": 50, "p-2": 50}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0, "p-2": 0},
+					want: map[string]float64{"p-0": 20, "p-1": 40, "p-2": 40}},
+			},
+		},
+		{
+			name:      "interpolation",
+			threshold: 0,
+			maxStep:   100,
+			rounds:    4,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 0, "p-1": 100},
+					want: map[string]float64{"p-0": 0, "p-1": 100}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 25, "p-1": 75}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 50, "p-1": 50}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 75, "p-1": 25}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 100, "p-1": 0}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					want: map[string]float64{"p-0": 100, "p-1": 0}},
+				// a new target restarts the interpolation from the applied
+				// weights
+				{target: map[string]float64{"p-0": 20, "p-1": 80},
+					want: map[string]float64{"p-0": 80, "p-1": 20}},
+			},
+		},
+		{
+			// the pods changed: the target is applied at once
+			name:      "replica added and removed",
+			threshold: 0,
+			maxStep:   10,
+			rounds:    1,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 100},
+					want: map[string]float64{"p-0": 100}},
+				{target: map[string]float64{"p-0": 50, "p-1": 50},
+					want: map[string]float64{"p-0": 50, "p-1": 50}},
+				{target: map[string]float64{"p-0": 40, "p-1": 60},
+					want: map[string]float64{"p-0": 40, "p-1": 60}},
+				{target: map[string]float64{"p-1": 100},
+					want: map[string]float64{"p-1": 100}},
+			},
+		},
+		{
+			name:      "pinned",
+			threshold: 10,
+			maxStep:   10,
+			rounds:    4,
+			damp: []dampRound{
+				{target: map[string]float64{"p-0": 0, "p-1": 100},
+					want: map[string]float64{"p-0": 0, "p-1": 100}},
+				{target: map[string]float64{"p-0": 100, "p-1": 0},
+					pinned: true,
+					want:   map[string]float64{"p-0": 100, "p-1": 0}},
+				// damped again once unpinned
+				{target: map[string]float64{"p-0": 0, "p-1": 100},
+					want: map[string]float64{"p-0": 90, "p-1": 10}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testConfig()
+			c.LBWeightThreshold = test.threshold
+			c.LBWeightMaxStep = test.maxStep
+			c.LBWeightInterpolationRounds = test.rounds
+			damper := weightDamper{config: c}
+
+			for i, round := range test.damp {
+				pins := make(map[string]Pin)
+				if round.pinned {
+					pins["p"] = Pin{Tenant: "p", LBWeights: round.target}
+				}
+				applied, err := protocol.ParseLBWeights(
+					damper.Damp(lbWeightsOfP(round.target), pins))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(applied["p"]) != len(round.want) {
+					t.Errorf("round %d: weights %v, want %v",
+						i, applied["p"], round.want)
+				}
+				sum := 0.0
+				for pod, want := range round.want {
+					assertNear(t, fmt.Sprintf("round %d: weight of %s", i, pod),
+						applied["p"][pod], want)
+					sum += applied["p"][pod]
+				}
+				assertNear(t, fmt.Sprintf("round %d: sum of weights", i),
+					sum, 100)
+			}
+		})
+	}
+}
+
+func TestWeightDamperDropsMissingTenants(t *testing.T) {
+	c := testConfig()
+	c.LBWeightMaxStep = 10
+	damper := weightDamper{config: c}
+
+	damper.Damp("p:p-0=0.0,p-1=100.0 q:q-0=100.0", nil)
+	applied := damper.Damp("q:q-0=100.0", nil)
+	if applied != "q:q-0=100.000000" {
+		t.Errorf("applied = %q, want only q", applied)
+	}
+
+	// p is new again: its target is applied at once
+	applied = damper.Damp("p:p-0=100.0,p-1=0.0", nil)
+	if applied != "p:p-0=100.000000,p-1=0.000000" {
+		t.Errorf("applied = %q, want the target of p", applied)
+	}
+}
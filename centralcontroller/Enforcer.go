@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Enforcer is one enforcement mechanism of the CC. Every round runEnforcer:
+1. Collects the CPU Utilizations pushed by the host agents
+2. Lets the enforcer decide what to enforce (Decide)
+3. Logs the CPU Utilizations and the decision (Log)
+4. Lets the enforcer send the decision to the host agents (Apply)
+
+Enforcers are registered by name with RegisterEnforcer and picked at runtime
+with NewEnforcer, which configures them with the Config of a policy (the
+active one, or a candidate in shadow mode, see Shadow.go). Names can be combined with "+" (e.g. "LB+CPU_QUOTA") to
+run several enforcers every round.
+*/
+type Enforcer interface {
+	// SetDefaults applies the default values before the first round
+	SetDefaults(nodes []Node)
+	// Decide computes the values to enforce this round
+	Decide(round Round)
+	// Log adds the values decided this round to the log line
+	Log(logFileFormat *LogFileFormat)
+	// Apply sends the values decided this round to the host agents
+	Apply(round Round)
+}
+
+// Round is what the host agents reported in one round.
+type Round struct {
+	// the nodes of the round, see UtilizationFeed.NextRound
+	Nodes []Node
+	// CPU Utilizations of each node (nil for the nodes that did not report)
+	NodeCPUUtilizations []map[string]float64
+	// percentiles and other windows of the CPU Utilizations of the pods of
+	// each node (see Estimator.go)
+	NodeCPUStatistics []map[string]CPUStatistics
+	// memory and network usage of the pods of each node (see Resources.go)
+	NodeResourceUsages []map[string]ResourceUsages
+	// CPU Utilization of each node outside the pods
+	NodeUnmanagedUtilizations []float64
+	// TenantPolicies of the round by tenant, see TenantPolicy.go
+	TenantPolicies map[string]TenantPolicy
+	// Pins of the round by tenant, see Admin.go
+	Pins map[string]Pin
+}
+
+type EnforcerFactory func(solver Solver, c Config) Enforcer
+
+var enforcerFactories = make(map[string]EnforcerFactory)
+
+func RegisterEnforcer(name string, factory EnforcerFactory) {
+	if _, ok := enforcerFactories[name]; ok {
+		panic("Enforcer registered twice: " + name)
+	}
+	enforcerFactories[name] = factory
+}
+
+// EnforcerNames returns the names of the registered enforcers
+func EnforcerNames() []string {
+	names := make([]string, 0, len(enforcerFactories))
+	for name := range enforcerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEnforcer returns the enforcer registered as name, or the enforcers
+// registered as the "+" separated names run one after the other.
+func NewEnforcer(name string, solver Solver, c Config) (Enforcer, error) {
+	chain := make(enforcerChain, 0)
+	for _, part := range strings.Split(name, "+") {
+		factory, ok := enforcerFactories[strings.TrimSpace(part)]
+		if !ok {
+			return nil, fmt.Errorf("invalid enforcement type %q (one of %s)",
+				part, strings.Join(EnforcerNames(), ", "))
+		}
+		chain = append(chain, factory(solver, c))
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+func init() {
+	RegisterEnforcer("NONE", func(solver Solver, c Config) Enforcer {
+		return enforcerChain{}
+	})
+	RegisterEnforcer("LB", func(solver Solver, c Config) Enforcer {
+		return &lbEnforcer{solver: solver,
+			loadEstimators: NewLoadEstimators(c), damper: weightDamper{config: c}}
+	})
+	RegisterEnforcer("CPU_SHARE", func(solver Solver, c Config) Enforcer {
+		return &cpuShareEnforcer{solver: solver,
+			loadEstimators: NewLoadEstimators(c)}
+	})
+	RegisterEnforcer("CPU_QUOTA", func(solver Solver, c Config) Enforcer {
+		return &cpuQuotaEnforcer{solver: solver,
+			loadEstimators: NewLoadEstimators(c)}
+	})
+	RegisterEnforcer("BOTH", func(solver Solver, c Config) Enforcer {
+		return enforcerChain{
+			&cpuQuotaEnforcer{solver: solver,
+				loadEstimators: NewLoadEstimators(c)},
+			&cpuShareEnforcer{solver: solver,
+				loadEstimators: NewLoadEstimators(c)}}
+	})
+}
+
+// runEnforcer runs the rounds until ctx is done.
+func runEnforcer(ctx context.Context, cpuLogFile *LogFile,
+	feed *UtilizationFeed, tenantPolicies *TenantPolicies, enforcer Enforcer,
+	shadow *shadowPolicy) {
+
+	// Repeat the following (until ctx is done):
+	// - Wait for the CPU Utilizations pushed by the host agents
+	// - Decide what to enforce
+	// - Send the decision to the host agents to be applied
+	for {
+		roundStart := time.Now()
+
+		// - Wait for the CPU Utilizations pushed by the host agents
+		nodes, nodeCPUUtilizations, nodeCPUStatistics, nodeResourceUsages,
+			nodeUnmanagedUtilizations := feed.NextRound(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		round := Round{nodes, nodeCPUUtilizations, nodeCPUStatistics,
+			nodeResourceUsages, nodeUnmanagedUtilizations,
+			tenantPolicies.Policies(), admin.Pins()}
+
+		// - Decide what to enforce (see Shadow.go for the candidate)
+		shadowLog := decideRound(round, enforcer, shadow)
+
+		// log the CPU Utilizations and the decision
+		cpuLogFile.Writeln(getLogFileFormat(
+			time.Now(), round, enforcer, shadowLog))
+
+		// - Send the decision to the host agents to be applied
+		// (unless paused from the admin API)
+		if admin.IsPaused() {
+			slog.Info("Enforcement is paused, not applying the decision")
+		} else {
+			enforcer.Apply(round)
+		}
+
+		roundDurationMetric.Observe(time.Since(roundStart).Seconds())
+	}
+}
+
+// applyToHealthyNodes runs apply on every healthy node and logs the nodes it
+// failed on.
+func applyToHealthyNodes(
+	nodes []Node, what string, apply func(i int, node *Node) error) {
+
+	for i := range nodes {
+		if !nodes[i].IsHealthy() {
+			continue
+		}
+		err := apply(i, &nodes[i])
+		if err != nil {
+			slog.Warn("Failed to apply " + what + " on node: " + nodes[i].IP)
+		}
+	}
+}
+
+// ================================ Enforcers ================================
+
+// enforcerChain runs its enforcers one after the other (none = NONE).
+type enforcerChain []Enforcer
+
+func (c enforcerChain) SetDefaults(nodes []Node) {
+	for _, enforcer := range c {
+		enforcer.SetDefaults(nodes)
+	}
+}
+
+func (c enforcerChain) Decide(round Round) {
+	for _, enforcer := range c {
+		enforcer.Decide(round)
+	}
+}
+
+func (c enforcerChain) Log(logFileFormat *LogFileFormat) {
+	for _, enforcer := range c {
+		enforcer.Log(logFileFormat)
+	}
+}
+
+func (c enforcerChain) Apply(round Round) {
+	for _, enforcer := range c {
+		enforcer.Apply(round)
+	}
+}
+
+// lbEnforcer sets the weights of the load balancers from the solver, damped
+// by a weightDamper.
+type lbEnforcer struct {
+	solver         Solver
+	loadEstimators *LoadEstimators
+	damper         weightDamper
+	rawLBWeights   string
+	lbWeights      string
+	solvedBy       string
+}
+
+func (e *lbEnforcer) SetDefaults(nodes []Node) {
+	setDefaultLBWeights(nodes)
+}
+
+func (e *lbEnforcer) Decide(round Round) {
+	e.rawLBWeights, e.solvedBy, e.loadEstimators = getOptimalLBWeights(e.solver,
+		round.Nodes, round.NodeCPUUtilizations, round.NodeCPUStatistics,
+		round.NodeResourceUsages,
+		round.NodeUnmanagedUtilizations, round.TenantPolicies, round.Pins,
+		e.loadEstimators)
+	e.lbWeights = e.damper.Damp(e.rawLBWeights, round.Pins)
+}
+
+func (e *lbEnforcer) Log(logFileFormat *LogFileFormat) {
+	logFileFormat.RawLBWeights = parseLBWeightStr(e.rawLBWeights)
+	logFileFormat.LBWeights = parseLBWeightStr(e.lbWeights)
+	logFileFormat.SolvedBy["LB"] = e.solvedBy
+}
+
+func (e *lbEnforcer) Apply(round Round) {
+	applyToHealthyNodes(round.Nodes, "LB Weights",
+		func(i int, node *Node) error {
+			return node.ApplyLBWeights(e.lbWeights)
+		})
+}
+
+// cpuShareEnforcer sets the cpu.shares of the pods from the solver.
+type cpuShareEnforcer struct {
+	solver         Solver
+	loadEstimators *LoadEstimators
+	nodeCPUShares  []map[string]int64
+	solvedBy       string
+}
+
+func (e *cpuShareEnforcer) SetDefaults(nodes []Node) {
+	setDefaultCPUShares(nodes)
+}
+
+func (e *cpuShareEnforcer) Decide(round Round) {
+	e.nodeCPUShares, e.solvedBy, e.loadEstimators = getOptimalCPUShares(e.solver,
+		round.Nodes, round.NodeCPUUtilizations, round.NodeCPUStatistics,
+		round.NodeResourceUsages,
+		round.NodeUnmanagedUtilizations, round.TenantPolicies, round.Pins,
+		e.loadEstimators)
+}
+
+func (e *cpuShareEnforcer) Log(logFileFormat *LogFileFormat) {
+	for _, nodeCPUShare := range e.nodeCPUShares {
+		for podName, podShare := range nodeCPUShare {
+			logFileFormat.CPUShares[podName] = strconv.FormatInt(podShare, 10)
+		}
+	}
+	logFileFormat.SolvedBy["CPU_SHARE"] = e.solvedBy
+}
+
+func (e *cpuShareEnforcer) Apply(round Round) {
+	if e.nodeCPUShares == nil {
+		slog.Warn("Failed to get optimal CPU shares")
+		return
+	}
+	applyToHealthyNodes(round.Nodes, "CPU shares",
+		func(i int, node *Node) error {
+			if i >= len(e.nodeCPUShares) || e.nodeCPUShares[i] == nil {
+				return fmt.Errorf("no CPU shares for node %d", i)
+			}
+			return node.ApplyCPUShares(e.nodeCPUShares[i])
+		})
+}
+
+// cpuQuotaEnforcer sets the cpu.cfs_quota_us of the pods from the solver.
+type cpuQuotaEnforcer struct {
+	solver         Solver
+	loadEstimators *LoadEstimators
+	nodeCPUQuotas  []map[string]int64
+	solvedBy       string
+}
+
+func (e *cpuQuotaEnforcer) SetDefaults(nodes []Node) {
+	setDefaultCPUQuotas(nodes)
+}
+
+func (e *cpuQuotaEnforcer) Decide(round Round) {
+	e.nodeCPUQuotas, e.solvedBy, e.loadEstimators = getOptimalCPUQuotas(e.solver,
+		round.Nodes, round.NodeCPUUtilizations, round.NodeCPUStatistics,
+		round.NodeResourceUsages,
+		round.NodeUnmanagedUtilizations, round.TenantPolicies, round.Pins,
+		e.loadEstimators)
+}
+
+func (e *cpuQuotaEnforcer) Log(logFileFormat *LogFileFormat) {
+	for _, nodeCPUQuota := range e.nodeCPUQuotas {
+		for podName, podQuota := range nodeCPUQuota {
+			logFileFormat.CPUQuotas[podName] = strconv.FormatInt(podQuota, 10)
+		}
+	}
+	logFileFormat.SolvedBy["CPU_QUOTA"] = e.solvedBy
+}
+
+func (e *cpuQuotaEnforcer) Apply(round Round) {
+	if e.nodeCPUQuotas == nil {
+		slog.Warn("Failed to get optimal CPU Quotas")
+		return
+	}
+	applyToHealthyNodes(round.Nodes, "CPU Quotas",
+		func(i int, node *Node) error {
+			if i >= len(e.nodeCPUQuotas) || e.nodeCPUQuotas[i] == nil {
+				return fmt.Errorf("no CPU Quotas for node %d", i)
+			}
+			return node.ApplyCPUQuotas(e.nodeCPUQuotas[i])
+		})
+}
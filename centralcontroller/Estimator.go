@@ -0,0 +1,474 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"protocol"
+)
+
+/*
+Estimator estimates the load of a tenant from the loads it had in the past
+rounds. The estimate, not the load of the last round, is what the solver
+balances. Every round getLoadEstimates:
+1. Adds the load of every tenant to its estimator (Add)
+2. Returns the estimates of all tenants (Estimate)
+
+Estimators are registered by name with RegisterEstimator and picked per
+tenant with -tenant-estimators (the others use -estimator):
+
+	MEAN        mean of the last rollingAverageRounds loads
+	EWMA        exponentially weighted mean, halving the weight of a load
+	            every ewmaHalfLifeRounds
+	MAX         highest of the last rollingAverageRounds loads
+	PERCENTILE  estimatorPercentile-th percentile of the last
+	            rollingAverageRounds loads
+	HOLT        Holt's linear trend forecast of the next load, smoothing the
+	            level with holtAlpha and the trend with holtBeta
+	WINDOW_MAX  highest of the load and of its averages over the
+	            cpuUtilizationWindowsMs of this round (e.g. 1s, 5s and 30s:
+	            follows a rise within 1s, holds a peak for 30s)
+	SAMPLED_PERCENTILE
+	            estimatorPercentile-th percentile of the load within the
+	            window of this round, as sampled by the host agents (one of
+	            cpuUtilizationPercentiles)
+
+WINDOW_MAX and SAMPLED_PERCENTILE are StatisticsEstimators: they get the
+CPUStatistics the host agents report besides the load, and fall back to the
+load where there are none (e.g. in replay or shadow mode).
+
+A tenant missing from a round adds a load of 0, and its estimator is dropped
+once it has been missing for rollingAverageRounds rounds.
+*/
+type Estimator interface {
+	// Add adds the load of one round
+	Add(load float64)
+	// Estimate returns the estimated load
+	Estimate() float64
+}
+
+// StatisticsEstimator is an Estimator that also uses the CPUStatistics of the
+// tenant. AddStatistics is called instead of Add when there is a load.
+type StatisticsEstimator interface {
+	Estimator
+	// AddStatistics adds the load of one round and its statistics
+	AddStatistics(load float64, statistics CPUStatistics)
+}
+
+/*
+CPUStatistics are the CPU Utilizations of a pod or tenant in a round besides
+its average over the window, if the host agents are asked for them:
+
+	Percentiles  percentile (cpuUtilizationPercentiles) -> percentile of
+	             the utilization between consecutive samples in the window
+	Windows      window (cpuUtilizationWindowsMs) -> average utilization
+	             over the window
+
+The statistics of a tenant are the sums of those of its pods. Its averages
+over the windows are exact, its percentiles are only approximations, exact
+when its pods peak together.
+*/
+type CPUStatistics struct {
+	Percentiles map[float64]float64
+	Windows     map[int]float64
+}
+
+type EstimatorFactory func(c Config) Estimator
+
+var estimatorFactories = make(map[string]EstimatorFactory)
+
+func RegisterEstimator(name string, factory EstimatorFactory) {
+	if _, ok := estimatorFactories[name]; ok {
+		panic("Estimator registered twice: " + name)
+	}
+	estimatorFactories[name] = factory
+}
+
+// EstimatorNames returns the names of the registered estimators
+func EstimatorNames() []string {
+	names := make([]string, 0, len(estimatorFactories))
+	for name := range estimatorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEstimator returns the estimator registered as name, configured by c.
+func NewEstimator(name string, c Config) (Estimator, error) {
+	factory, ok := estimatorFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid estimator %q (one of %s)",
+			name, strings.Join(EstimatorNames(), ", "))
+	}
+	return factory(c), nil
+}
+
+func init() {
+	RegisterEstimator("MEAN", func(c Config) Estimator {
+		return &meanEstimator{window: newLoadWindow(c.RollingAverageRounds)}
+	})
+	RegisterEstimator("EWMA", func(c Config) Estimator {
+		return &ewmaEstimator{alpha: 1 - math.Pow(0.5, 1/c.EWMAHalfLifeRounds)}
+	})
+	RegisterEstimator("MAX", func(c Config) Estimator {
+		return &percentileEstimator{
+			window: newLoadWindow(c.RollingAverageRounds), percentile: 100}
+	})
+	RegisterEstimator("PERCENTILE", func(c Config) Estimator {
+		return &percentileEstimator{
+			window:     newLoadWindow(c.RollingAverageRounds),
+			percentile: c.EstimatorPercentile}
+	})
+	RegisterEstimator("HOLT", func(c Config) Estimator {
+		return &holtEstimator{alpha: c.HoltAlpha, beta: c.HoltBeta}
+	})
+	RegisterEstimator("WINDOW_MAX", func(c Config) Estimator {
+		return &windowMaxEstimator{}
+	})
+	RegisterEstimator("SAMPLED_PERCENTILE", func(c Config) Estimator {
+		return &sampledPercentileEstimator{percentile: c.EstimatorPercentile}
+	})
+}
+
+// tenantEstimator is the estimator of one tenant and the rounds in a row
+// the tenant has been missing from.
+type tenantEstimator struct {
+	estimator    Estimator
+	missedRounds int
+}
+
+// LoadEstimators are the estimators of the tenants, configured by config.
+type LoadEstimators struct {
+	config  Config
+	tenants map[string]*tenantEstimator
+}
+
+func NewLoadEstimators(c Config) *LoadEstimators {
+	return &LoadEstimators{
+		config: c, tenants: make(map[string]*tenantEstimator)}
+}
+
+// estimatorName returns the name of the estimator of tenant.
+func (e *LoadEstimators) estimatorName(tenant string) string {
+	if name, ok := e.config.TenantEstimators[tenant]; ok {
+		return name
+	}
+	return e.config.Estimator
+}
+
+// getLoadEstimates adds the loads of this round, and their statistics (may be
+// nil), to the estimators of the tenants (creating the missing ones) and
+// returns the estimated loads. loadEstimators may be nil, for estimators
+// configured by config.
+func getLoadEstimates(
+	currentAppUtils map[string]float64,
+	tenantStatistics map[string]CPUStatistics,
+	loadEstimators *LoadEstimators) (map[string]float64, *LoadEstimators) {
+
+	if loadEstimators == nil {
+		loadEstimators = NewLoadEstimators(config)
+	}
+
+	// add the loads of this round
+	for tenant, util := range currentAppUtils {
+		e, ok := loadEstimators.tenants[tenant]
+		if !ok {
+			estimator, err := NewEstimator(
+				loadEstimators.estimatorName(tenant), loadEstimators.config)
+			check(err)
+			e = &tenantEstimator{estimator: estimator}
+			loadEstimators.tenants[tenant] = e
+		}
+		if estimator, ok := e.estimator.(StatisticsEstimator); ok {
+			estimator.AddStatistics(util, tenantStatistics[tenant])
+		} else {
+			e.estimator.Add(util)
+		}
+		e.missedRounds = 0
+	}
+
+	// the tenants missing from this round have no load
+	for tenant, e := range loadEstimators.tenants {
+		if _, ok := currentAppUtils[tenant]; ok {
+			continue
+		}
+		e.missedRounds++
+		if e.missedRounds >= loadEstimators.config.RollingAverageRounds {
+			delete(loadEstimators.tenants, tenant)
+			continue
+		}
+		e.estimator.Add(0)
+	}
+
+	// get estimated loads
+	estimatedAppUtils := make(map[string]float64, len(loadEstimators.tenants))
+	for tenant, e := range loadEstimators.tenants {
+		estimatedAppUtils[tenant] = e.estimator.Estimate()
+	}
+
+	return estimatedAppUtils, loadEstimators
+}
+
+// ================================ Estimators ================================
+
+// loadWindow holds the loads of the last size rounds.
+type loadWindow struct {
+	size  int
+	loads []float64
+}
+
+func newLoadWindow(size int) loadWindow {
+	return loadWindow{size: size, loads: make([]float64, 0, size)}
+}
+
+func (w *loadWindow) add(load float64) {
+	if len(w.loads) == w.size {
+		w.loads = w.loads[1:]
+	}
+	w.loads = append(w.loads, load)
+}
+
+// meanEstimator is the mean of the last loads (the rolling average).
+type meanEstimator struct {
+	window loadWindow
+}
+
+func (e *meanEstimator) Add(load float64) {
+	e.window.add(load)
+}
+
+func (e *meanEstimator) Estimate() float64 {
+	if len(e.window.loads) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, load := range e.window.loads {
+		sum += load
+	}
+	return sum / float64(len(e.window.loads))
+}
+
+// ewmaEstimator is the exponentially weighted moving average of the loads,
+// starting at the first load.
+type ewmaEstimator struct {
+	alpha   float64
+	value   float64
+	started bool
+}
+
+func (e *ewmaEstimator) Add(load float64) {
+	if !e.started {
+		e.value = load
+		e.started = true
+		return
+	}
+	e.value += e.alpha * (load - e.value)
+}
+
+func (e *ewmaEstimator) Estimate() float64 {
+	return e.value
+}
+
+// percentileEstimator is a percentile of the last loads, interpolated
+// between the closest ranks (100 = the highest load).
+type percentileEstimator struct {
+	window     loadWindow
+	percentile float64
+}
+
+func (e *percentileEstimator) Add(load float64) {
+	e.window.add(load)
+}
+
+func (e *percentileEstimator) Estimate() float64 {
+	if len(e.window.loads) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(e.window.loads))
+	copy(sorted, e.window.loads)
+	sort.Float64s(sorted)
+
+	rank := e.percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// holtEstimator is Holt's linear (double exponential) smoothing of the
+// loads, forecasting the load of the next round from the level and trend.
+type holtEstimator struct {
+	alpha  float64
+	beta   float64
+	level  float64
+	trend  float64
+	rounds int
+}
+
+func (e *holtEstimator) Add(load float64) {
+	e.rounds++
+	switch e.rounds {
+	case 1:
+		e.level = load
+		return
+	case 2:
+		e.trend = load - e.level
+		e.level = load
+		return
+	}
+	lastLevel := e.level
+	e.level = e.alpha*load + (1-e.alpha)*(e.level+e.trend)
+	e.trend = e.beta*(e.level-lastLevel) + (1-e.beta)*e.trend
+}
+
+func (e *holtEstimator) Estimate() float64 {
+	// a load is never negative, even when it is trending down
+	return math.Max(0, e.level+e.trend)
+}
+
+// windowMaxEstimator is the highest of the load and of its averages over the
+// other windows of the last round.
+type windowMaxEstimator struct {
+	value float64
+}
+
+func (e *windowMaxEstimator) Add(load float64) {
+	e.value = load
+}
+
+func (e *windowMaxEstimator) AddStatistics(
+	load float64, statistics CPUStatistics) {
+
+	e.value = load
+	for _, windowLoad := range statistics.Windows {
+		e.value = math.Max(e.value, windowLoad)
+	}
+}
+
+func (e *windowMaxEstimator) Estimate() float64 {
+	return e.value
+}
+
+// sampledPercentileEstimator is the percentile of the load within the window
+// of the last round, or the load if the host agents did not report it.
+type sampledPercentileEstimator struct {
+	percentile float64
+	value      float64
+}
+
+func (e *sampledPercentileEstimator) Add(load float64) {
+	e.value = load
+}
+
+func (e *sampledPercentileEstimator) AddStatistics(
+	load float64, statistics CPUStatistics) {
+
+	e.value = load
+	if value, ok := statistics.Percentiles[e.percentile]; ok {
+		e.value = value
+	}
+}
+
+func (e *sampledPercentileEstimator) Estimate() float64 {
+	return e.value
+}
+
+// getPerTenantCPUStatistics sums the CPUStatistics of the pods of each tenant,
+// like getPerTenantUtilizations does with the CPU Utilizations. A pod without
+// a statistic counts with its CPU Utilization. The statistics of a tenant
+// are shifted by the noise and overhead its load got: from the sum of the
+// CPU Utilizations of its pods to its load in effectiveAppUtils.
+func getPerTenantCPUStatistics(nodes []Node,
+	nodeCPUUtilizations []map[string]float64,
+	nodeCPUStatistics []map[string]CPUStatistics,
+	effectiveAppUtils map[string]float64) map[string]CPUStatistics {
+
+	if len(config.CPUUtilizationPercentiles) == 0 &&
+		len(config.CPUUtilizationWindowsMs) == 0 {
+		return nil
+	}
+
+	tenantStatistics := make(map[string]CPUStatistics)
+	tenantUtils := make(map[string]float64)
+	for i, cpuUtil := range nodeCPUUtilizations {
+		for podName, podUtil := range cpuUtil {
+			pod, ok := nodes[i].Pods[podName]
+			if !ok || pod.Tenant == "" {
+				continue
+			}
+			statistics, ok := tenantStatistics[pod.Tenant]
+			if !ok {
+				statistics = CPUStatistics{
+					Percentiles: make(map[float64]float64),
+					Windows:     make(map[int]float64),
+				}
+				tenantStatistics[pod.Tenant] = statistics
+			}
+			tenantUtils[pod.Tenant] += podUtil
+
+			var podStatistics CPUStatistics
+			if i < len(nodeCPUStatistics) {
+				podStatistics = nodeCPUStatistics[i][podName]
+			}
+			for _, p := range config.CPUUtilizationPercentiles {
+				value, ok := podStatistics.Percentiles[p]
+				if !ok {
+					value = podUtil
+				}
+				statistics.Percentiles[p] += value
+			}
+			for _, windowMs := range config.CPUUtilizationWindowsMs {
+				value, ok := podStatistics.Windows[windowMs]
+				if !ok {
+					value = podUtil
+				}
+				statistics.Windows[windowMs] += value
+			}
+		}
+	}
+
+	for tenant, statistics := range tenantStatistics {
+		shift := effectiveAppUtils[tenant] - tenantUtils[tenant]
+		for p, value := range statistics.Percentiles {
+			statistics.Percentiles[p] = math.Max(0, value+shift)
+		}
+		for windowMs, value := range statistics.Windows {
+			statistics.Windows[windowMs] = math.Max(0, value+shift)
+		}
+	}
+	return tenantStatistics
+}
+
+// getSampleCPUStatistics returns the CPUStatistics of the pods in a sample of
+// a host agent asked for percentiles, none for the pods it has none of.
+func getSampleCPUStatistics(sample *protocol.CPUUtilizationSample,
+	percentiles []float64) map[string]CPUStatistics {
+
+	podStatistics := make(map[string]CPUStatistics)
+	get := func(podName string) CPUStatistics {
+		statistics, ok := podStatistics[podName]
+		if !ok {
+			statistics = CPUStatistics{
+				Percentiles: make(map[float64]float64),
+				Windows:     make(map[int]float64),
+			}
+			podStatistics[podName] = statistics
+		}
+		return statistics
+	}
+	for podName, values := range sample.Percentiles {
+		for i, value := range values {
+			if i < len(percentiles) {
+				get(podName).Percentiles[percentiles[i]] = value
+			}
+		}
+	}
+	for windowMs, utilizations := range sample.WindowUtilizations {
+		for podName, utilization := range utilizations {
+			get(podName).Windows[int(windowMs)] = utilization
+		}
+	}
+	return podStatistics
+}
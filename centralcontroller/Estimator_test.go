@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// testConfig is the default configuration with a window of 10 rounds.
+func testConfig() Config {
+	c := defaultConfig()
+	c.RollingAverageRounds = 10
+	c.EWMAHalfLifeRounds = 2
+	c.EstimatorPercentile = 50
+	return c
+}
+
+// feed adds loads to the estimator and returns the estimate after each.
+func feed(t *testing.T, name string, loads []float64) []float64 {
+	t.Helper()
+	estimator, err := NewEstimator(name, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimates := make([]float64, len(loads))
+	for i, load := range loads {
+		estimator.Add(load)
+		estimates[i] = estimator.Estimate()
+	}
+	return estimates
+}
+
+// step is 10 rounds of 0 followed by 20 rounds of 100.
+func step() []float64 {
+	loads := make([]float64, 30)
+	for i := 10; i < len(loads); i++ {
+		loads[i] = 100
+	}
+	return loads
+}
+
+// ramp rises by 10 every round.
+func ramp() []float64 {
+	loads := make([]float64, 30)
+	for i := range loads {
+		loads[i] = float64(i * 10)
+	}
+	return loads
+}
+
+func assertNear(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-6 {
+		t.Errorf("%s = %f, want %f", what, got, want)
+	}
+}
+
+func TestEstimatorsStepResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		// estimates 1 and 5 rounds after the step, and at the end
+		afterOne, afterFive, settled float64
+	}{
+		{"MEAN", 10, 50, 100},
+		{"EWMA", 100 * (1 - math.Sqrt(0.5)), 100 * (1 - math.Pow(0.5, 2.5)), 100 * (1 - math.Pow(0.5, 10))},
+		{"MAX", 100, 100, 100},
+		{"PERCENTILE", 0, 50, 100},
+		{"HOLT", 65, 126.19728125, 100}, // overshoots before settling
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			estimates := feed(t, test.name, step())
+			assertNear(t, "estimate before the step", estimates[9], 0)
+			assertNear(t, "estimate 1 round after the step",
+				estimates[10], test.afterOne)
+			assertNear(t, "estimate 5 rounds after the step",
+				estimates[14], test.afterFive)
+			if math.Abs(estimates[29]-test.settled) > 0.5 {
+				t.Errorf("settled estimate = %f, want %f",
+					estimates[29], test.settled)
+			}
+		})
+	}
+}
+
+func TestEstimatorsRampResponse(t *testing.T) {
+	// how far behind the last load each estimator is once the window is
+	// full (negative = ahead)
+	tests := []struct {
+		name string
+		lag  float64
+	}{
+		{"MEAN", 45},
+		{"EWMA", 10 * math.Sqrt(0.5) / (1 - math.Sqrt(0.5))},
+		{"MAX", 0},
+		{"PERCENTILE", 45},
+		{"HOLT", -10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loads := ramp()
+			estimates := feed(t, test.name, loads)
+			last := len(loads) - 1
+			lag := loads[last] - estimates[last]
+			if math.Abs(lag-test.lag) > 0.01 {
+				t.Errorf("lag = %f, want %f", lag, test.lag)
+			}
+		})
+	}
+}
+
+func TestGetLoadEstimatesDropsMissingTenants(t *testing.T) {
+	config = testConfig()
+	defer func() { config = defaultConfig() }()
+	config.TenantEstimators = map[string]string{"frontend": "MAX"}
+
+	var estimators *LoadEstimators
+	estimates, estimators := getLoadEstimates(
+		map[string]float64{"frontend": 40, "profile": 20}, nil, estimators)
+	assertNear(t, "frontend", estimates["frontend"], 40)
+	assertNear(t, "profile", estimates["profile"], 20)
+
+	// profile goes missing: its load is 0 until its estimator is dropped
+	for round := 1; round < config.RollingAverageRounds; round++ {
+		estimates, estimators = getLoadEstimates(
+			map[string]float64{"frontend": 10}, nil, estimators)
+	}
+	// frontend has a MAX estimator, profile the default MEAN one
+	assertNear(t, "frontend", estimates["frontend"], 40)
+	assertNear(t, "profile", estimates["profile"], 20.0/10)
+
+	estimates, _ = getLoadEstimates(
+		map[string]float64{"frontend": 10}, nil, estimators)
+	if _, ok := estimates["profile"]; ok {
+		t.Errorf("profile still estimated after %d missing rounds",
+			config.RollingAverageRounds)
+	}
+}
+
+func TestStatisticsEstimators(t *testing.T) {
+	statistics := CPUStatistics{
+		Percentiles: map[float64]float64{50: 70, 90: 90},
+		Windows:     map[int]float64{1000: 60, 5000: 45, 30000: 80},
+	}
+	tests := []struct {
+		name string
+		// estimates with the statistics, and with the load only
+		withStatistics, withoutStatistics float64
+	}{
+		{"WINDOW_MAX", 80, 40},
+		{"SAMPLED_PERCENTILE", 70, 40},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			estimator, err := NewEstimator(test.name, testConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			statisticsEstimator, ok := estimator.(StatisticsEstimator)
+			if !ok {
+				t.Fatalf("%s is not a StatisticsEstimator", test.name)
+			}
+			statisticsEstimator.AddStatistics(40, statistics)
+			assertNear(t, "estimate with statistics",
+				estimator.Estimate(), test.withStatistics)
+			statisticsEstimator.AddStatistics(40, CPUStatistics{})
+			assertNear(t, "estimate without statistics",
+				estimator.Estimate(), test.withoutStatistics)
+		})
+	}
+}
+
+func TestGetPerTenantCPUStatistics(t *testing.T) {
+	config = testConfig()
+	defer func() { config = defaultConfig() }()
+	config.CPUUtilizationPercentiles = []float64{90}
+	config.CPUUtilizationWindowsMs = []int{1000}
+
+	nodes := []Node{{Name: "node1", Pods: map[string]Pod{
+		"frontend-0": {Name: "frontend-0", Tenant: "frontend"},
+		"frontend-1": {Name: "frontend-1", Tenant: "frontend"},
+		"consul-0":   {Name: "consul-0"},
+	}}}
+	nodeCPUUtilizations := []map[string]float64{
+		{"frontend-0": 10, "frontend-1": 20, "consul-0": 5}}
+	// frontend-1 has no statistics: its CPU Utilization counts instead
+	nodeCPUStatistics := []map[string]CPUStatistics{{
+		"frontend-0": {
+			Percentiles: map[float64]float64{90: 15},
+			Windows:     map[int]float64{1000: 12},
+		},
+		"consul-0": {Percentiles: map[float64]float64{90: 50}},
+	}}
+
+	// with an overhead of 5 on the load of 30
+	statistics := getPerTenantCPUStatistics(nodes, nodeCPUUtilizations,
+		nodeCPUStatistics, map[string]float64{"frontend": 35})
+	if len(statistics) != 1 {
+		t.Fatalf("statistics of %d tenants, want 1", len(statistics))
+	}
+	assertNear(t, "90th percentile",
+		statistics["frontend"].Percentiles[90], 15+20+5)
+	assertNear(t, "1s window", statistics["frontend"].Windows[1000], 12+20+5)
+}
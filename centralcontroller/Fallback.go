@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"math"
+	"sort"
+)
+
+const (
+	// bisection steps of the water level of a tenant's pods
+	FALLBACK_LEVEL_STEPS = 60
+
+	// which allocator a round's weights, shares or quotas come from
+	SOLVED_BY_PRIMARY  = "PRIMARY"
+	SOLVED_BY_FALLBACK = "FALLBACK"
+)
+
+/*
+FallbackSolver allocates the generic placement model (see Solver) with a
+heuristic instead of solving it, so that a round still gets an allocation when
+the primary solver fails, times out or finds no optimal solution (see
+getGenericWeights). It never fails, and ignores ctx as it takes no time. Its
+allocation is feasible but not optimal, so it returns SOLVER_STATUS_SUBOPTIMAL.
+
+What does FallbackSolver do:
+ 1. Water-fill the capacity of all the hosts over the tenants in proportion to
+    their entitlement (fshareload): each tenant gets the same multiple of its
+    entitlement, but no more than its load or what the hosts of its pods can
+    take (see getMaxTenantLoad). Capacity left after that goes to the tenants
+    still short of their load, evenly
+ 2. Water-fill the share of each tenant over its pods, giving load to the
+    pods on the hosts with the most spare capacity first, so that the spare
+    capacity is levelled as in the model. The tenants on the fewest hosts go
+    first, as they have the fewest places to go. No pod gets more than its
+    max load or its host's spare capacity, of CPU or of the resources the
+    tenant uses (see Resources.go)
+ 3. Give the share the hosts of a tenant could not take, as other tenants
+    filled them up first, to the tenants still short of their load, in the
+    same order
+*/
+type FallbackSolver struct{}
+
+func (s *FallbackSolver) Solve(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	GurobiGenericResponse, error) {
+
+	spare := make(map[string]float64, len(hosts))
+	spareResources := make(map[string]ResourceUsages, len(hosts))
+	totalCap := 0.0
+	for _, host := range hosts {
+		spare[host.Name] = host.Cap
+		spareResources[host.Name] = maps.Clone(host.Resources)
+		totalCap += host.Cap
+	}
+
+	// 1. share the capacity among the tenants, none getting more than the
+	// hosts of its pods can take
+	demands := make(map[string]float64, len(tenants))
+	entitlements := make(map[string]float64, len(tenants))
+	evenly := make(map[string]float64, len(tenants))
+	for _, tenant := range tenants {
+		demands[tenant.Name] = math.Min(math.Max(0, tenant.Load),
+			getMaxTenantLoad(tenant.Name, pods, spare, spareResources,
+				tenant.Resources))
+		entitlements[tenant.Name] = math.Max(0, tenant.FShareLoad)
+		evenly[tenant.Name] = 1
+	}
+	shortfalls := maps.Clone(demands)
+	shares := waterFill(demands, entitlements, totalCap)
+	leftCap := totalCap
+	for tenant, share := range shares {
+		demands[tenant] -= share
+		leftCap -= share
+	}
+	for tenant, share := range waterFill(demands, evenly, leftCap) {
+		shares[tenant] += share
+	}
+
+	// 2. share the share of each tenant among its pods
+	tenantPods := make(map[string][]PodJSON)
+	tenantHosts := make(map[string]map[string]bool)
+	for _, pod := range pods {
+		tenantPods[pod.Tenant] = append(tenantPods[pod.Tenant], pod)
+		if tenantHosts[pod.Tenant] == nil {
+			tenantHosts[pod.Tenant] = make(map[string]bool)
+		}
+		tenantHosts[pod.Tenant][pod.Host] = true
+	}
+	tenantNames := make([]string, 0, len(tenants))
+	intensities := make(map[string]ResourceUsages, len(tenants))
+	for _, tenant := range tenants {
+		tenantNames = append(tenantNames, tenant.Name)
+		intensities[tenant.Name] = tenant.Resources
+	}
+	sort.Slice(tenantNames, func(i, j int) bool {
+		hi := len(tenantHosts[tenantNames[i]])
+		hj := len(tenantHosts[tenantNames[j]])
+		if hi != hj {
+			return hi < hj
+		}
+		return tenantNames[i] < tenantNames[j]
+	})
+
+	result := zeroResult(pods)
+	place := func(tenant string, load float64) {
+		room := make(map[string]float64, len(tenantHosts[tenant]))
+		for host := range tenantHosts[tenant] {
+			room[host] = resourceRoom(spareResources[host], intensities[tenant])
+		}
+
+		podLoads := fillPods(load, tenantPods[tenant], spare, room)
+		for _, pod := range tenantPods[tenant] {
+			result[tenant][pod.Name] += podLoads[pod.Name]
+			shortfalls[tenant] -= podLoads[pod.Name]
+			for resource, capacity := range spareResources[pod.Host] {
+				spareResources[pod.Host][resource] = capacity -
+					podLoads[pod.Name]*intensities[tenant][resource]
+			}
+		}
+	}
+	for _, tenant := range tenantNames {
+		place(tenant, shares[tenant])
+	}
+
+	// 3. the share a tenant's hosts could not take, as other tenants filled
+	// them up, goes to the tenants still short of their load
+	for _, tenant := range tenantNames {
+		if shortfalls[tenant] > 0 {
+			place(tenant, shortfalls[tenant])
+		}
+	}
+
+	return GurobiGenericResponse{
+		Status: SOLVER_STATUS_SUBOPTIMAL,
+		Result: result,
+	}, nil
+}
+
+// waterFill shares capacity among demands in proportion to weights: every
+// key gets the same multiple of its weight, but no more than its demand.
+// Keys with no weight get nothing.
+func waterFill(demands map[string]float64, weights map[string]float64,
+	capacity float64) map[string]float64 {
+
+	keys := make([]string, 0, len(demands))
+	totalWeight := 0.0
+	for key := range demands {
+		if weights[key] > 0 {
+			keys = append(keys, key)
+			totalWeight += weights[key]
+		}
+	}
+	// the keys that are filled up first go first
+	sort.Slice(keys, func(i, j int) bool {
+		ri := demands[keys[i]] / weights[keys[i]]
+		rj := demands[keys[j]] / weights[keys[j]]
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	shares := make(map[string]float64, len(keys))
+	left := math.Max(0, capacity)
+	for i, key := range keys {
+		level := left / totalWeight
+		if demands[key] <= level*weights[key] {
+			shares[key] = demands[key]
+			left -= demands[key]
+			totalWeight -= weights[key]
+			continue
+		}
+		// nobody left is filled up, they all get the level
+		for _, rest := range keys[i:] {
+			shares[rest] = level * weights[rest]
+		}
+		break
+	}
+	return shares
+}
+
+// fillPods shares load among pods, levelling the spare capacity of their
+// hosts, and takes the load off spare. No host gets more than its room for
+// the pods' tenant. It returns the load of each pod.
+func fillPods(load float64, pods []PodJSON, spare map[string]float64,
+	room map[string]float64) map[string]float64 {
+
+	// the pods of the tenant on each host
+	hostPods := make(map[string][]PodJSON)
+	for _, pod := range pods {
+		hostPods[pod.Host] = append(hostPods[pod.Host], pod)
+	}
+	hostMaxLoad := make(map[string]float64, len(hostPods))
+	maxSpare := 0.0
+	for host, pods := range hostPods {
+		for _, pod := range pods {
+			if pod.MaxLoad <= 0 {
+				hostMaxLoad[host] = math.Inf(1)
+				break
+			}
+			hostMaxLoad[host] += pod.MaxLoad
+		}
+		if hostRoom, ok := room[host]; ok {
+			hostMaxLoad[host] = math.Min(hostMaxLoad[host], hostRoom)
+		}
+		maxSpare = math.Max(maxSpare, spare[host])
+	}
+
+	// the load each host takes if its spare capacity is brought down to level
+	hostLoads := func(level float64) (map[string]float64, float64) {
+		loads := make(map[string]float64, len(hostPods))
+		total := 0.0
+		for host := range hostPods {
+			loads[host] = math.Min(hostMaxLoad[host],
+				math.Max(0, spare[host]-level))
+			total += loads[host]
+		}
+		return loads, total
+	}
+
+	// find the lowest level at which the hosts take no more than load
+	loads, total := hostLoads(0)
+	if total > load {
+		low, high := 0.0, maxSpare
+		for i := 0; i < FALLBACK_LEVEL_STEPS; i++ {
+			level := (low + high) / 2
+			if _, total := hostLoads(level); total > load {
+				low = level
+			} else {
+				high = level
+			}
+		}
+		loads, _ = hostLoads(high)
+	}
+
+	// share the load of each host among the tenant's pods on it
+	podLoads := make(map[string]float64, len(pods))
+	for host, pods := range hostPods {
+		spare[host] -= loads[host]
+
+		demands := make(map[string]float64, len(pods))
+		evenly := make(map[string]float64, len(pods))
+		for _, pod := range pods {
+			demands[pod.Name] = math.Inf(1)
+			if pod.MaxLoad > 0 {
+				demands[pod.Name] = pod.MaxLoad
+			}
+			evenly[pod.Name] = 1
+		}
+		for podName, podLoad := range waterFill(demands, evenly, loads[host]) {
+			podLoads[podName] = podLoad
+		}
+	}
+	return podLoads
+}
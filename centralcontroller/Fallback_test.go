@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWaterFill(t *testing.T) {
+	tests := []struct {
+		name             string
+		demands, weights map[string]float64
+		capacity         float64
+		want             map[string]float64
+	}{
+		{"enough for all",
+			map[string]float64{"a": 10, "b": 10},
+			map[string]float64{"a": 1, "b": 1}, 30,
+			map[string]float64{"a": 10, "b": 10}},
+		{"in proportion to the weights",
+			map[string]float64{"a": 100, "b": 100},
+			map[string]float64{"a": 1, "b": 3}, 80,
+			map[string]float64{"a": 20, "b": 60}},
+		{"filled up key leaves the rest to the others",
+			map[string]float64{"a": 10, "b": 100},
+			map[string]float64{"a": 1, "b": 1}, 80,
+			map[string]float64{"a": 10, "b": 70}},
+		{"no weight gets nothing",
+			map[string]float64{"a": 50, "b": 50},
+			map[string]float64{"a": 1}, 80,
+			map[string]float64{"a": 50}},
+		{"no capacity",
+			map[string]float64{"a": 50},
+			map[string]float64{"a": 1}, -10,
+			map[string]float64{"a": 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shares := waterFill(test.demands, test.weights, test.capacity)
+			if len(shares) != len(test.want) {
+				t.Errorf("shares = %v, want %v", shares, test.want)
+			}
+			for key, want := range test.want {
+				assertNear(t, "share of "+key, shares[key], want)
+			}
+		})
+	}
+}
+
+// twoHostModel is two hosts of 100 with fair shares that add up to their
+// capacity: a on both hosts, b on host 0 and c on host 1.
+func twoHostModel(loads [3]float64) ([]HostJSON, []TenantJSON, []PodJSON) {
+	hosts := []HostJSON{{Name: "0", Cap: 100}, {Name: "1", Cap: 100}}
+	tenants := []TenantJSON{
+		{Name: "a", Load: loads[0], FShareLoad: 100},
+		{Name: "b", Load: loads[1], FShareLoad: 50},
+		{Name: "c", Load: loads[2], FShareLoad: 50},
+	}
+	pods := []PodJSON{
+		{Name: "a0", Tenant: "a", Host: "0"},
+		{Name: "a1", Tenant: "a", Host: "1"},
+		{Name: "b0", Tenant: "b", Host: "0"},
+		{Name: "c1", Tenant: "c", Host: "1"},
+	}
+	return hosts, tenants, pods
+}
+
+func TestFallbackSolverBounds(t *testing.T) {
+	tests := []struct {
+		name  string
+		model func() ([]HostJSON, []TenantJSON, []PodJSON)
+		// all the load or all the capacity, whichever is less
+		total float64
+	}{
+		{"underloaded", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			return threeNodeModel(100, [3]float64{30, 60, 60})
+		}, 150},
+		{"fair share caps", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			return threeNodeModel(100, [3]float64{100, 150, 100})
+		}, 300},
+		{"pod max load", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			hosts, tenants, pods := threeNodeModel(
+				100, [3]float64{30, 60, 60})
+			pods[4].MaxLoad = 30
+			return hosts, tenants, pods
+		}, 150},
+		{"overloaded", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			return twoHostModel([3]float64{150, 30, 200})
+		}, 200},
+		{"share exceeds hosts", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			// a's fair share of both hosts is 133, but host 0 takes 100
+			hosts, tenants, pods := twoHostModel([3]float64{150, 0, 150})
+			return hosts, []TenantJSON{tenants[0], tenants[2]},
+				[]PodJSON{pods[0], pods[3]}
+		}, 200},
+		{"hosts filled up", func() ([]HostJSON, []TenantJSON, []PodJSON) {
+			// a and b get 67 each, more than host 0 takes, and c's share
+			// is left 33 short on host 1
+			hosts := []HostJSON{{Name: "0", Cap: 100}, {Name: "1", Cap: 100}}
+			tenants := []TenantJSON{
+				{Name: "a", Load: 100, FShareLoad: 30},
+				{Name: "b", Load: 100, FShareLoad: 30},
+				{Name: "c", Load: 200, FShareLoad: 30},
+			}
+			pods := []PodJSON{
+				{Name: "a0", Tenant: "a", Host: "0"},
+				{Name: "b0", Tenant: "b", Host: "0"},
+				{Name: "c0", Tenant: "c", Host: "0"},
+				{Name: "c1", Tenant: "c", Host: "1"},
+			}
+			return hosts, tenants, pods
+		}, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, tenants, pods := test.model()
+			response, err := new(FallbackSolver).Solve(
+				context.Background(), hosts, tenants, pods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != SOLVER_STATUS_SUBOPTIMAL {
+				t.Fatalf("status = %d, want %d",
+					response.Status, SOLVER_STATUS_SUBOPTIMAL)
+			}
+			assertFeasible(t, hosts, tenants, pods, response.Result)
+
+			total := 0.0
+			for _, pod := range pods {
+				total += response.Result[pod.Tenant][pod.Name]
+			}
+			assertNear(t, "total load", total, test.total)
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	LP_EPSILON        = 1e-9
+	LP_MAX_ITERATIONS = 100_000
+)
+
+// constraint kinds
+const (
+	LP_LE = iota
+	LP_GE
+)
+
+// GoSolver solves the generic placement model in-process with a two-phase
+// simplex, so the controller can run without the Gurobi server. The least
+// load of each tenant comes from Objective (see Objective.go), the pods are
+// then placed minimizing the largest spare capacity. Every simplex iteration
+// checks the context of the solve, so a cancelled solve stops at once.
+type GoSolver struct {
+	Objective Objective
+}
+
+type lpConstraint struct {
+	coeffs []float64
+	kind   int
+	rhs    float64
+}
+
+func (s *GoSolver) Solve(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	GurobiGenericResponse, error) {
+
+	response := GurobiGenericResponse{Result: zeroResult(pods)}
+
+	objective := s.Objective
+	if objective == nil {
+		objective = objectives[OBJECTIVE]
+	}
+	floors, status := objective.Floors(ctx, hosts, tenants, pods)
+	if status != SOLVER_STATUS_OPTIMAL {
+		response.Status = status
+		return response, interruptedError(ctx, status)
+	}
+
+	// variables: one w per pod, followed by z = max spare capacity
+	numVars := len(pods) + 1
+	z := len(pods)
+
+	constraints := placementConstraints(
+		hosts, tenants, pods, floors, numVars, z)
+
+	cost := make([]float64, numVars)
+	cost[z] = 1
+
+	x, status := solveLP(ctx, cost, constraints)
+
+	response.Status = status
+	if status == SOLVER_STATUS_OPTIMAL {
+		for i, pod := range pods {
+			response.Result[pod.Tenant][pod.Name] = x[i]
+		}
+	}
+	return response, interruptedError(ctx, status)
+}
+
+// interruptedError returns the error of ctx if the solve was interrupted.
+func interruptedError(ctx context.Context, status int) error {
+	if status == SOLVER_STATUS_INTERRUPTED {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// placementConstraints returns the constraints of the model on the loads of
+// the pods, the first len(pods) of numVars variables:
+//
+//	sum(w_p for p on h) <= cap_h                  (each host h)
+//	cap_h - sum(w_p for p on h) <= z              (each host h, spare >= 0)
+//	floor_t <= sum(w_p for p of t) <= load_t      (each tenant t)
+//	w_p <= maxload_p                              (maxload_p > 0)
+//	sum(w_p * r_t for p of t on h) <= cap_h,r     (each host h, resource r)
+//
+// where z is the variable spare (none if spare < 0), and r_t and cap_h,r are
+// the resources of the tenants and the hosts (see Resources.go).
+func placementConstraints(hosts []HostJSON, tenants []TenantJSON,
+	pods []PodJSON, floors map[string]float64,
+	numVars int, spare int) []lpConstraint {
+
+	constraints := make([]lpConstraint, 0)
+
+	for _, host := range hosts {
+		used := hostRow(host.Name, pods, numVars)
+		constraints = append(constraints,
+			lpConstraint{used, LP_LE, host.Cap})
+		if spare >= 0 {
+			bounded := make([]float64, numVars)
+			copy(bounded, used)
+			bounded[spare] = 1
+			constraints = append(constraints,
+				lpConstraint{bounded, LP_GE, host.Cap})
+		}
+	}
+
+	for _, tenant := range tenants {
+		assigned := tenantRow(tenant.Name, pods, numVars)
+		constraints = append(constraints,
+			lpConstraint{assigned, LP_LE, tenant.Load},
+			lpConstraint{assigned, LP_GE, floors[tenant.Name]})
+	}
+
+	for i, pod := range pods {
+		if pod.MaxLoad > 0 {
+			bounded := make([]float64, numVars)
+			bounded[i] = 1
+			constraints = append(constraints,
+				lpConstraint{bounded, LP_LE, pod.MaxLoad})
+		}
+	}
+
+	intensities := make(map[string]ResourceUsages, len(tenants))
+	for _, tenant := range tenants {
+		intensities[tenant.Name] = tenant.Resources
+	}
+	for _, resource := range resourceNames(hosts) {
+		for _, host := range hosts {
+			capacity, ok := host.Resources[resource]
+			if !ok {
+				continue
+			}
+			used := make([]float64, numVars)
+			largest := 0.0
+			for i, pod := range pods {
+				if pod.Host == host.Name {
+					used[i] = intensities[pod.Tenant][resource]
+					largest = math.Max(largest, used[i])
+				}
+			}
+			if largest == 0 {
+				continue
+			}
+			// in units of the capacity, as bytes are far from loads
+			scale := capacity
+			if scale <= 0 {
+				scale = largest
+			}
+			for i := range used {
+				used[i] /= scale
+			}
+			constraints = append(constraints,
+				lpConstraint{used, LP_LE, capacity / scale})
+		}
+	}
+
+	return constraints
+}
+
+// hostRow returns the coefficients of the load of the pods on host.
+func hostRow(host string, pods []PodJSON, numVars int) []float64 {
+	row := make([]float64, numVars)
+	for i, pod := range pods {
+		if pod.Host == host {
+			row[i] = 1
+		}
+	}
+	return row
+}
+
+// tenantRow returns the coefficients of the load of the pods of tenant.
+func tenantRow(tenant string, pods []PodJSON, numVars int) []float64 {
+	row := make([]float64, numVars)
+	for i, pod := range pods {
+		if pod.Tenant == tenant {
+			row[i] = 1
+		}
+	}
+	return row
+}
+
+// solveLP minimizes cost.x subject to constraints and x >= 0, returning x
+// and one of the SOLVER_STATUS_* codes (SOLVER_STATUS_INTERRUPTED once ctx
+// is done).
+func solveLP(ctx context.Context,
+	cost []float64, constraints []lpConstraint) ([]float64, int) {
+
+	numVars := len(cost)
+	m := len(constraints)
+
+	// count the slack/surplus and artificial columns needed
+	numSlack, numArtificial := m, 0
+	for _, c := range constraints {
+		if (c.kind == LP_GE) == (c.rhs >= 0) {
+			numArtificial++
+		}
+	}
+	n := numVars + numSlack + numArtificial
+
+	// build the tableau with non-negative right hand sides;
+	// the last column of each row holds the rhs
+	tab := make([][]float64, m)
+	basis := make([]int, m)
+	nextArtificial := numVars + numSlack
+	for i, c := range constraints {
+		row := make([]float64, n+1)
+		sign, kind := 1.0, c.kind
+		if c.rhs < 0 {
+			sign = -1
+			if kind == LP_LE {
+				kind = LP_GE
+			} else {
+				kind = LP_LE
+			}
+		}
+		for j, coeff := range c.coeffs {
+			row[j] = sign * coeff
+		}
+		row[n] = sign * c.rhs
+
+		if kind == LP_LE {
+			row[numVars+i] = 1
+			basis[i] = numVars + i
+		} else {
+			row[numVars+i] = -1
+			row[nextArtificial] = 1
+			basis[i] = nextArtificial
+			nextArtificial++
+		}
+		tab[i] = row
+	}
+
+	isArtificial := func(j int) bool { return j >= numVars+numSlack }
+
+	// phase 1: drive the artificial variables to zero
+	phase1Cost := make([]float64, n)
+	for j := numVars + numSlack; j < n; j++ {
+		phase1Cost[j] = 1
+	}
+	if status := simplex(ctx, tab, basis, phase1Cost,
+		func(int) bool { return true }); status != SOLVER_STATUS_OPTIMAL {
+		return nil, status
+	}
+	infeasibility := 0.0
+	for i, b := range basis {
+		infeasibility += phase1Cost[b] * tab[i][n]
+	}
+	if infeasibility > 1e-7 {
+		return nil, SOLVER_STATUS_INFEASIBLE
+	}
+
+	// pivot any artificial variables left in the basis (at zero) out of it
+	for i, b := range basis {
+		if !isArtificial(b) {
+			continue
+		}
+		for j := 0; j < numVars+numSlack; j++ {
+			if math.Abs(tab[i][j]) > LP_EPSILON {
+				pivot(tab, i, j)
+				basis[i] = j
+				break
+			}
+		}
+	}
+
+	// phase 2: minimize the actual cost without artificial variables
+	phase2Cost := make([]float64, n)
+	copy(phase2Cost, cost)
+	if status := simplex(ctx, tab, basis, phase2Cost,
+		func(j int) bool { return !isArtificial(j) }); status != SOLVER_STATUS_OPTIMAL {
+		return nil, status
+	}
+
+	x := make([]float64, numVars)
+	for i, b := range basis {
+		if b < numVars {
+			x[b] = tab[i][n]
+		}
+	}
+	return x, SOLVER_STATUS_OPTIMAL
+}
+
+// simplex runs primal simplex iterations on tab using Bland's rule to avoid
+// cycling. Only columns for which canEnter returns true may enter the basis.
+// It stops when ctx is done.
+func simplex(ctx context.Context,
+	tab [][]float64, basis []int, cost []float64, canEnter func(int) bool) int {
+
+	m, n := len(tab), len(cost)
+
+	for iter := 0; iter < LP_MAX_ITERATIONS; iter++ {
+
+		if ctx.Err() != nil {
+			return SOLVER_STATUS_INTERRUPTED
+		}
+
+		// pick the first column with a negative reduced cost
+		enter := -1
+		for j := 0; j < n && enter < 0; j++ {
+			if !canEnter(j) {
+				continue
+			}
+			reducedCost := cost[j]
+			for i := 0; i < m; i++ {
+				reducedCost -= cost[basis[i]] * tab[i][j]
+			}
+			if reducedCost < -LP_EPSILON {
+				enter = j
+			}
+		}
+		if enter < 0 {
+			return SOLVER_STATUS_OPTIMAL
+		}
+
+		// ratio test, breaking ties by the lowest basis index
+		leave := -1
+		bestRatio := math.Inf(1)
+		for i := 0; i < m; i++ {
+			if tab[i][enter] <= LP_EPSILON {
+				continue
+			}
+			ratio := tab[i][n] / tab[i][enter]
+			if ratio < bestRatio-LP_EPSILON ||
+				(ratio < bestRatio+LP_EPSILON && basis[i] < basis[leave]) {
+				leave = i
+				bestRatio = ratio
+			}
+		}
+		if leave < 0 {
+			return SOLVER_STATUS_UNBOUNDED
+		}
+
+		pivot(tab, leave, enter)
+		basis[leave] = enter
+	}
+
+	return SOLVER_STATUS_ITERATION_LIMIT
+}
+
+func pivot(tab [][]float64, row, col int) {
+	pivotRow := tab[row]
+	pivotValue := pivotRow[col]
+	for j := range pivotRow {
+		pivotRow[j] /= pivotValue
+	}
+	for i := range tab {
+		if i == row || tab[i][col] == 0 {
+			continue
+		}
+		factor := tab[i][col]
+		for j := range tab[i] {
+			tab[i][j] -= factor * pivotRow[j]
+		}
+	}
+}
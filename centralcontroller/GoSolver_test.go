@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// threeNodeModel is the 3-node topology of test_3_node_run_generic_model in
+// gurobi_server.py: app1 on host 0, app2 on hosts 0 and 1, app3 on hosts 1
+// and 2, with fair shares of 0.5, 1.5 and 1.5 hosts.
+func threeNodeModel(hostCap float64, loads [3]float64) (
+	[]HostJSON, []TenantJSON, []PodJSON) {
+
+	hosts := []HostJSON{
+		{Name: "0", Cap: hostCap},
+		{Name: "1", Cap: hostCap},
+		{Name: "2", Cap: hostCap},
+	}
+	tenants := []TenantJSON{
+		{Name: "app1", Load: loads[0], FShareLoad: 0.5 * hostCap},
+		{Name: "app2", Load: loads[1], FShareLoad: 1.5 * hostCap},
+		{Name: "app3", Load: loads[2], FShareLoad: 1.5 * hostCap},
+	}
+	pods := []PodJSON{
+		{Name: "00", Tenant: "app1", Host: "0"},
+		{Name: "10", Tenant: "app2", Host: "0"},
+		{Name: "11", Tenant: "app2", Host: "1"},
+		{Name: "21", Tenant: "app3", Host: "1"},
+		{Name: "22", Tenant: "app3", Host: "2"},
+	}
+	return hosts, tenants, pods
+}
+
+// assertFeasible checks the constraints of the model (see Solver) on the
+// loads assigned to the pods, with the floors of MIN_MAX_SPARE.
+func assertFeasible(t *testing.T, hosts []HostJSON, tenants []TenantJSON,
+	pods []PodJSON, result map[string]map[string]float64) {
+	t.Helper()
+
+	hostLoads := make(map[string]float64)
+	tenantLoads := make(map[string]float64)
+	for _, pod := range pods {
+		load := result[pod.Tenant][pod.Name]
+		if load < -1e-6 {
+			t.Errorf("pod %s load = %f, want >= 0", pod.Name, load)
+		}
+		if pod.MaxLoad > 0 && load > pod.MaxLoad+1e-6 {
+			t.Errorf("pod %s load = %f, want <= maxload %f",
+				pod.Name, load, pod.MaxLoad)
+		}
+		hostLoads[pod.Host] += load
+		tenantLoads[pod.Tenant] += load
+	}
+	for _, host := range hosts {
+		if hostLoads[host.Name] > host.Cap+1e-6 {
+			t.Errorf("host %s load = %f, want <= cap %f",
+				host.Name, hostLoads[host.Name], host.Cap)
+		}
+	}
+	for _, tenant := range tenants {
+		floor := math.Min(tenant.FShareLoad, tenant.Load)
+		if load := tenantLoads[tenant.Name]; load > tenant.Load+1e-6 ||
+			load < floor-1e-6 {
+			t.Errorf("tenant %s load = %f, want in [%f, %f]",
+				tenant.Name, load, floor, tenant.Load)
+		}
+	}
+}
+
+// maxSpare returns the largest spare capacity of the hosts under result.
+func maxSpare(hosts []HostJSON, pods []PodJSON,
+	result map[string]map[string]float64) float64 {
+
+	hostLoads := make(map[string]float64)
+	for _, pod := range pods {
+		hostLoads[pod.Host] += result[pod.Tenant][pod.Name]
+	}
+	spare := 0.0
+	for _, host := range hosts {
+		spare = math.Max(spare, host.Cap-hostLoads[host.Name])
+	}
+	return spare
+}
+
+func TestGoSolverMatchesGenericModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		loads [3]float64
+		// max loads of the pods by name
+		maxLoads map[string]float64
+		status   int
+		// optimal max spare capacity
+		spare float64
+		// loads of the pods by name, when the optimum is unique
+		want map[string]float64
+	}{
+		{
+			// every tenant gets its load, spread to leave 50 on each host
+			name:   "underloaded",
+			loads:  [3]float64{30, 60, 60},
+			status: SOLVER_STATUS_OPTIMAL,
+			spare:  50,
+			want: map[string]float64{
+				"00": 30, "10": 20, "11": 40, "21": 10, "22": 50},
+		},
+		{
+			// app1 gets its fair share (< its load), app3 its load
+			// (< its fair share): min(fshareload, load) fills the hosts
+			name:   "fair share caps",
+			loads:  [3]float64{100, 150, 100},
+			status: SOLVER_STATUS_OPTIMAL,
+			spare:  0,
+			want: map[string]float64{
+				"00": 50, "10": 50, "11": 100, "21": 0, "22": 100},
+		},
+		{
+			// pod 22 holds at most 30, the rest of app3 goes to host 1
+			name:     "pod max load",
+			loads:    [3]float64{30, 60, 60},
+			maxLoads: map[string]float64{"22": 30},
+			status:   SOLVER_STATUS_OPTIMAL,
+			spare:    70,
+		},
+		{
+			// pods 10 and 11 hold at most 40 together, under app2's load
+			name:     "pod max loads below floor",
+			loads:    [3]float64{30, 60, 60},
+			maxLoads: map[string]float64{"10": 20, "11": 20},
+			status:   SOLVER_STATUS_INFEASIBLE,
+		},
+		{
+			// the floors (50 + 150 + 150) are more than the 300 of the hosts
+			name:   "overloaded",
+			loads:  [3]float64{100, 200, 200},
+			status: SOLVER_STATUS_INFEASIBLE,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, tenants, pods := threeNodeModel(100, test.loads)
+			for i := range pods {
+				pods[i].MaxLoad = test.maxLoads[pods[i].Name]
+			}
+
+			solver := &GoSolver{Objective: objectives["MIN_MAX_SPARE"]}
+			response, err := solver.Solve(context.Background(), hosts, tenants, pods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != test.status {
+				t.Fatalf("status = %d, want %d", response.Status, test.status)
+			}
+
+			if test.status != SOLVER_STATUS_OPTIMAL {
+				// every pod is assigned 0 load, like the Gurobi server does
+				for _, pod := range pods {
+					load, ok := response.Result[pod.Tenant][pod.Name]
+					if !ok || load != 0 {
+						t.Errorf("pod %s load = %f (%t), want 0",
+							pod.Name, load, ok)
+					}
+				}
+				return
+			}
+
+			assertFeasible(t, hosts, tenants, pods, response.Result)
+			assertNear(t, "max spare",
+				maxSpare(hosts, pods, response.Result), test.spare)
+			for _, pod := range pods {
+				if want, ok := test.want[pod.Name]; ok {
+					assertNear(t, "pod "+pod.Name+" load",
+						response.Result[pod.Tenant][pod.Name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestGoSolverStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	hosts, tenants, pods := threeNodeModel(100, [3]float64{100, 200, 200})
+	for _, name := range ObjectiveNames() {
+		solver := &GoSolver{Objective: objectives[name]}
+		response, err := solver.Solve(ctx, hosts, tenants, pods)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: error = %v, want %v", name, err, context.Canceled)
+		}
+		if response.Status != SOLVER_STATUS_INTERRUPTED {
+			t.Errorf("%s: status = %d, want %d",
+				name, response.Status, SOLVER_STATUS_INTERRUPTED)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -10,34 +9,70 @@ import (
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type KubernetesClient struct {
-	clientset *kubernetes.Clientset
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+
+	// label (or annotation) whose value is the tenant of a pod
+	TenantLabel string
 }
 
-func (k8sClient *KubernetesClient) Initialize() {
+// Initialize connects to the cluster with the kubeconfig file, or if it is "",
+// with ~/.kube/config when there is one and else with the service account of
+// the pod the CC runs in (e.g. as a Deployment).
+func (k8sClient *KubernetesClient) Initialize(kubeconfig string) error {
 
 	// Build the configuration from the kubeconfig file
-	config, err := clientcmd.BuildConfigFromFlags(
-		"", filepath.Join(getHomeDir(), ".kube", "config"))
+	config, err := getRESTConfig(kubeconfig)
 	if err != nil {
-		slog.Error(
-			fmt.Sprintf("Error building kubeconfig: %s\n", err.Error()))
+		return fmt.Errorf("error building kubeconfig: %w", err)
 	}
 
 	// Create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		slog.Error(
-			fmt.Sprintf("Error creating Kubernetes client: %s\n", err.Error()))
+		return fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+
+	// Create the client for custom resources (TenantPolicy)
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error creating dynamic client: %w", err)
 	}
 
 	// Set the clientset
 	k8sClient.clientset = clientset
+	k8sClient.dynamicClient = dynamicClient
+	return nil
+}
+
+// getRESTConfig returns the client configuration of Initialize.
+func getRESTConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	kubeconfig = filepath.Join(getHomeDir(), ".kube", "config")
+	if _, err := os.Stat(kubeconfig); err == nil {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+// getTenant returns the value of the pod's tenant label, or of the annotation
+// with the same key, and "" for pods that do not belong to a tenant.
+func getTenant(pod v1.Pod, tenantLabel string) string {
+	if tenant, ok := pod.Labels[tenantLabel]; ok {
+		return tenant
+	}
+	return pod.Annotations[tenantLabel]
 }
 
 func getAppName(pod v1.Pod) string {
@@ -51,122 +86,108 @@ func getAppName(pod v1.Pod) string {
 	return pod.Name
 }
 
-func (k8sClient *KubernetesClient) GetNodesToPodMap() map[string]map[string]Pod {
-
-	// List all pods in the cluster
-	pods, err := k8sClient.clientset.CoreV1().Pods("default").List(
-		context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		slog.Error(
-			fmt.Sprintf("Error listing pods: %s\n", err.Error()))
-	}
+// getNodesToPodMap groups the scheduled pods by the node they run on, with
+// the FShare of each tenant pod set to an equal share of its node.
+func getNodesToPodMap(
+	pods []*v1.Pod, tenantLabel string) map[string]map[string]Pod {
 
 	nodeToPods := make(map[string]map[string]Pod)
 
-	// Iterate through the pods and print the UIDs of pods on the specified node
-	for _, pod := range pods.Items {
+	// Iterate through the pods and collect the cgroup paths of the pods on
+	// each node
+	for _, pod := range pods {
+
+		// skip pods that are not scheduled yet or whose containers (and
+		// cgroups) are gone
+		if pod.Spec.NodeName == "" ||
+			pod.Status.Phase == v1.PodSucceeded ||
+			pod.Status.Phase == v1.PodFailed {
+			continue
+		}
 
 		parentCgroupFolder := strings.ToLower(string(pod.Status.QOSClass)) + "/"
 		if parentCgroupFolder == "guaranteed/" {
 			parentCgroupFolder = ""
 		}
 
-		if nodeToPods[pod.Spec.NodeName] != nil {
-			nodeToPods[pod.Spec.NodeName][pod.Name] = Pod{
-				Name:           pod.Name,
-				AppName:        getAppName(pod),
-				FShare:         0.0,
-				CGroupFilePath: parentCgroupFolder + "pod" + string(pod.UID),
-			}
-		} else {
+		if nodeToPods[pod.Spec.NodeName] == nil {
 			nodeToPods[pod.Spec.NodeName] = make(map[string]Pod)
-			nodeToPods[pod.Spec.NodeName][pod.Name] = Pod{
-				Name:           pod.Name,
-				AppName:        getAppName(pod),
-				FShare:         0.0,
-				CGroupFilePath: parentCgroupFolder + "pod" + string(pod.UID),
-			}
 		}
-	}
-
-	for _, pod := range pods.Items {
-		numPods := len(nodeToPods[pod.Spec.NodeName])
 		nodeToPods[pod.Spec.NodeName][pod.Name] = Pod{
-			Name:           nodeToPods[pod.Spec.NodeName][pod.Name].Name,
-			AppName:        nodeToPods[pod.Spec.NodeName][pod.Name].AppName,
-			FShare:         1 / float64(numPods),
-			CGroupFilePath: nodeToPods[pod.Spec.NodeName][pod.Name].CGroupFilePath,
+			Name:           pod.Name,
+			AppName:        getAppName(*pod),
+			Tenant:         getTenant(*pod, tenantLabel),
+			FShare:         0.0,
+			CGroupFilePath: parentCgroupFolder + "pod" + string(pod.UID),
 		}
 	}
 
+	setFShares(nodeToPods)
 	return nodeToPods
 }
 
-func (k8sClient *KubernetesClient) GetNodes() []Node {
-
-	// List all nodes in the cluster
-	nodes, err := k8sClient.clientset.CoreV1().Nodes().List(
-		context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		slog.Error(
-			fmt.Sprintf("Error listing nodes: %s\n", err.Error()))
+// setFShares sets the FShare of every tenant pod to an equal share of its
+// node.
+func setFShares(nodeToPods map[string]map[string]Pod) {
+	for _, pods := range nodeToPods {
+		numTenantPods := 0
+		for _, pod := range pods {
+			if pod.Tenant != "" {
+				numTenantPods++
+			}
+		}
+		for podName, pod := range pods {
+			if pod.Tenant != "" {
+				pod.FShare = 1 / float64(numTenantPods)
+				pods[podName] = pod
+			}
+		}
 	}
+}
 
-	nodesToPods := k8sClient.GetNodesToPodMap()
-
-	nodeList := make([]Node, 0)
-
-	// Print the names of the nodes
-	for _, node := range nodes.Items {
-
-		nodeNum := getNodeNum(node)
-		cpuCapacity := node.Status.Capacity[v1.ResourceCPU]
-		cpuMilliCores := int(cpuCapacity.MilliValue())
-		nodeList = append(nodeList,
-			Node{
-				Num:               nodeNum,
-				Name:              node.Name,
-				IP:                getNodeInternalIP(node),
-				HostAgentNodePort: k8sClient.getHostAgentNodePort(node),
-				Pods:              nodesToPods[node.Name],
-				MilliCores:        cpuMilliCores,
-			})
+// newNode returns the Node for a cluster node running pods, whose host agent
+// serves on nodePort (0 if unknown), with a new (not yet connected) host
+// agent connection.
+func newNode(node *v1.Node, pods map[string]Pod, nodePort int) Node {
+
+	// what the kubelet leaves to the pods, after the system and kube
+	// reservations
+	cpuCapacity := getAllocatable(*node, v1.ResourceCPU)
+	memoryCapacity := getAllocatable(*node, v1.ResourceMemory)
+	return Node{
+		Num:               getNodeNum(*node),
+		Name:              node.Name,
+		IP:                getNodeInternalIP(*node),
+		HostAgentNodePort: nodePort,
+		Pods:              pods,
+		MilliCores:        int(cpuCapacity.MilliValue()),
+		MemoryBytes:       memoryCapacity.Value(),
+		conn:              new(nodeConn),
 	}
-
-	return nodeList
 }
 
-func (k8sClient *KubernetesClient) getHostAgentNodePort(node v1.Node) int {
+// getHostAgentNodePort returns the cc NodePort of the host agent service of
+// the node, getting the service by name with getService.
+func getHostAgentNodePort(node v1.Node,
+	getService func(name string) (*v1.Service, error)) (int, error) {
 
 	serviceName := "hostagent-" + node.Labels["node-role.kubernetes.io/worker"]
 	if node.Labels["node-role.kubernetes.io/worker"] == "" {
 		serviceName = "hostagent-node0"
 	}
 
-	// Get the specified service
-	service, err := k8sClient.clientset.CoreV1().Services("default").Get(
-		context.TODO(), serviceName, metav1.GetOptions{})
+	service, err := getService(serviceName)
 	if err != nil {
-		slog.Error(
-			fmt.Sprintf(
-				"Error getting service %s: %s\n", serviceName, err.Error()))
+		return 0, fmt.Errorf(
+			"Error getting service %s: %s", serviceName, err.Error())
 	}
 
-	// Find and print the NodePort
-	nodePort := 0
 	for _, port := range service.Spec.Ports {
-		if port.Name == "cc" {
-			nodePort = int(port.NodePort)
-			break
+		if port.Name == "cc" && port.NodePort != 0 {
+			return int(port.NodePort), nil
 		}
 	}
-
-	if nodePort == 0 {
-		slog.Error(
-			fmt.Sprintf("No cc NodePort for service %s\n", serviceName))
-	}
-
-	return nodePort
+	return 0, fmt.Errorf("No cc NodePort for service %s", serviceName)
 }
 
 func getNodeNum(node v1.Node) int {
@@ -184,6 +205,15 @@ func getNodeNum(node v1.Node) int {
 	return nodeNum
 }
 
+// getAllocatable returns the node's allocatable amount of the resource name,
+// or its capacity if the kubelet reports no allocatable amount.
+func getAllocatable(node v1.Node, name v1.ResourceName) resource.Quantity {
+	if allocatable, ok := node.Status.Allocatable[name]; ok {
+		return allocatable
+	}
+	return node.Status.Capacity[name]
+}
+
 func getNodeInternalIP(node v1.Node) string {
 	for _, addr := range node.Status.Addresses {
 		if addr.Type == v1.NodeInternalIP {
@@ -193,14 +223,6 @@ func getNodeInternalIP(node v1.Node) string {
 	return ""
 }
 
-func extractIntFromString(s string) (int, error) {
-	num, err := strconv.Atoi(s)
-	if err == nil {
-		return num, nil
-	}
-	return 0, err
-}
-
 // homeDir returns the home directory for the executing user.
 func getHomeDir() string {
 	if h := getHome(); h != "" {
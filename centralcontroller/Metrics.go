@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Metrics of the control loop, served in the Prometheus text format on
+/metrics (see serveMetrics):
+
+	mplb_round_duration_seconds             time of a whole round
+	mplb_node_collection_latency_seconds    time from the start of a round to
+	                                        the node's sample
+	mplb_solver_latency_seconds             time of a solve
+	mplb_solver_status                      status of the last solve
+	mplb_solver_fallbacks_total             solves the heuristic allocator
+	                                        took over (see Fallback.go)
+	mplb_tenant_load                        measured load of the tenant
+	mplb_tenant_load_average                estimated load (see Estimator.go)
+	mplb_tenant_resource_usage              measured usage of a resource
+	                                        besides CPU (see Resources.go)
+	mplb_tenant_fshare_load                 fair share sent to the solver
+	mplb_tenant_assigned_load               load the solver assigned
+	mplb_pod_lb_weight                      LB weight of the pod
+	mplb_node_spare_capacity                capacity the solver left unused
+	mplb_node_unmanaged_load                load outside the pods (system
+	                                        services, other namespaces)
+	mplb_host_agent_call_failures_total     failed calls to the host agents
+
+Loads and capacities are in percent of one core, memory in bytes and
+network in bytes per second.
+*/
+
+var (
+	roundDurationMetric = newHistogram("mplb_round_duration_seconds",
+		"Time of an optimization round, from waiting for the samples to "+
+			"applying the decision.",
+		[]float64{0.5, 1, 1.5, 2, 3, 5, 10})
+	nodeCollectionLatencyMetric = newGauge(
+		"mplb_node_collection_latency_seconds",
+		"Time from the start of the last round until the node's sample "+
+			"arrived.", "node")
+	solverLatencyMetric = newHistogram("mplb_solver_latency_seconds",
+		"Time of a solve.",
+		[]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1})
+	solverStatusMetric = newGauge("mplb_solver_status",
+		"Status of the last solve (2 = optimal).")
+	solverFallbacksMetric = newCounter("mplb_solver_fallbacks_total",
+		"Solves the heuristic allocator took over after the solver failed, "+
+			"timed out or found no optimal solution.")
+	tenantLoadMetric = newGauge("mplb_tenant_load",
+		"Measured CPU load of the tenant in the last round.", "tenant")
+	tenantLoadAverageMetric = newGauge("mplb_tenant_load_average",
+		"Estimated CPU load of the tenant (see Estimator.go).", "tenant")
+	tenantResourceUsageMetric = newGauge("mplb_tenant_resource_usage",
+		"Measured usage of a resource of the tenant in the last round "+
+			"(memory in bytes, network in bytes per second).",
+		"tenant", "resource")
+	tenantFShareLoadMetric = newGauge("mplb_tenant_fshare_load",
+		"Fair share of the tenant sent to the solver.", "tenant")
+	tenantAssignedLoadMetric = newGauge("mplb_tenant_assigned_load",
+		"CPU load the solver assigned to the tenant.", "tenant")
+	podLBWeightMetric = newGauge("mplb_pod_lb_weight",
+		"LB weight (percent) of the pod.", "tenant", "pod")
+	nodeSpareCapacityMetric = newGauge("mplb_node_spare_capacity",
+		"CPU capacity of the node left unused by the solver.", "node")
+	nodeUnmanagedLoadMetric = newGauge("mplb_node_unmanaged_load",
+		"CPU load of the node outside the pods of the CC (system services, "+
+			"pods of other namespaces), taken off its capacity.", "node")
+	hostAgentCallFailuresMetric = newCounter(
+		"mplb_host_agent_call_failures_total",
+		"Failed calls to the host agents.", "node", "method")
+)
+
+// metric is a family of series of one kind (gauge, counter or histogram)
+// with the same label names.
+type metric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels string
+	value  float64
+	// histograms only: observations <= each bucket and their sum
+	bucketCounts []uint64
+	count        uint64
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   = make([]*metric, 0)
+)
+
+func newMetric(
+	name, help, kind string, buckets []float64, labelNames []string) *metric {
+
+	m := &metric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*metricSeries),
+	}
+	metricsMu.Lock()
+	metrics = append(metrics, m)
+	metricsMu.Unlock()
+	return m
+}
+
+func newGauge(name, help string, labelNames ...string) *metric {
+	return newMetric(name, help, "gauge", nil, labelNames)
+}
+
+func newCounter(name, help string, labelNames ...string) *metric {
+	return newMetric(name, help, "counter", nil, labelNames)
+}
+
+func newHistogram(name, help string, buckets []float64,
+	labelNames ...string) *metric {
+	return newMetric(name, help, "histogram", buckets, labelNames)
+}
+
+// get returns the series with labelValues, creating it if needed. The
+// caller holds m.mu.
+func (m *metric) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got %v",
+			m.name, m.labelNames, labelValues))
+	}
+
+	pairs := make([]string, len(m.labelNames))
+	for i, name := range m.labelNames {
+		pairs[i] = name + "=" + strconv.Quote(labelValues[i])
+	}
+	labels := strings.Join(pairs, ",")
+
+	series, ok := m.series[labels]
+	if !ok {
+		series = &metricSeries{
+			labels:       labels,
+			bucketCounts: make([]uint64, len(m.buckets)),
+		}
+		m.series[labels] = series
+	}
+	return series
+}
+
+// Set sets the gauge with labelValues to value
+func (m *metric) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = value
+}
+
+// Inc adds one to the counter with labelValues
+func (m *metric) Inc(labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value++
+}
+
+// Observe adds an observation to the histogram with labelValues
+func (m *metric) Observe(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := m.get(labelValues)
+	for i, bucket := range m.buckets {
+		if value <= bucket {
+			series.bucketCounts[i]++
+		}
+	}
+	series.count++
+	series.value += value
+}
+
+// Reset removes all series, e.g. of the tenants of a previous round
+func (m *metric) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series = make(map[string]*metricSeries)
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	labels := make([]string, 0, len(m.series))
+	for label := range m.series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		series := m.series[label]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n",
+				m.name, withLabels(series.labels), formatFloat(series.value))
+			continue
+		}
+		for i, bucket := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name,
+				withLabels(series.labels, "le="+strconv.Quote(formatFloat(bucket))),
+				series.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name,
+			withLabels(series.labels, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n",
+			m.name, withLabels(series.labels), formatFloat(series.value))
+		fmt.Fprintf(w, "%s_count%s %d\n",
+			m.name, withLabels(series.labels), series.count)
+	}
+}
+
+// withLabels returns the labels in braces, or "" if there are none
+func withLabels(labels ...string) string {
+	nonEmpty := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if label != "" {
+			nonEmpty = append(nonEmpty, label)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(nonEmpty, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// serveMetrics writes all metrics in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// setTenantMetric sets the gauge of every tenant to its value in
+// tenantValues, removing the tenants that are gone.
+func setTenantMetric(m *metric, tenantValues map[string]float64) {
+	m.Reset()
+	for tenant, value := range tenantValues {
+		m.Set(value, tenant)
+	}
+}
+
+// setTenantResourceMetric sets the resource usage metric of every tenant.
+func setTenantResourceMetric(tenantUsages map[string]ResourceUsages) {
+	tenantResourceUsageMetric.Reset()
+	for tenant, usages := range tenantUsages {
+		for resource, usage := range usages {
+			tenantResourceUsageMetric.Set(usage, tenant, resource)
+		}
+	}
+}
+
+// recordSolution sets the metrics of the fair shares sent to the solver and
+// of the loads it assigned (none if it found no allocation).
+func recordSolution(fshareLoads map[string]float64, allocation *Allocation) {
+
+	setTenantMetric(tenantFShareLoadMetric, fshareLoads)
+
+	tenantAssignedLoadMetric.Reset()
+	nodeSpareCapacityMetric.Reset()
+	if allocation == nil {
+		return
+	}
+
+	for tenant, load := range allocation.Assigned {
+		tenantAssignedLoadMetric.Set(load, tenant)
+	}
+	for host, spareCap := range allocation.Spare {
+		nodeSpareCapacityMetric.Set(spareCap, host)
+	}
+}
+
+// recordLBWeights sets the LB weight metric of every pod to its share
+// (percent) of its tenant's assigned load, as in parseGurobiResponse.
+func recordLBWeights(response GurobiGenericResponse, pins map[string]Pin) {
+	podLBWeightMetric.Reset()
+	for tenant, podResult := range response.Result {
+		podResult = getLBWeightBasis(tenant, podResult, pins)
+		tenantLoad := 0.0
+		for _, load := range podResult {
+			tenantLoad += load
+		}
+		for podName, load := range podResult {
+			weight := 100.0 / float64(len(podResult))
+			if tenantLoad != 0 {
+				weight = load * 100 / tenantLoad
+			}
+			podLBWeightMetric.Set(weight, tenant, podName)
+		}
+	}
+}
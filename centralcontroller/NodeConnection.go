@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"protocol"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	NODE_CALL_TIMEOUT_MS     = 5000
+	RECONNECT_MIN_BACKOFF_MS = 500
+	RECONNECT_MAX_BACKOFF_MS = 30_000
+	NODE_STARTUP_TIMEOUT_MS  = 10_000
+
+	// a stream that pushes no sample for this many rounds is considered dead
+	STREAM_STALL_TIMEOUT_ROUNDS = 3
+)
+
+/*
+Every node's host agent connection is supervised by its own goroutine
+(UtilizationFeed.supervise), which repeats the following until the node is
+removed from the cluster:
+	- connect to the host agent (if not connected), which must serve the
+	  protocol.VERSION of the CC
+	- replay updatePods with the node's current pods (see Topology.go)
+	- subscribe to the CPU Utilizations pushed by the host agent and forward
+	  them to the UtilizationFeed; the node is healthy while this stream lasts
+	- when any of the above fails, mark the node unhealthy and retry after an
+	  exponential backoff
+
+Unhealthy nodes are left out of the optimization rounds until they recover.
+*/
+
+// nodeConn is the connection to a node's host agent. It is shared by all
+// copies of the Node.
+type nodeConn struct {
+	mu         sync.Mutex
+	healthy    bool
+	removed    bool
+	connection *grpc.ClientConn
+	client     *protocol.HostAgentClient
+}
+
+func (n *Node) Connect() error {
+	if n.HostAgentNodePort == 0 {
+		return fmt.Errorf("no host agent NodePort for node %s", n.Name)
+	}
+	connection, err := protocol.Dial(
+		net.JoinHostPort(n.IP, strconv.Itoa(n.HostAgentNodePort)))
+	if err != nil {
+		return err
+	}
+	client := protocol.NewHostAgentClient(connection)
+
+	if err := checkVersion(client); err != nil {
+		connection.Close()
+		return err
+	}
+
+	n.conn.mu.Lock()
+	defer n.conn.mu.Unlock()
+	n.conn.connection = connection
+	n.conn.client = client
+	return nil
+}
+
+// checkVersion fails unless the host agent serves the protocol.VERSION of the
+// CC. Host agents from before the Version call fail it as unimplemented.
+func checkVersion(client *protocol.HostAgentClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(config.NodeCallTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("version check failed: %w", err)
+	}
+	if version != protocol.VERSION {
+		return fmt.Errorf("host agent speaks protocol version %d, want %d",
+			version, protocol.VERSION)
+	}
+	return nil
+}
+
+func (n *Node) Disconnect() {
+	n.conn.mu.Lock()
+	defer n.conn.mu.Unlock()
+	if n.conn.connection != nil {
+		n.conn.connection.Close()
+	}
+	n.conn.connection = nil
+	n.conn.client = nil
+	n.conn.healthy = false
+}
+
+// Remove disconnects from the host agent of a node that was removed from the
+// cluster and stops its supervisor.
+func (n *Node) Remove() {
+	n.conn.mu.Lock()
+	n.conn.removed = true
+	n.conn.mu.Unlock()
+	n.Disconnect()
+}
+
+func (n *Node) IsRemoved() bool {
+	n.conn.mu.Lock()
+	defer n.conn.mu.Unlock()
+	return n.conn.removed
+}
+
+func (n *Node) IsHealthy() bool {
+	n.conn.mu.Lock()
+	defer n.conn.mu.Unlock()
+	return n.conn.healthy
+}
+
+func (n *Node) setHealthy(healthy bool) {
+	n.conn.mu.Lock()
+	changed := n.conn.healthy != healthy
+	n.conn.healthy = healthy
+	n.conn.mu.Unlock()
+
+	if changed && healthy {
+		slog.Info(fmt.Sprintf("Node %s (%s) is healthy", n.Name, n.IP))
+	} else if changed {
+		slog.Warn(fmt.Sprintf("Node %s (%s) is unhealthy", n.Name, n.IP))
+	}
+}
+
+func (n *Node) getClient() *protocol.HostAgentClient {
+	n.conn.mu.Lock()
+	defer n.conn.mu.Unlock()
+	return n.conn.client
+}
+
+// call runs one unary call on the node's host agent with a timeout
+func (n *Node) call(method string, req interface{},
+	invoke func(ctx context.Context, client *protocol.HostAgentClient) error) error {
+
+	client := n.getClient()
+	if client == nil {
+		return fmt.Errorf("%s on %s failed: not connected", method, n.IP)
+	}
+
+	slog.Info(fmt.Sprintf("Sending %s to %s: %v", method, n.IP, req))
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(config.NodeCallTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	err := invoke(ctx, client)
+	admin.recordSent(n.Name, method, req, err)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("%s on %s failed: %s", method, n.IP, err.Error()))
+		hostAgentCallFailuresMetric.Inc(n.Name, method)
+	}
+	return err
+}
+
+func (n *Node) UpdatePods() error {
+	req := &protocol.UpdatePodsRequest{Pods: make(map[string]string)}
+	for podName, pod := range n.Pods {
+		req.Pods[podName] = pod.CGroupFilePath
+	}
+	return n.call("updatePods", req,
+		func(ctx context.Context, client *protocol.HostAgentClient) error {
+			return client.UpdatePods(ctx, req)
+		})
+}
+
+func (n *Node) ApplyLBWeights(weights string) error {
+	req := &protocol.ApplyLBWeightsRequest{Weights: weights}
+	return n.call("applyLBWeights", req,
+		func(ctx context.Context, client *protocol.HostAgentClient) error {
+			return client.ApplyLBWeights(ctx, req)
+		})
+}
+
+func (n *Node) ApplyCPUShares(shares map[string]int64) error {
+	req := &protocol.ApplyCPUSharesRequest{Shares: shares}
+	return n.call("applyCPUShares", req,
+		func(ctx context.Context, client *protocol.HostAgentClient) error {
+			return client.ApplyCPUShares(ctx, req)
+		})
+}
+
+func (n *Node) ApplyCPUQuotas(quotas map[string]int64) error {
+	req := &protocol.ApplyCPUQuotasRequest{Quotas: quotas}
+	return n.call("applyCPUQuotas", req,
+		func(ctx context.Context, client *protocol.HostAgentClient) error {
+			return client.ApplyCPUQuotas(ctx, req)
+		})
+}
+
+// Reset asks the host agent to undo the LB weights, shares and quotas it
+// applied.
+func (n *Node) Reset() error {
+	return n.call("reset", &protocol.Empty{},
+		func(ctx context.Context, client *protocol.HostAgentClient) error {
+			return client.Reset(ctx)
+		})
+}
+
+// supervise keeps the host agent of the node at nodeIdx connected and its
+// CPU Utilizations flowing into feed, reconnecting with backoff whenever that
+// fails.
+func (feed *UtilizationFeed) supervise(nodeIdx int) {
+
+	backoff := RECONNECT_MIN_BACKOFF_MS * time.Millisecond
+
+	for {
+		// the node's address and pods may have changed since the last attempt
+		n := feed.topology.Node(nodeIdx)
+		if n.IsRemoved() {
+			return
+		}
+
+		var err error
+		if n.getClient() == nil {
+			err = n.Connect()
+		}
+		if err == nil {
+			err = n.UpdatePods()
+		}
+		if err == nil {
+			var wasHealthy bool
+			wasHealthy, err = n.streamCPUUtilizations(nodeIdx, feed)
+			if wasHealthy {
+				backoff = RECONNECT_MIN_BACKOFF_MS * time.Millisecond
+			}
+		}
+
+		n.setHealthy(false)
+		feed.notifyHealthChanged()
+
+		slog.Warn(fmt.Sprintf("Lost host agent on %s: %s; reconnecting in %v",
+			n.IP, err.Error(), backoff))
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > RECONNECT_MAX_BACKOFF_MS*time.Millisecond {
+			backoff = RECONNECT_MAX_BACKOFF_MS * time.Millisecond
+		}
+	}
+}
+
+// streamCPUUtilizations forwards the samples pushed by the node's host agent
+// to feed until the stream ends. The node is marked healthy once the first
+// sample arrives; wasHealthy reports whether that happened.
+func (n *Node) streamCPUUtilizations(
+	nodeIdx int, feed *UtilizationFeed) (wasHealthy bool, err error) {
+
+	client := n.getClient()
+	if client == nil {
+		return false, fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	windowsMs := make([]int64, len(config.CPUUtilizationWindowsMs))
+	for i, windowMs := range config.CPUUtilizationWindowsMs {
+		windowsMs[i] = int64(windowMs)
+	}
+	stream, err := client.StreamCPUUtilizations(ctx,
+		&protocol.StreamCPUUtilizationsRequest{
+			IntervalMs:  int64(config.RoundDurationMs),
+			WindowMs:    int64(config.CPUUtilizationWindowMs),
+			Percentiles: config.CPUUtilizationPercentiles,
+			WindowsMs:   windowsMs})
+	if err != nil {
+		return false, err
+	}
+
+	// cancel the stream if the host agent stops pushing samples
+	stallTimeout := time.Duration(
+		STREAM_STALL_TIMEOUT_ROUNDS*config.RoundDurationMs) * time.Millisecond
+	watchdog := time.AfterFunc(stallTimeout, cancel)
+	defer watchdog.Stop()
+
+	for {
+		sample, err := stream.Recv()
+		if err != nil {
+			return wasHealthy, err
+		}
+		watchdog.Reset(stallTimeout)
+		if !wasHealthy {
+			wasHealthy = true
+			n.setHealthy(true)
+			feed.notifyHealthChanged()
+		}
+		for podName, podErr := range sample.Errors {
+			slog.Warn(fmt.Sprintf("No CPU Utilization for pod %s on %s: %s",
+				podName, n.IP, podErr))
+		}
+		if sample.Utilizations == nil {
+			// a node without pods still reported this round
+			sample.Utilizations = make(map[string]float64)
+		}
+		feed.samples <- CPUUtil{nodeIdx, sample.Utilizations,
+			getSampleCPUStatistics(sample, config.CPUUtilizationPercentiles),
+			getSampleResourceUsages(sample), sample.UnmanagedUtilization}
+	}
+}
+
+// waitUntilHealthy waits up to timeout for all nodes to become healthy and
+// returns whether they did.
+func waitUntilHealthy(nodes []Node, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		allHealthy := true
+		for i := range nodes {
+			allHealthy = allHealthy &&
+				(nodes[i].IsHealthy() || nodes[i].IsRemoved())
+		}
+		if allHealthy {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for i := range nodes {
+		if !nodes[i].IsHealthy() && !nodes[i].IsRemoved() {
+			slog.Warn("Host agent still unhealthy on node: " + nodes[i].IP)
+		}
+	}
+	return false
+}
+
+// healthyNodes returns the nodes that reported CPU Utilizations this round.
+func healthyNodes(
+	nodes []Node, nodeCPUUtilizations []map[string]float64) []Node {
+
+	reported := make([]Node, 0, len(nodes))
+	for i := range nodes {
+		if nodeCPUUtilizations[i] != nil {
+			reported = append(reported, nodes[i])
+		}
+	}
+	return reported
+}
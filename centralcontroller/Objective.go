@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+const (
+	// tangents of log(1 + load) per tenant (PROPORTIONAL)
+	PROPORTIONAL_LOG_TANGENTS = 64
+	// relative slack left under the floors found by an LP, so that the LPs
+	// built on them stay feasible, and the least relative gain that counts
+	// as growing (MAX_MIN)
+	OBJECTIVE_FLOOR_SLACK = 1e-6
+	OBJECTIVE_TOLERANCE   = 1e-3
+	// most LPs of a progressive filling (MAX_MIN, DRF, a PRIORITY tier):
+	// every step solves one LP for the level and one per growing tenant, so
+	// up to n * (n + 1) for n tenants; past the bound the growing tenants
+	// are frozen at the level reached
+	OBJECTIVE_MAX_MIN_SOLVES = 100
+)
+
+/*
+Objective is the fairness objective of the GoSolver (-objective): it decides
+the least load each tenant gets, from the same hosts, tenants and pods as the
+model (see Solver), and the GoSolver then places the pods minimizing the
+largest spare capacity with those floors. Every objective keeps the
+capacities of the hosts, the loads of the tenants and the max loads of the
+pods. The weight of a tenant is its fair share (fshareload, see
+TenantPolicy.go).
+
+	MIN_MAX_SPARE  the model of the Gurobi server: every tenant gets at least
+	               min(fshareload, load)
+	MAX_MIN        weighted max-min fairness: raise every tenant's load in
+	               proportion to its weight, freezing the tenants that cannot
+	               grow, until none can
+	PROPORTIONAL   proportional fairness: maximize sum(weight * log(1 + load))
+	               (piecewise linear, PROPORTIONAL_LOG_TANGENTS tangents)
+	DRF            dominant-resource fairness: max-min fairness of the
+	               dominant shares (the largest share of a resource that
+	               scales with the load, CPU or network, a tenant uses, see
+	               Resources.go); with CPU the only resource, it is
+	               unweighted MAX_MIN
+	PRIORITY       strict priority tiers (TenantPolicy priority, higher
+	               first): each tier gets as much as it can, with MAX_MIN in
+	               the tier, before the next tier gets anything
+
+Tenants of no weight are only given the capacity left over by the placement.
+Objectives are registered by name with RegisterObjective.
+*/
+type Objective interface {
+	// Floors returns the least load of each tenant, and a SOLVER_STATUS_*.
+	Floors(ctx context.Context,
+		hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+		map[string]float64, int)
+}
+
+// objectives are the Objectives by name
+var objectives = make(map[string]Objective)
+
+func RegisterObjective(name string, objective Objective) {
+	objectives[name] = objective
+}
+
+// ObjectiveNames returns the names of the registered objectives, sorted.
+func ObjectiveNames() []string {
+	names := make([]string, 0, len(objectives))
+	for name := range objectives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterObjective("MIN_MAX_SPARE", minMaxSpareObjective{})
+	RegisterObjective("MAX_MIN", maxMinObjective{})
+	RegisterObjective("PROPORTIONAL", proportionalObjective{})
+	RegisterObjective("DRF", drfObjective{})
+	RegisterObjective("PRIORITY", priorityObjective{})
+}
+
+// fshareWeights returns the weight of every tenant: its fair share.
+func fshareWeights(tenants []TenantJSON) map[string]float64 {
+	weights := make(map[string]float64, len(tenants))
+	for _, tenant := range tenants {
+		weights[tenant.Name] = math.Max(0, tenant.FShareLoad)
+	}
+	return weights
+}
+
+// ============================== MIN_MAX_SPARE ==============================
+
+type minMaxSpareObjective struct{}
+
+func (minMaxSpareObjective) Floors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	map[string]float64, int) {
+
+	floors := make(map[string]float64, len(tenants))
+	for _, tenant := range tenants {
+		floors[tenant.Name] = math.Min(tenant.FShareLoad, tenant.Load)
+	}
+	return floors, SOLVER_STATUS_OPTIMAL
+}
+
+// ================================= MAX_MIN =================================
+
+type maxMinObjective struct{}
+
+func (maxMinObjective) Floors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	map[string]float64, int) {
+
+	return maxMinFloors(ctx, hosts, tenants, pods, fshareWeights(tenants),
+		make(map[string]float64))
+}
+
+// maxMinFloors raises the loads of the tenants with a weight in proportion to
+// their weights (progressive filling) on top of the floors of the others,
+// and returns the floors of all the tenants.
+//
+// Every step maximizes z with load_t >= weight_t * z for the tenants still
+// growing, then freezes those that cannot get more than weight_t * z while
+// the others keep theirs. The tenants at their load are frozen without an
+// LP, and after OBJECTIVE_MAX_MIN_SOLVES LPs all of them are, which keeps
+// the floors feasible, only less fair.
+func maxMinFloors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON,
+	weights map[string]float64, fixed map[string]float64) (
+	map[string]float64, int) {
+
+	floors := make(map[string]float64, len(tenants))
+	for tenant, floor := range fixed {
+		floors[tenant] = floor
+	}
+	growing := make([]TenantJSON, 0, len(tenants))
+	for _, tenant := range tenants {
+		if _, ok := fixed[tenant.Name]; !ok &&
+			weights[tenant.Name] > 0 && tenant.Load > 0 {
+			growing = append(growing, tenant)
+		}
+	}
+
+	// variables: one w per pod, followed by z
+	numVars := len(pods) + 1
+	z := len(pods)
+
+	// the constraints of a step, with the growing tenants at weight * level
+	// (or weight * z if level < 0)
+	stepConstraints := func(level float64) []lpConstraint {
+		constraints := placementConstraints(
+			hosts, tenants, pods, floors, numVars, -1)
+		for _, tenant := range growing {
+			row := tenantRow(tenant.Name, pods, numVars)
+			rhs := weights[tenant.Name] * level
+			if level < 0 {
+				row[z] = -weights[tenant.Name]
+				rhs = 0
+			}
+			constraints = append(constraints,
+				lpConstraint{row, LP_GE, rhs})
+		}
+		return constraints
+	}
+
+	solves := 0
+	for len(growing) > 0 {
+
+		// maximize the level z of the growing tenants
+		cost := make([]float64, numVars)
+		cost[z] = -1
+		x, status := solveLP(ctx, cost, stepConstraints(-1))
+		solves++
+		if status != SOLVER_STATUS_OPTIMAL {
+			return nil, status
+		}
+		level := x[z] * (1 - OBJECTIVE_FLOOR_SLACK)
+
+		// freeze the tenants that cannot grow past the level (or only by
+		// numerical noise)
+		stillGrowing := make([]TenantJSON, 0, len(growing))
+		for _, tenant := range growing {
+			load := weights[tenant.Name] * level
+			if tenant.Load <= load+OBJECTIVE_TOLERANCE*(1+load) ||
+				solves >= OBJECTIVE_MAX_MIN_SOLVES {
+				continue
+			}
+			cost := make([]float64, numVars)
+			for i, pod := range pods {
+				if pod.Tenant == tenant.Name {
+					cost[i] = -1
+				}
+			}
+			x, status := solveLP(ctx, cost, stepConstraints(level))
+			solves++
+			if status == SOLVER_STATUS_INTERRUPTED {
+				return nil, status
+			}
+			if status == SOLVER_STATUS_OPTIMAL &&
+				-dot(cost, x) > load+OBJECTIVE_TOLERANCE*(1+load) {
+				stillGrowing = append(stillGrowing, tenant)
+			}
+		}
+		if len(stillGrowing) == len(growing) {
+			// every step freezes a tenant at least
+			stillGrowing = stillGrowing[:0]
+		}
+
+		for _, tenant := range growing {
+			floors[tenant.Name] = weights[tenant.Name] * level
+		}
+		growing = stillGrowing
+	}
+
+	return floors, SOLVER_STATUS_OPTIMAL
+}
+
+// dot returns the dot product of a and b.
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// =============================== PROPORTIONAL ===============================
+
+type proportionalObjective struct{}
+
+func (proportionalObjective) Floors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	map[string]float64, int) {
+
+	weights := fshareWeights(tenants)
+
+	// variables: one w per pod, followed by one s per tenant,
+	// s_t <= log(1 + load_t)
+	numVars := len(pods) + len(tenants)
+	s := func(t int) int { return len(pods) + t }
+
+	constraints := placementConstraints(hosts, tenants, pods,
+		make(map[string]float64), numVars, -1)
+	cost := make([]float64, numVars)
+	for t, tenant := range tenants {
+		if weights[tenant.Name] == 0 || tenant.Load <= 0 {
+			continue
+		}
+		cost[s(t)] = -weights[tenant.Name]
+
+		// below the tangent of log(1 + x) at every breakpoint a up to the
+		// load: s - x / (1 + a) <= log(1 + a) - a / (1 + a)
+		for k := 0; k <= PROPORTIONAL_LOG_TANGENTS; k++ {
+			a := math.Pow(1+tenant.Load,
+				float64(k)/PROPORTIONAL_LOG_TANGENTS) - 1
+			row := tenantRow(tenant.Name, pods, numVars)
+			for i := range row {
+				row[i] *= -1 / (1 + a)
+			}
+			row[s(t)] = 1
+			constraints = append(constraints, lpConstraint{
+				row, LP_LE, math.Log1p(a) - a/(1+a)})
+		}
+	}
+
+	x, status := solveLP(ctx, cost, constraints)
+	if status != SOLVER_STATUS_OPTIMAL {
+		return nil, status
+	}
+	return assignedFloors(tenants, pods, x), SOLVER_STATUS_OPTIMAL
+}
+
+// assignedFloors returns the load of every tenant in x, less the slack.
+func assignedFloors(
+	tenants []TenantJSON, pods []PodJSON, x []float64) map[string]float64 {
+
+	floors := make(map[string]float64, len(tenants))
+	for i, pod := range pods {
+		floors[pod.Tenant] += x[i]
+	}
+	for tenant, floor := range floors {
+		floors[tenant] = slackFloor(floor)
+	}
+	return floors
+}
+
+// slackFloor returns floor less the slack.
+func slackFloor(floor float64) float64 {
+	return math.Max(0, floor-OBJECTIVE_FLOOR_SLACK*(1+floor))
+}
+
+// =================================== DRF ===================================
+
+type drfObjective struct{}
+
+func (drfObjective) Floors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	map[string]float64, int) {
+
+	return maxMinFloors(ctx, hosts, tenants, pods, dominantShareWeights(
+		hosts, tenants), make(map[string]float64))
+}
+
+// dominantShareWeights returns the weight of every tenant under DRF: the
+// load per unit of its dominant share, so that max-min of weighted loads is
+// max-min of dominant shares. A unit of load of a tenant uses 1 / capacity of
+// the CPU of all the hosts, and usage / capacity of every other resource it
+// uses, the largest of which is its dominant share.
+func dominantShareWeights(
+	hosts []HostJSON, tenants []TenantJSON) map[string]float64 {
+
+	capacity := 0.0
+	capacities := make(ResourceUsages)
+	for _, host := range hosts {
+		capacity += host.Cap
+		for resource, resourceCapacity := range host.Resources {
+			capacities[resource] += resourceCapacity
+		}
+	}
+
+	weights := make(map[string]float64, len(tenants))
+	for _, tenant := range tenants {
+		share := 1 / capacity
+		for resource, usage := range tenant.Resources {
+			if capacities[resource] > 0 {
+				share = math.Max(share, usage/capacities[resource])
+			}
+		}
+		weights[tenant.Name] = 1 / share
+	}
+	return weights
+}
+
+// ================================ PRIORITY =================================
+
+type priorityObjective struct{}
+
+func (priorityObjective) Floors(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	map[string]float64, int) {
+
+	tiers := make(map[int][]TenantJSON)
+	priorities := make([]int, 0)
+	for _, tenant := range tenants {
+		if _, ok := tiers[tenant.Priority]; !ok {
+			priorities = append(priorities, tenant.Priority)
+		}
+		tiers[tenant.Priority] = append(tiers[tenant.Priority], tenant)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	// the tiers below the one being filled get nothing yet
+	weights := fshareWeights(tenants)
+	floors := make(map[string]float64, len(tenants))
+	for _, priority := range priorities {
+		tierWeights := make(map[string]float64, len(tiers[priority]))
+		for _, tenant := range tiers[priority] {
+			tierWeights[tenant.Name] = weights[tenant.Name]
+		}
+
+		tierFloors, status := maxMinFloors(ctx,
+			hosts, tenants, pods, tierWeights, floors)
+		if status != SOLVER_STATUS_OPTIMAL {
+			return nil, status
+		}
+		for _, tenant := range tiers[priority] {
+			floors[tenant.Name] = tierFloors[tenant.Name]
+		}
+	}
+	return floors, SOLVER_STATUS_OPTIMAL
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestObjectivesKnownOptima(t *testing.T) {
+	// on twoHostModel: a on both hosts, b on host 0, c on host 1, with fair
+	// shares 100, 50 and 50 of the 200 of capacity; c is held to 20 by its
+	// load, so the others share the 180 left
+	tests := []struct {
+		name, objective string
+		// b's network per unit of load, on hosts of 1000 (0 = none)
+		bNetwork  float64
+		bPriority int
+		want      map[string]float64
+		tolerance float64
+	}{
+		// a and b in proportion to their weights, 2:1
+		{"MAX_MIN", "MAX_MIN", 0, 0,
+			map[string]float64{"a": 120, "b": 60, "c": 20}, 1e-3},
+		// max 100 log(1+a) + 50 log(1+b) with a + b = 180: 1+a = 2(1+b);
+		// log is approximated by its tangents
+		{"PROPORTIONAL", "PROPORTIONAL", 0, 0,
+			map[string]float64{"a": 120.333, "b": 59.667, "c": 20}, 2},
+		// unweighted without other resources
+		{"DRF", "DRF", 0, 0,
+			map[string]float64{"a": 90, "b": 90, "c": 20}, 1e-3},
+		// b's dominant share is its network, 20 * b / 2000, twice its CPU
+		// share, and it is held to 50 by the network of host 0
+		{"DRF network", "DRF", 20, 0,
+			map[string]float64{"a": 130, "b": 50, "c": 20}, 1e-3},
+		// b first, all of host 0; a gets what c leaves of host 1
+		{"PRIORITY", "PRIORITY", 0, 1,
+			map[string]float64{"a": 80, "b": 100, "c": 20}, 1e-3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, tenants, pods := twoHostModel([3]float64{200, 200, 20})
+			if test.bNetwork > 0 {
+				for i := range hosts {
+					hosts[i].Resources = ResourceUsages{RESOURCE_NETWORK: 1000}
+				}
+				tenants[1].Resources = ResourceUsages{
+					RESOURCE_NETWORK: test.bNetwork}
+			}
+			tenants[1].Priority = test.bPriority
+
+			solver := &GoSolver{Objective: objectives[test.objective]}
+			response, err := solver.Solve(
+				context.Background(), hosts, tenants, pods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != SOLVER_STATUS_OPTIMAL {
+				t.Fatalf("status = %d, want %d",
+					response.Status, SOLVER_STATUS_OPTIMAL)
+			}
+			for tenant, want := range test.want {
+				got := 0.0
+				for _, load := range response.Result[tenant] {
+					got += load
+				}
+				if math.Abs(got-want) > test.tolerance {
+					t.Errorf("load of %s = %f, want %f", tenant, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMinMaxSpareObjectiveFloors(t *testing.T) {
+	hosts, tenants, pods := twoHostModel([3]float64{200, 200, 20})
+	floors, status := minMaxSpareObjective{}.Floors(
+		context.Background(), hosts, tenants, pods)
+	if status != SOLVER_STATUS_OPTIMAL {
+		t.Fatalf("status = %d, want %d", status, SOLVER_STATUS_OPTIMAL)
+	}
+	// min(fshareload, load)
+	for tenant, want := range map[string]float64{"a": 100, "b": 50, "c": 20} {
+		assertNear(t, "floor of "+tenant, floors[tenant], want)
+	}
+}
+
+// BenchmarkMaxMinFloors measures the progressive filling of MAX_MIN on 10
+// hosts and 20 tenants of 3 pods each, all overloaded, against the solver
+// deadline (SOLVER_TIMEOUT_MS).
+func BenchmarkMaxMinFloors(b *testing.B) {
+	hosts := make([]HostJSON, 10)
+	for i := range hosts {
+		hosts[i] = HostJSON{Name: fmt.Sprint(i), Cap: 100}
+	}
+	tenants := make([]TenantJSON, 20)
+	pods := make([]PodJSON, 0)
+	for i := range tenants {
+		name := fmt.Sprintf("t%d", i)
+		tenants[i] = TenantJSON{Name: name, Load: float64(50 + 10*i),
+			FShareLoad: float64(1 + i%3)}
+		for j := 0; j < 3; j++ {
+			pods = append(pods, PodJSON{Name: fmt.Sprintf("%s-%d", name, j),
+				Tenant: name, Host: fmt.Sprint((i + 3*j) % len(hosts))})
+		}
+	}
+
+	for i := 0; i < b.N; i++ {
+		_, status := maxMinObjective{}.Floors(
+			context.Background(), hosts, tenants, pods)
+		if status != SOLVER_STATUS_OPTIMAL {
+			b.Fatalf("status = %d, want %d", status, SOLVER_STATUS_OPTIMAL)
+		}
+	}
+}
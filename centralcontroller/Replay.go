@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CPU of every node of a topology built with -pods: the nodes of the
+	// recorded runs are minikube nodes with 2 CPUs
+	REPLAY_NODE_MILLICORES = 2000
+)
+
+/*
+What does replay do:
+1. Read the trace: one LogFileFormat JSON per round, e.g. a
+	logs/none_CPU_* file or a synthetic trace in the same format
+2. Read the topology: the nodes with their pods and tenants, in the format
+	served on /admin/topology (Name, MilliCores, MemoryBytes and Pods of
+	each node), or build it from the pods of the trace with -pods
+3. For every round of the trace:
+	- Split the recorded CPU Utilizations and resource usages of the pods by
+	  node (pods that are not in the topology are left out, with a warning
+	  the first time), with the recorded unmanaged CPU Utilization of each
+	  node
+	- Let the enforcer decide, with the same pipeline (noise, overhead,
+	  load estimators, fair shares) and solver as the live CC
+	- Log the round with what the enforcer would have applied
+No host agent is connected and nothing is applied. With -shadow-config, the
+candidate policy decides every round too (see Shadow.go), to compare two
+policies on the same trace.
+
+	centralcontroller replay -topology nodes.json -trace logs/none_CPU_24 \
+		-enforcement LB+CPU_QUOTA -log-file replayed_CPU_24
+
+Traces recorded before /admin/topology, like the logs/none_CPU_* runs, have
+neither nodes nor tenants. -pods places their pods by name prefix instead,
+as prefix=tenant@node (an empty tenant = background load); a pod is placed
+by the longest prefix it matches, up to a "-":
+
+	centralcontroller replay -trace logs/none_CPU_1 -pods \
+		profile=profile@node1,recommendation=recommendation@node1,\
+		frontend=frontend@node2,hostagent-node1=@node1,hostagent-node2=@node2
+*/
+
+// replay runs the replay subcommand with its command line arguments.
+func replay(args []string) {
+
+	fs := flag.NewFlagSet("centralcontroller replay", flag.ExitOnError)
+	topologyFile := fs.String("topology", "",
+		"JSON file with the nodes and their pods (as served on /admin/topology)")
+	var pods podMapping
+	fs.Func("pods", "tenant and node of the pods of a trace without a "+
+		"topology, by pod name prefix, e.g. \"profile=profile@node1,"+
+		"consul=@node2\" (an empty tenant = background load)",
+		func(s string) (err error) {
+			pods, err = parsePodMapping(s)
+			return err
+		})
+	nodeMilliCores := fs.Int("node-millicores", REPLAY_NODE_MILLICORES,
+		"CPU of every node of the topology built with -pods")
+	traceFile := fs.String("trace", "",
+		"recorded CPU log to replay, one JSON round per line (- = stdin)")
+
+	var err error
+	config, err = loadConfig(fs, args)
+	if err == nil && *traceFile == "" {
+		err = fmt.Errorf("-trace is required")
+	}
+	if err == nil && (*topologyFile == "") == (pods == nil) {
+		err = fmt.Errorf("one of -topology and -pods is required")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err.Error())
+		os.Exit(2)
+	}
+
+	enforcer, err := NewEnforcer(config.Enforcement,
+		NewSolver(config), config)
+	check(err)
+	shadow, err := newShadowPolicy(config)
+	check(err)
+
+	trace, err := readTrace(*traceFile)
+	check(err)
+
+	var nodes []Node
+	if *topologyFile != "" {
+		nodes, err = readTopology(*topologyFile)
+		check(err)
+	} else {
+		nodes = buildTopology(trace, pods, *nodeMilliCores)
+	}
+
+	logFile := new(LogFile)
+	logFile.Initialize(config.LogFile)
+	defer logFile.Close()
+
+	slog.Info(fmt.Sprintf("Replaying %s on %d nodes with %s",
+		*traceFile, len(nodes), config.Enforcement))
+
+	// Repeat the following for every round of the trace:
+	// - Split the CPU Utilizations of the round by node
+	// - Decide what to enforce
+	// - Log the decision
+	unknownPods := make(map[string]bool)
+	for _, recorded := range trace {
+
+		// - Split the CPU Utilizations of the round by node
+		warnUnknownPods(nodes, recorded, unknownPods)
+		nodeCPUUtilizations := getRecordedCPUUtilizations(nodes, recorded)
+		nodeResourceUsages := getRecordedResourceUsages(nodes, recorded)
+		nodeUnmanagedUtilizations := make([]float64, len(nodes))
+		for i, node := range nodes {
+			nodeUnmanagedUtilizations[i] =
+				recorded.UnmanagedUtilizations[node.Name]
+		}
+		// no statistics are logged, the StatisticsEstimators use the loads
+		round := Round{nodes, nodeCPUUtilizations, nil, nodeResourceUsages,
+			nodeUnmanagedUtilizations, nil, nil}
+
+		// - Decide what to enforce
+		shadowLog := decideRound(round, enforcer, shadow)
+
+		// - Log the decision
+		logFile.Writeln(getLogFileFormat(
+			time.Unix(0, recorded.Time), round, enforcer, shadowLog))
+	}
+
+	slog.Info(fmt.Sprintf("Replayed %d rounds", len(trace)))
+}
+
+// readTrace reads the rounds of a recorded CPU log (- = stdin).
+func readTrace(path string) ([]LogFileFormat, error) {
+	file := os.Stdin
+	if path != "-" {
+		var err error
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+	}
+
+	trace := make([]LogFileFormat, 0)
+	decoder := json.NewDecoder(file)
+	for {
+		var recorded LogFileFormat
+		err := decoder.Decode(&recorded)
+		if err == io.EOF {
+			return trace, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace %s, round %d: %w",
+				path, len(trace)+1, err)
+		}
+		trace = append(trace, recorded)
+	}
+}
+
+// readTopology reads the nodes and their pods from a JSON file.
+func readTopology(path string) ([]Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []Node
+	err = json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %w", path, err)
+	}
+
+	for i := range nodes {
+		nodes[i].conn = new(nodeConn)
+		for podName, pod := range nodes[i].Pods {
+			pod.Name = podName
+			nodes[i].Pods[podName] = pod
+		}
+	}
+	return nodes, nil
+}
+
+// podMapping is the tenant and node of the pods of a trace, by pod name
+// prefix (see -pods).
+type podMapping map[string]podPlacement
+
+type podPlacement struct {
+	Tenant string // "" = background load
+	Node   string
+}
+
+// parsePodMapping parses a -pods value, prefix=tenant@node joined by ",".
+func parsePodMapping(s string) (podMapping, error) {
+	pods := make(podMapping)
+	for _, entry := range strings.Split(s, ",") {
+		prefix, placement, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		tenant, node, hasNode := strings.Cut(placement, "@")
+		if !ok || !hasNode || prefix == "" || node == "" {
+			return nil, fmt.Errorf(
+				"invalid pod placement %q, want prefix=tenant@node", entry)
+		}
+		pods[prefix] = podPlacement{Tenant: tenant, Node: node}
+	}
+	return pods, nil
+}
+
+// place returns the prefix and placement of the pod, by the longest prefix
+// that is the pod's name or is followed by a "-" in it.
+func (m podMapping) place(podName string) (string, podPlacement, bool) {
+	match := ""
+	for prefix := range m {
+		if (podName == prefix || strings.HasPrefix(podName, prefix+"-")) &&
+			len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	placement, ok := m[match]
+	return match, placement, ok
+}
+
+// buildTopology returns the nodes of the placements, sorted by name, with
+// the pods of the trace placed on them. Every node has milliCores of CPU.
+func buildTopology(
+	trace []LogFileFormat, pods podMapping, milliCores int) []Node {
+
+	nodeToPods := make(map[string]map[string]Pod)
+	for _, placement := range pods {
+		nodeToPods[placement.Node] = make(map[string]Pod)
+	}
+	for _, recorded := range trace {
+		for podName := range recorded.CPUUtilizations {
+			prefix, placement, ok := pods.place(podName)
+			if !ok {
+				continue
+			}
+			nodeToPods[placement.Node][podName] = Pod{
+				Name:    podName,
+				AppName: prefix,
+				Tenant:  placement.Tenant,
+			}
+		}
+	}
+	setFShares(nodeToPods)
+
+	nodeNames := make([]string, 0, len(nodeToPods))
+	for nodeName := range nodeToPods {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	nodes := make([]Node, 0, len(nodeNames))
+	for i, nodeName := range nodeNames {
+		nodes = append(nodes, Node{
+			Num:        i,
+			Name:       nodeName,
+			Pods:       nodeToPods[nodeName],
+			MilliCores: milliCores,
+			conn:       new(nodeConn),
+		})
+	}
+	return nodes
+}
+
+// warnUnknownPods warns about the pods of a recorded round that are not in
+// the topology, and so are left out, unless they are in warned already.
+func warnUnknownPods(
+	nodes []Node, recorded LogFileFormat, warned map[string]bool) {
+
+	for podName := range recorded.CPUUtilizations {
+		if warned[podName] {
+			continue
+		}
+		known := false
+		for _, node := range nodes {
+			if _, ok := node.Pods[podName]; ok {
+				known = true
+				break
+			}
+		}
+		if !known {
+			warned[podName] = true
+			slog.Warn(fmt.Sprintf(
+				"Pod %s of the trace is not in the topology, left out", podName))
+		}
+	}
+}
+
+// getRecordedCPUUtilizations returns the CPU Utilizations of the pods of
+// each node in a recorded round. Every node reports, even if none of its
+// pods are in the round.
+func getRecordedCPUUtilizations(
+	nodes []Node, recorded LogFileFormat) []map[string]float64 {
+
+	nodeCPUUtilizations := make([]map[string]float64, len(nodes))
+	for i, node := range nodes {
+		nodeCPUUtilizations[i] = make(map[string]float64)
+		for podName := range node.Pods {
+			util, ok := recorded.CPUUtilizations[podName]
+			if !ok {
+				continue
+			}
+			podUtil, err := strconv.ParseFloat(util, 64)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Invalid CPU Utilization of pod %s: %q",
+					podName, util))
+				continue
+			}
+			nodeCPUUtilizations[i][podName] = podUtil
+		}
+	}
+	return nodeCPUUtilizations
+}
+
+// getRecordedResourceUsages returns the resource usages of the pods of each
+// node in a recorded round (none in traces from before they were logged).
+func getRecordedResourceUsages(
+	nodes []Node, recorded LogFileFormat) []map[string]ResourceUsages {
+
+	nodeResourceUsages := make([]map[string]ResourceUsages, len(nodes))
+	for i, node := range nodes {
+		nodeResourceUsages[i] = make(map[string]ResourceUsages)
+		for podName := range node.Pods {
+			if usages, ok := recorded.ResourceUsages[podName]; ok {
+				nodeResourceUsages[i][podName] = usages
+			}
+		}
+	}
+	return nodeResourceUsages
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// replayPods places the pods of testdata/none_CPU_1, 5 rounds of a recorded
+// hotelReservation run: three tenants and the host agents, the other pods
+// are left out.
+const replayPods = "profile=profile@node1,recommendation=recommendation@node1," +
+	"frontend=frontend@node2,hostagent-node1=@node1,hostagent-node2=@node2"
+
+func TestPodMappingPlace(t *testing.T) {
+	pods, err := parsePodMapping(
+		"profile=profile@node1,memcached-profile=@node2,consul=@node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		podName string
+		prefix  string
+		ok      bool
+	}{
+		{"profile-78b66fd976-ggn2w", "profile", true},
+		{"memcached-profile-7d6fcb6b8-59mnq", "memcached-profile", true},
+		{"consul", "consul", true},
+		{"mongodb-profile-6bb85f4df7-94nqh", "", false},
+		{"profiler", "", false},
+	}
+	for _, test := range tests {
+		prefix, _, ok := pods.place(test.podName)
+		if prefix != test.prefix || ok != test.ok {
+			t.Errorf("place(%s) = %q, %t, want %q, %t",
+				test.podName, prefix, ok, test.prefix, test.ok)
+		}
+	}
+
+	for _, invalid := range []string{"profile", "profile=profile", "=a@node1",
+		"profile=profile@"} {
+		if _, err := parsePodMapping(invalid); err == nil {
+			t.Errorf("parsePodMapping(%q) did not fail", invalid)
+		}
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	trace, err := readTrace(filepath.Join("testdata", "none_CPU_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods, err := parsePodMapping(replayPods)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := buildTopology(trace, pods, 2000)
+	if len(nodes) != 2 || nodes[0].Name != "node1" || nodes[1].Name != "node2" {
+		t.Fatalf("nodes = %v, want node1 and node2", nodes)
+	}
+	want := map[string]Pod{
+		"profile-78b66fd976-ggn2w": {Name: "profile-78b66fd976-ggn2w",
+			AppName: "profile", Tenant: "profile", FShare: 0.5},
+		"recommendation-68974cc4fb-m8mqc": {
+			Name:    "recommendation-68974cc4fb-m8mqc",
+			AppName: "recommendation", Tenant: "recommendation", FShare: 0.5},
+		"hostagent-node1": {Name: "hostagent-node1",
+			AppName: "hostagent-node1"},
+	}
+	if len(nodes[0].Pods) != len(want) {
+		t.Errorf("pods of node1 = %v, want %v", nodes[0].Pods, want)
+	}
+	for podName, pod := range want {
+		if nodes[0].Pods[podName] != pod {
+			t.Errorf("pod %s = %+v, want %+v",
+				podName, nodes[0].Pods[podName], pod)
+		}
+	}
+	if nodes[1].MilliCores != 2000 {
+		t.Errorf("node2 MilliCores = %d, want 2000", nodes[1].MilliCores)
+	}
+}
+
+func TestReplayLegacyTrace(t *testing.T) {
+	defer func() { config = defaultConfig() }()
+	logPath := filepath.Join(t.TempDir(), "replayed_CPU_1")
+
+	replay([]string{"-trace", filepath.Join("testdata", "none_CPU_1"),
+		"-pods", replayPods, "-enforcement", "LB", "-log-file", logPath})
+
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	rounds := 0
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var round LogFileFormat
+		if err := json.Unmarshal(scanner.Bytes(), &round); err != nil {
+			t.Fatal(err)
+		}
+		rounds++
+
+		// the pods of the topology are replayed, with their recorded
+		// CPU Utilizations
+		if _, ok := round.CPUUtilizations["profile-78b66fd976-ggn2w"]; !ok {
+			t.Errorf("round %d: no CPU Utilization of profile", rounds)
+		}
+		if _, ok := round.CPUUtilizations["geo-7cbdf78874-dktr7"]; ok {
+			t.Errorf("round %d: CPU Utilization of geo, left out", rounds)
+		}
+		// every tenant has one pod, which gets all its load
+		for tenant, pod := range map[string]string{
+			"profile":        "profile-78b66fd976-ggn2w",
+			"recommendation": "recommendation-68974cc4fb-m8mqc",
+			"frontend":       "frontend-57fdd49d77-4k2rt",
+		} {
+			assertNear(t, "LB weight of "+pod, round.LBWeights[tenant][pod], 100)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if rounds != 5 {
+		t.Errorf("replayed %d rounds, want 5", rounds)
+	}
+}
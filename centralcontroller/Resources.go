@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"protocol"
+)
+
+// resources of the model besides CPU
+const (
+	// memory working set, in bytes
+	RESOURCE_MEMORY = "memory"
+	// network bytes received and sent per second
+	RESOURCE_NETWORK = "network"
+)
+
+/*
+Besides their CPU Utilization, the host agents report the memory working set
+and the network bytes per second of every pod. The model treats them
+differently, as only the network follows the load balanced to a pod:
+
+	memory   a fixed demand of every pod: its working set, whatever its
+	         load. It constrains each host as a whole: a host whose pods
+	         (tenant and background) need more than its memory
+	         (Node.MemoryBytes, what the kubelet leaves to the pods) is under
+	         eviction pressure, and gets no load (CPU capacity 0) until its
+	         pods fit again
+	network  a usage per unit of load: the measured usage of a tenant over
+	         its measured load (percent of one core)
+
+So the vectors of the model only carry the resources that scale with the
+load:
+
+	HostJSON.Resources    capacity of the host left to the tenants: its network
+	                      bandwidth (-node-network-bytes-per-sec), less the
+	                      usage of the background pods and of the pinned
+	                      tenants
+	TenantJSON.Resources  usage of the tenant per unit of load
+
+A pod of tenant t assigned load w_p uses w_p * r_t of every such resource,
+and the GoSolver keeps the usage of the pods of every host within its
+capacity:
+
+	sum(w_p * r_t for p of t on h) <= cap_h,r     (each host h, resource r)
+
+DRF weighs the tenants by their dominant share over CPU and these resources
+(see Objective.go), and the FallbackSolver keeps within the capacities too.
+The memory a tenant holds does not move with its load, so it does not enter
+the split of the load.
+
+Hosts without a capacity of a resource (unknown memory, network bandwidth 0)
+are not constrained on it, and tenants without a usage of a resource (e.g.
+host agents that do not report it, idle tenants) do not use it. The Gurobi
+server ignores the resources, but not the hosts drained for memory.
+*/
+
+// ResourceUsages is the usage of every resource by name (RESOURCE_*).
+type ResourceUsages map[string]float64
+
+// getSampleResourceUsages returns the usages of the pods in a sample of a
+// host agent, none for the pods it has none of.
+func getSampleResourceUsages(
+	sample *protocol.CPUUtilizationSample) map[string]ResourceUsages {
+
+	podUsages := make(map[string]ResourceUsages)
+	add := func(resource string, usages map[string]float64) {
+		for podName, usage := range usages {
+			if podUsages[podName] == nil {
+				podUsages[podName] = make(ResourceUsages)
+			}
+			podUsages[podName][resource] = usage
+		}
+	}
+	add(RESOURCE_MEMORY, sample.MemoryWorkingSets)
+	add(RESOURCE_NETWORK, sample.NetworkBytesPerSec)
+	return podUsages
+}
+
+// roundResources is the usage of the resources in a round.
+type roundResources struct {
+	// usage of the pods of each tenant, by tenant
+	tenantUsages map[string]ResourceUsages
+	// usage of each tenant per unit of its load of the resources that scale
+	// with the load (not memory), by tenant
+	intensities map[string]ResourceUsages
+	// usage of the background pods of each node, by node
+	background map[string]ResourceUsages
+	// memory of the tenant pods of each node, by node
+	tenantMemory map[string]float64
+}
+
+// getRoundResources sums the usages of the pods of each tenant and of the
+// background pods of each node, like getPerTenantUtilizations and
+// getBackgroundUtilizations do with the CPU Utilizations.
+func getRoundResources(nodes []Node,
+	nodeCPUUtilizations []map[string]float64,
+	nodeResourceUsages []map[string]ResourceUsages) roundResources {
+
+	resources := roundResources{
+		tenantUsages: make(map[string]ResourceUsages),
+		intensities:  make(map[string]ResourceUsages),
+		background:   make(map[string]ResourceUsages),
+		tenantMemory: make(map[string]float64),
+	}
+
+	add := func(usages map[string]ResourceUsages, key string,
+		podUsages ResourceUsages) {
+
+		if usages[key] == nil {
+			usages[key] = make(ResourceUsages)
+		}
+		for resource, usage := range podUsages {
+			usages[key][resource] += usage
+		}
+	}
+	for i, podResources := range nodeResourceUsages {
+		for podName, podUsages := range podResources {
+			if pod, ok := nodes[i].Pods[podName]; ok && pod.Tenant != "" {
+				add(resources.tenantUsages, pod.Tenant, podUsages)
+				resources.tenantMemory[nodes[i].Name] +=
+					podUsages[RESOURCE_MEMORY]
+			} else {
+				add(resources.background, nodes[i].Name, podUsages)
+			}
+		}
+	}
+
+	tenantUtils := getPerTenantUtilizations(nodes, nodeCPUUtilizations)
+	for tenant, usages := range resources.tenantUsages {
+		if tenantUtils[tenant] <= 0 {
+			continue
+		}
+		resources.intensities[tenant] = make(ResourceUsages, len(usages))
+		for resource, usage := range usages {
+			if resource == RESOURCE_MEMORY {
+				continue
+			}
+			resources.intensities[tenant][resource] = usage / tenantUtils[tenant]
+		}
+	}
+
+	return resources
+}
+
+// getHostResources returns the capacity of every resource of the node that
+// scales with the load left after its background usage.
+func getHostResources(node Node, background ResourceUsages) ResourceUsages {
+	capacities := make(ResourceUsages)
+	if config.NodeNetworkBytesPerSec > 0 {
+		capacities[RESOURCE_NETWORK] = config.NodeNetworkBytesPerSec
+	}
+	for resource, capacity := range capacities {
+		capacities[resource] = math.Max(0, capacity-background[resource])
+	}
+	return capacities
+}
+
+// isOutOfMemory returns whether the pods of the node, its background pods and
+// the tenant pods using tenantMemory, need more than its memory. Nodes of
+// unknown memory never are.
+func isOutOfMemory(
+	node Node, background ResourceUsages, tenantMemory float64) bool {
+
+	return node.MemoryBytes > 0 &&
+		background[RESOURCE_MEMORY]+tenantMemory > float64(node.MemoryBytes)
+}
+
+// resourceNames returns the names of the resources of hosts, sorted.
+func resourceNames(hosts []HostJSON) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		for resource := range host.Resources {
+			if !seen[resource] {
+				seen[resource] = true
+				names = append(names, resource)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resourceRoom returns the most load of a tenant using intensities per unit
+// of load that fits in the capacities, +Inf if it uses none of them.
+func resourceRoom(capacities ResourceUsages, intensities ResourceUsages) float64 {
+	room := math.Inf(1)
+	for resource, capacity := range capacities {
+		if usage := intensities[resource]; usage > 0 {
+			room = math.Min(room, math.Max(0, capacity)/usage)
+		}
+	}
+	return room
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetRoundResourcesMemoryIsFixed(t *testing.T) {
+	nodes := []Node{{Name: "node1", Pods: map[string]Pod{
+		"frontend-0": {Name: "frontend-0", Tenant: "frontend"},
+		"consul-0":   {Name: "consul-0"},
+	}}}
+	resources := getRoundResources(nodes,
+		[]map[string]float64{{"frontend-0": 20, "consul-0": 5}},
+		[]map[string]ResourceUsages{{
+			"frontend-0": {RESOURCE_MEMORY: 1000, RESOURCE_NETWORK: 400},
+			"consul-0":   {RESOURCE_MEMORY: 300},
+		}})
+
+	intensities := resources.intensities["frontend"]
+	if _, ok := intensities[RESOURCE_MEMORY]; ok {
+		t.Errorf("memory per unit of load = %v, want none", intensities)
+	}
+	assertNear(t, "network per unit of load",
+		intensities[RESOURCE_NETWORK], 400.0/20)
+	assertNear(t, "memory of the tenant pods",
+		resources.tenantMemory["node1"], 1000)
+	assertNear(t, "memory of the background pods",
+		resources.background["node1"][RESOURCE_MEMORY], 300)
+}
+
+func TestGetHostCapacitiesDrainsNodesOutOfMemory(t *testing.T) {
+	config = testConfig()
+	defer func() { config = defaultConfig() }()
+	config.NodeNetworkBytesPerSec = 1000
+
+	nodes := []Node{
+		{Name: "node1", MilliCores: 2000, MemoryBytes: 1000},
+		{Name: "node2", MilliCores: 2000, MemoryBytes: 1000},
+		// unknown memory is never out of it
+		{Name: "node3", MilliCores: 2000},
+	}
+	resources := roundResources{
+		background: map[string]ResourceUsages{
+			"node1": {RESOURCE_MEMORY: 300, RESOURCE_NETWORK: 100},
+			"node3": {RESOURCE_MEMORY: 300},
+		},
+		tenantMemory: map[string]float64{
+			"node1": 800, "node2": 500, "node3": 5000},
+	}
+	hostCaps, hostResources := getHostCapacities(nodes,
+		map[string]float64{"node1": 10, "node2": 10}, resources)
+
+	for node, want := range map[string]float64{
+		"node1": 0, "node2": 190, "node3": 200} {
+		assertNear(t, "CPU capacity of "+node, hostCaps[node], want)
+	}
+	assertNear(t, "network of node1",
+		hostResources["node1"][RESOURCE_NETWORK], 900)
+	if _, ok := hostResources["node2"][RESOURCE_MEMORY]; ok {
+		t.Errorf("node2 has a memory capacity per unit of load")
+	}
+}
+
+func TestGetMaxTenantLoad(t *testing.T) {
+	hostCaps := map[string]float64{"0": 100, "1": 100}
+	pods := []PodJSON{
+		{Name: "a0", Tenant: "a", Host: "0"},
+		{Name: "a0-2", Tenant: "a", Host: "0"},
+		{Name: "a1", Tenant: "a", Host: "1", MaxLoad: 30},
+		{Name: "b1", Tenant: "b", Host: "1"},
+	}
+	tests := []struct {
+		name          string
+		hostResources map[string]ResourceUsages
+		want          float64
+	}{
+		// the two pods on host 0 share its capacity
+		{"CPU", nil, 100 + 30},
+		// and its network: 1000 / 20
+		{"network", map[string]ResourceUsages{
+			"0": {RESOURCE_NETWORK: 1000}}, 50 + 30},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxLoad := getMaxTenantLoad("a", pods, hostCaps,
+				test.hostResources, ResourceUsages{RESOURCE_NETWORK: 20})
+			assertNear(t, "max load", maxLoad, test.want)
+		})
+	}
+}
+
+func TestSolversKeepNetworkCapacity(t *testing.T) {
+	solvers := map[string]Solver{
+		"GO":       &GoSolver{Objective: objectives["MIN_MAX_SPARE"]},
+		"FALLBACK": new(FallbackSolver),
+	}
+	for name, solver := range solvers {
+		t.Run(name, func(t *testing.T) {
+			// b uses 20 of network per unit of load, host 0 has 1000
+			hosts, tenants, pods := twoHostModel([3]float64{200, 200, 20})
+			for i := range hosts {
+				hosts[i].Resources = ResourceUsages{RESOURCE_NETWORK: 1000}
+			}
+			tenants[1].Resources = ResourceUsages{RESOURCE_NETWORK: 20}
+
+			response, err := solver.Solve(
+				context.Background(), hosts, tenants, pods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertFeasible(t, hosts, tenants, pods, response.Result)
+			if got := response.Result["b"]["b0"]; got > 50+1e-6 {
+				t.Errorf("load of b0 = %f, want <= 50", got)
+			}
+		})
+	}
+}
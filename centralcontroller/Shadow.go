@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+)
+
+/*
+Shadow mode runs a candidate policy next to the active one, to see what it
+would do on the live load before switching to it. The candidate is a JSON
+config file (-shadow-config, same keys as -config) read over the active
+configuration; its enforcement, solver (and objective), load estimators and
+LB weight damping are its own, the rest (noise, overheads, timeouts, ...) is
+the active one's.
+
+What does shadow mode do every round:
+ 1. The candidate decides with the CPU Utilizations of the round (before the
+    active enforcer, so that the metrics and /admin/solver are left with the
+    active policy's solve)
+ 2. The active enforcer decides, and only its decision is applied
+ 3. Both decisions are logged, with the allocation of each (the load assigned
+    to every tenant and the capacity left spare on every node) and the
+    candidate's minus the active's (see ShadowLogFormat)
+
+A round the candidate fails (e.g. its solver is down) is logged with the
+error; the active policy goes on.
+
+	centralcontroller -config active.json -shadow-config candidate.json
+*/
+
+// ShadowLogFormat is what the candidate decided in a round, in the log line
+// of the round (LogFileFormat.Shadow).
+type ShadowLogFormat struct {
+	Enforcement  string                        `json:"enforcement"`
+	CPUShares    map[string]string             `json:"CPUShares"`
+	CPUQuotas    map[string]string             `json:"CPUQuotas"`
+	LBWeights    map[string]map[string]float64 `json:"LBWeights"`
+	RawLBWeights map[string]map[string]float64 `json:"RawLBWeights,omitempty"`
+	SolvedBy     map[string]string             `json:"SolvedBy,omitempty"`
+	Error        string                        `json:"error,omitempty"`
+
+	// the allocations of the active and the candidate policy, and the
+	// candidate's minus the active's (none if either found none)
+	Active    *Allocation `json:"active,omitempty"`
+	Candidate *Allocation `json:"candidate,omitempty"`
+	Delta     *Allocation `json:"delta,omitempty"`
+}
+
+// shadowPolicy is the candidate policy of shadow mode.
+type shadowPolicy struct {
+	config   Config
+	enforcer Enforcer
+}
+
+// newShadowPolicy returns the candidate policy of c, nil if shadow mode is
+// off.
+func newShadowPolicy(c Config) (*shadowPolicy, error) {
+	if c.ShadowConfig == "" {
+		return nil, nil
+	}
+
+	candidate, err := c.shadowConfig()
+	if err != nil {
+		return nil, err
+	}
+	enforcer, err := NewEnforcer(candidate.Enforcement,
+		NewSolver(candidate), candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Shadowing with " + candidate.Enforcement + " from " +
+		c.ShadowConfig)
+	return &shadowPolicy{config: candidate, enforcer: enforcer}, nil
+}
+
+// shadowConfig returns the configuration of the candidate policy: the file
+// c.ShadowConfig read over c.
+func (c *Config) shadowConfig() (Config, error) {
+	candidate := *c
+	candidate.ShadowConfig = ""
+	// the file is decoded into the maps of c otherwise
+	candidate.TenantEstimators = maps.Clone(c.TenantEstimators)
+
+	err := candidate.readFile(c.ShadowConfig)
+	if err != nil {
+		return candidate, err
+	}
+	if candidate.ShadowConfig != "" {
+		return candidate, fmt.Errorf("%s: a shadow config has no shadowConfig",
+			c.ShadowConfig)
+	}
+	return candidate, candidate.Validate()
+}
+
+// decideRound lets enforcer decide round and, in shadow mode (shadow not
+// nil), the candidate too. It returns what the candidate decided, nil if
+// shadow mode is off.
+func decideRound(
+	round Round, enforcer Enforcer, shadow *shadowPolicy) *ShadowLogFormat {
+
+	if shadow == nil {
+		enforcer.Decide(round)
+		return nil
+	}
+
+	shadowLog := shadow.decide(round)
+
+	before := admin.LastSolve()
+	enforcer.Decide(round)
+	shadowLog.Active = solveSince(before)
+	shadowLog.Delta = diffAllocations(shadowLog.Active, shadowLog.Candidate)
+
+	return shadowLog
+}
+
+// decide lets the candidate decide round and returns its decision and
+// allocation, or the error it failed with.
+func (s *shadowPolicy) decide(round Round) (shadowLog *ShadowLogFormat) {
+	shadowLog = &ShadowLogFormat{Enforcement: s.config.Enforcement}
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Warn(fmt.Sprintf("Shadow round failed: %v", r))
+			shadowLog.Error = fmt.Sprint(r)
+		}
+	}()
+
+	before := admin.LastSolve()
+	s.enforcer.Decide(round)
+	shadowLog.Candidate = solveSince(before)
+
+	logFileFormat := newLogFileFormat(0)
+	s.enforcer.Log(&logFileFormat)
+	shadowLog.CPUShares = logFileFormat.CPUShares
+	shadowLog.CPUQuotas = logFileFormat.CPUQuotas
+	shadowLog.LBWeights = logFileFormat.LBWeights
+	shadowLog.RawLBWeights = logFileFormat.RawLBWeights
+	shadowLog.SolvedBy = logFileFormat.SolvedBy
+
+	return shadowLog
+}
+
+// solveSince returns the allocation of the last solve if there was one after
+// before, nil otherwise. With several enforcers, it is the last one's.
+func solveSince(before *solverCall) *Allocation {
+	after := admin.LastSolve()
+	if after == nil || after == before {
+		return nil
+	}
+	return after.Allocation
+}
+
+// diffAllocations returns candidate minus active, by tenant and by node.
+func diffAllocations(active, candidate *Allocation) *Allocation {
+	if active == nil || candidate == nil {
+		return nil
+	}
+
+	diff := func(a, b map[string]float64) map[string]float64 {
+		d := make(map[string]float64, len(b))
+		for key, value := range b {
+			d[key] = value - a[key]
+		}
+		for key, value := range a {
+			if _, ok := b[key]; !ok {
+				d[key] = -value
+			}
+		}
+		return d
+	}
+
+	return &Allocation{
+		Assigned: diff(active.Assigned, candidate.Assigned),
+		Spare:    diff(active.Spare, candidate.Spare),
+	}
+}
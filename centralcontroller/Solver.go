@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Status codes returned by a Solver. They mirror gurobipy's GRB status
+// codes so that responses from the Gurobi server and from the in-process
+// solver can be handled the same way.
+const (
+	SOLVER_STATUS_OPTIMAL         = 2
+	SOLVER_STATUS_INFEASIBLE      = 3
+	SOLVER_STATUS_UNBOUNDED       = 5
+	SOLVER_STATUS_ITERATION_LIMIT = 7
+	SOLVER_STATUS_INTERRUPTED     = 11
+	// the FallbackSolver's allocation, feasible but not optimal
+	SOLVER_STATUS_SUBOPTIMAL = 13
+)
+
+// hasSolution reports whether a response with status has an allocation, be it
+// the solver's optimal one or the FallbackSolver's.
+func hasSolution(status int) bool {
+	return status == SOLVER_STATUS_OPTIMAL || status == SOLVER_STATUS_SUBOPTIMAL
+}
+
+// JSON structs to send to the Gurobi Server
+type HostJSON struct {
+	Name string  `json:"name"`
+	Cap  float64 `json:"cap"`
+	// capacity of the resources besides CPU (see Resources.go)
+	Resources ResourceUsages `json:"resources,omitempty"`
+}
+type TenantJSON struct {
+	Name       string  `json:"name"`
+	Load       float64 `json:"load"`
+	FShareLoad float64 `json:"fshareload"`
+	// TenantPolicy priority, for the PRIORITY objective (see Objective.go)
+	Priority int `json:"priority,omitempty"`
+	// usage of the resources besides CPU per unit of load (see Resources.go)
+	Resources ResourceUsages `json:"resources,omitempty"`
+}
+type PodJSON struct {
+	Name   string `json:"name"`
+	Tenant string `json:"tenant"`
+	Host   string `json:"host"`
+	// most load the pod can be assigned (0 = no limit)
+	MaxLoad float64 `json:"maxload,omitempty"`
+}
+
+// GurobiGenericResponse maps tenant -> pod -> load assigned to that pod.
+type GurobiGenericResponse struct {
+	Status int                           `json:"status"`
+	Result map[string]map[string]float64 `json:"result"`
+}
+
+/*
+Solver solves the generic placement model (run_generic_model in
+gurobi_server.py):
+
+	minimize   max_h sp_h
+	subject to sum(w_p for p on h) + sp_h == cap_h, sp_h >= 0  (each host h)
+	           sum(w_p for p of t) <= load_t                   (each tenant t)
+	           sum(w_p for p of t) >= min(fshareload_t, load_t)
+	           0 <= w_p <= maxload_p                   (maxload_p > 0)
+
+The GoSolver and the FallbackSolver also keep the usage of the resources
+besides CPU within the capacity of every host (see Resources.go). A solve
+stops when ctx is done, with ctx's error.
+*/
+type Solver interface {
+	Solve(ctx context.Context,
+		hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+		GurobiGenericResponse, error)
+}
+
+// NewSolver returns the solver configured by c, failing every solve that
+// takes longer than c.SolverTimeoutMs.
+func NewSolver(c Config) Solver {
+	timeout := time.Duration(c.SolverTimeoutMs) * time.Millisecond
+
+	var solver Solver
+	switch c.Solver {
+	case "GO":
+		solver = &GoSolver{Objective: objectives[c.Objective]}
+	case "GUROBI":
+		solver = &GurobiHTTPSolver{URL: c.SolverURL,
+			Client: &http.Client{Timeout: timeout}}
+	default:
+		panic("Invalid solver type: " + c.Solver)
+	}
+	return &deadlineSolver{solver: solver, timeout: timeout}
+}
+
+// deadlineSolver fails the solves of solver that take longer than timeout,
+// and cancels them: the GoSolver stops at its next simplex iteration and the
+// request to the Gurobi server is aborted, so timed-out solves do not pile up
+// in the background.
+type deadlineSolver struct {
+	solver  Solver
+	timeout time.Duration
+}
+
+func (s *deadlineSolver) Solve(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	GurobiGenericResponse, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	type solution struct {
+		response GurobiGenericResponse
+		err      error
+	}
+	done := make(chan solution, 1)
+	go func() {
+		response, err := s.solver.Solve(ctx, hosts, tenants, pods)
+		done <- solution{response, err}
+	}()
+
+	// a solve between two checks of ctx is not waited for
+	select {
+	case solved := <-done:
+		return solved.response, solved.err
+	case <-ctx.Done():
+		return GurobiGenericResponse{}, fmt.Errorf(
+			"solver timed out after %v: %w", s.timeout, ctx.Err())
+	}
+}
+
+// GurobiHTTPSolver sends the model to the Flask/Gurobi server
+// (gurobi_server.py) and returns its response.
+type GurobiHTTPSolver struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *GurobiHTTPSolver) Solve(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	GurobiGenericResponse, error) {
+
+	var response GurobiGenericResponse
+
+	payload, err := json.Marshal([]interface{}{hosts, tenants, pods})
+	if err != nil {
+		return response, err
+	}
+
+	slog.Debug(fmt.Sprintf("Payload sending to Gurobi: %s", payload))
+
+	resBody, err := sendPostRequest(ctx, s.Client, s.URL, string(payload))
+	if err != nil {
+		return response, err
+	}
+
+	err = json.Unmarshal([]byte(resBody), &response)
+	return response, err
+}
+
+// zeroResult returns a result with every pod assigned 0 load, which is what
+// the Gurobi server returns when the model is not solved to optimality.
+func zeroResult(pods []PodJSON) map[string]map[string]float64 {
+	result := make(map[string]map[string]float64)
+	for _, pod := range pods {
+		if result[pod.Tenant] == nil {
+			result[pod.Tenant] = make(map[string]float64)
+		}
+		result[pod.Tenant][pod.Name] = 0.0
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingSolver solves until its solve is cancelled.
+type blockingSolver struct {
+	stopped chan struct{}
+}
+
+func (s *blockingSolver) Solve(ctx context.Context,
+	hosts []HostJSON, tenants []TenantJSON, pods []PodJSON) (
+	GurobiGenericResponse, error) {
+
+	<-ctx.Done()
+	close(s.stopped)
+	return GurobiGenericResponse{}, ctx.Err()
+}
+
+func TestDeadlineSolverCancelsTimedOutSolve(t *testing.T) {
+	blocking := &blockingSolver{stopped: make(chan struct{})}
+	solver := &deadlineSolver{solver: blocking, timeout: 10 * time.Millisecond}
+
+	hosts, tenants, pods := threeNodeModel(100, [3]float64{30, 60, 60})
+	if _, err := solver.Solve(
+		context.Background(), hosts, tenants, pods); err == nil {
+		t.Errorf("Solve did not time out")
+	}
+	select {
+	case <-blocking.stopped:
+	case <-time.After(time.Second):
+		t.Errorf("timed-out solve was not cancelled")
+	}
+}
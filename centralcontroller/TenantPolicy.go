@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	TENANT_POLICY_GROUP    = "mplb.io"
+	TENANT_POLICY_VERSION  = "v1alpha1"
+	TENANT_POLICY_RESOURCE = "tenantpolicies"
+
+	// relative weight of the tenants without a TenantPolicy (or without an
+	// entitled CPU in theirs)
+	DEFAULT_TENANT_WEIGHT = 1.0
+)
+
+/*
+TenantPolicy is the fair-share entitlement of a tenant, set by the platform
+team with a namespaced TenantPolicy object (see tenantpolicy_crd.yaml):
+
+	apiVersion: mplb.io/v1alpha1
+	kind: TenantPolicy
+	metadata:
+	  name: frontend
+	spec:
+	  tenant: frontend      # defaults to the object's name
+	  cpu:
+	    milliCores: 1500    # absolute entitlement, or
+	    weight: 2           # a share of the capacity left after the absolute ones
+	  priority: 10          # higher is entitled first when oversubscribed
+	  minMilliCores: 500    # never entitled to less than this
+	  burstMilliCores: 3000 # never assigned more than this
+
+What does getFShareLoads do with the policies of the tenants:
+ 1. Entitle the tenants with milliCores to that much CPU
+ 2. Split the capacity left among the other tenants by weight
+ 3. Raise every entitlement to at least its minimum guarantee
+ 4. If the entitlements exceed the capacity, grant them by priority (higher
+    first), scaling down the tenants of the priority that no longer fits
+
+The entitlements are sent to the solver as fshareload and the burst caps
+limit the load of the tenants.
+*/
+type TenantPolicy struct {
+	Tenant          string
+	MilliCores      int64
+	Weight          float64
+	Priority        int
+	MinMilliCores   int64
+	BurstMilliCores int64
+}
+
+// tenantPolicyObject is the TenantPolicy object as stored by the API server
+type tenantPolicyObject struct {
+	Spec struct {
+		Tenant string `json:"tenant"`
+		CPU    struct {
+			MilliCores int64   `json:"milliCores"`
+			Weight     float64 `json:"weight"`
+		} `json:"cpu"`
+		Priority        int   `json:"priority"`
+		MinMilliCores   int64 `json:"minMilliCores"`
+		BurstMilliCores int64 `json:"burstMilliCores"`
+	} `json:"spec"`
+}
+
+// TenantPolicies is a cache of the TenantPolicy objects, kept up to date by
+// an informer.
+type TenantPolicies struct {
+	mu       sync.Mutex
+	policies map[string]TenantPolicy
+
+	factory dynamicinformer.DynamicSharedInformerFactory
+}
+
+// WatchTenantPolicies starts the TenantPolicy informer and returns the
+// policies once its cache is synced. If the TenantPolicy CRD is not
+// installed, every tenant gets an equal share of its nodes.
+func (k8sClient *KubernetesClient) WatchTenantPolicies() *TenantPolicies {
+
+	p := &TenantPolicies{policies: make(map[string]TenantPolicy)}
+
+	groupVersion := TENANT_POLICY_GROUP + "/" + TENANT_POLICY_VERSION
+	_, err := k8sClient.clientset.Discovery().ServerResourcesForGroupVersion(
+		groupVersion)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("No TenantPolicy CRD (%s): %s; "+
+			"using equal fair shares", groupVersion, err.Error()))
+		return p
+	}
+
+	p.factory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		k8sClient.dynamicClient,
+		time.Duration(config.TopologyResyncPeriodMs)*time.Millisecond,
+		"default", nil)
+	informer := p.factory.ForResource(schema.GroupVersionResource{
+		Group:    TENANT_POLICY_GROUP,
+		Version:  TENANT_POLICY_VERSION,
+		Resource: TENANT_POLICY_RESOURCE,
+	})
+
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.update(informer.Lister()) },
+		UpdateFunc: func(oldObj, newObj interface{}) { p.update(informer.Lister()) },
+		DeleteFunc: func(obj interface{}) { p.update(informer.Lister()) },
+	})
+
+	p.factory.Start(nil)
+	for resource, synced := range p.factory.WaitForCacheSync(nil) {
+		if !synced {
+			panic(fmt.Sprintf("Failed to sync informer for %v", resource))
+		}
+	}
+	p.update(informer.Lister())
+
+	return p
+}
+
+// Policies returns a snapshot of the policies by tenant.
+func (p *TenantPolicies) Policies() map[string]TenantPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	policies := make(map[string]TenantPolicy, len(p.policies))
+	for tenant, policy := range p.policies {
+		policies[tenant] = policy
+	}
+	return policies
+}
+
+// update rebuilds the policies from the informer cache, leaving out the
+// invalid ones.
+func (p *TenantPolicies) update(lister cache.GenericLister) {
+
+	objs, err := lister.List(labels.Everything())
+	check(err)
+
+	// list in name order, so that the same policy wins every time when
+	// several name the same tenant
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].(*unstructured.Unstructured).GetName() <
+			objs[j].(*unstructured.Unstructured).GetName()
+	})
+
+	policies := make(map[string]TenantPolicy)
+	for _, obj := range objs {
+		u := obj.(*unstructured.Unstructured)
+		policy, err := parseTenantPolicy(u)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring TenantPolicy %s: %s",
+				u.GetName(), err.Error()))
+			continue
+		}
+		if _, ok := policies[policy.Tenant]; ok {
+			slog.Warn(fmt.Sprintf("Ignoring TenantPolicy %s: tenant %s "+
+				"already has a policy", u.GetName(), policy.Tenant))
+			continue
+		}
+		policies[policy.Tenant] = policy
+	}
+
+	slog.Info(fmt.Sprintf("TenantPolicies: %v", policies))
+
+	p.mu.Lock()
+	p.policies = policies
+	p.mu.Unlock()
+}
+
+func parseTenantPolicy(u *unstructured.Unstructured) (TenantPolicy, error) {
+
+	var obj tenantPolicyObject
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+		u.UnstructuredContent(), &obj)
+	if err != nil {
+		return TenantPolicy{}, err
+	}
+
+	policy := TenantPolicy{
+		Tenant:          obj.Spec.Tenant,
+		MilliCores:      obj.Spec.CPU.MilliCores,
+		Weight:          obj.Spec.CPU.Weight,
+		Priority:        obj.Spec.Priority,
+		MinMilliCores:   obj.Spec.MinMilliCores,
+		BurstMilliCores: obj.Spec.BurstMilliCores,
+	}
+	if policy.Tenant == "" {
+		policy.Tenant = u.GetName()
+	}
+
+	switch {
+	case policy.MilliCores < 0 || policy.Weight < 0 ||
+		policy.MinMilliCores < 0 || policy.BurstMilliCores < 0:
+		return policy, fmt.Errorf("negative CPU")
+	case policy.MilliCores > 0 && policy.Weight > 0:
+		return policy, fmt.Errorf("both cpu.milliCores and cpu.weight are set")
+	case policy.BurstMilliCores > 0 &&
+		policy.BurstMilliCores < policy.MinMilliCores:
+		return policy, fmt.Errorf("burstMilliCores is below minMilliCores")
+	}
+	return policy, nil
+}
+
+// getFShareLoads returns the fair share (in percent of one core) of each of
+// the tenants out of the capacity of the hosts, from their policies (see
+// TenantPolicy). Without any policy, each tenant pod is entitled to its
+// FShare of its node instead.
+func getFShareLoads(nodes []Node, hostCaps map[string]float64,
+	tenants []string, policies map[string]TenantPolicy) map[string]float64 {
+
+	fshareLoads := make(map[string]float64)
+
+	if len(policies) == 0 {
+		for _, tenant := range tenants {
+			fshareLoads[tenant] = getFShareLoad(nodes, hostCaps, tenant)
+		}
+		return fshareLoads
+	}
+
+	capacity := 0.0
+	for _, hostCap := range hostCaps {
+		capacity += hostCap
+	}
+
+	// - Absolute entitlements
+	absolute := 0.0
+	totalWeight := 0.0
+	for _, tenant := range tenants {
+		policy, ok := policies[tenant]
+		switch {
+		case ok && policy.MilliCores > 0:
+			fshareLoads[tenant] = float64(policy.MilliCores) / 10.0
+			absolute += fshareLoads[tenant]
+		case ok && policy.Weight > 0:
+			totalWeight += policy.Weight
+		default:
+			totalWeight += DEFAULT_TENANT_WEIGHT
+		}
+	}
+
+	// - Relative entitlements and minimum guarantees
+	for _, tenant := range tenants {
+		policy, ok := policies[tenant]
+		if !ok || policy.MilliCores == 0 {
+			weight := DEFAULT_TENANT_WEIGHT
+			if ok && policy.Weight > 0 {
+				weight = policy.Weight
+			}
+			fshareLoads[tenant] = math.Max(0, capacity-absolute) *
+				weight / totalWeight
+		}
+		fshareLoads[tenant] = math.Max(fshareLoads[tenant],
+			float64(policy.MinMilliCores)/10.0)
+	}
+
+	// - Grant the entitlements by priority when they exceed the capacity
+	byPriority := make(map[int][]string)
+	priorities := make([]int, 0)
+	for _, tenant := range tenants {
+		priority := policies[tenant].Priority
+		if byPriority[priority] == nil {
+			priorities = append(priorities, priority)
+		}
+		byPriority[priority] = append(byPriority[priority], tenant)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	available := capacity
+	for _, priority := range priorities {
+		entitled := 0.0
+		for _, tenant := range byPriority[priority] {
+			entitled += fshareLoads[tenant]
+		}
+		if entitled > available {
+			slog.Warn(fmt.Sprintf("Fair shares of priority %d (%f) exceed "+
+				"the capacity left (%f)", priority, entitled, available))
+			for _, tenant := range byPriority[priority] {
+				fshareLoads[tenant] *= available / entitled
+			}
+			entitled = available
+		}
+		available -= entitled
+	}
+
+	return fshareLoads
+}
+
+// getBurstCappedLoad returns the tenant's load limited to its burst cap.
+func getBurstCappedLoad(
+	tenant string, load float64, policies map[string]TenantPolicy) float64 {
+
+	policy, ok := policies[tenant]
+	if !ok || policy.BurstMilliCores == 0 {
+		return load
+	}
+	return math.Min(load, float64(policy.BurstMilliCores)/10.0)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// how often the informers re-list nodes and pods in addition to the
+	// watch events
+	TOPOLOGY_RESYNC_PERIOD_MS = 60_000
+)
+
+/*
+Topology is a cache of the cluster's nodes and their pods, kept up to date
+by shared informers on nodes, pods and services (for the NodePorts of the
+host agents).
+
+What does Topology do:
+1. List nodes and pods once the informer caches are synced
+2. Repeat the following (Topology.Run):
+  - Wait for a node, pod or service add/update/delete event
+  - Rebuild the nodes and their pods from the informer caches
+  - Send updatePods to the host agents of the nodes whose pods changed
+  - Start supervising the host agents of new nodes and stop supervising
+    the ones of deleted nodes
+
+Nodes keep their index for the lifetime of the controller; deleted nodes
+stay in the list but are never healthy again. The optimization loops take a
+snapshot of the nodes every round (UtilizationFeed.NextRound), so changes
+take effect in the next round.
+*/
+type Topology struct {
+	mu    sync.Mutex
+	nodes []Node
+
+	k8sClient *KubernetesClient
+	factory   informers.SharedInformerFactory
+	changed   chan struct{}
+}
+
+// WatchTopology starts the node, pod and service informers and returns the
+// topology once their caches are synced.
+func (k8sClient *KubernetesClient) WatchTopology() *Topology {
+
+	t := &Topology{
+		k8sClient: k8sClient,
+		factory: informers.NewSharedInformerFactoryWithOptions(
+			k8sClient.clientset,
+			time.Duration(config.TopologyResyncPeriodMs)*time.Millisecond,
+			informers.WithNamespace("default")),
+		changed: make(chan struct{}, 1),
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.notifyChanged() },
+		UpdateFunc: func(oldObj, newObj interface{}) { t.notifyChanged() },
+		DeleteFunc: func(obj interface{}) { t.notifyChanged() },
+	}
+	t.factory.Core().V1().Nodes().Informer().AddEventHandler(handler)
+	t.factory.Core().V1().Pods().Informer().AddEventHandler(handler)
+	t.factory.Core().V1().Services().Informer().AddEventHandler(handler)
+
+	t.factory.Start(nil)
+	for informerType, synced := range t.factory.WaitForCacheSync(nil) {
+		if !synced {
+			panic(fmt.Sprintf("Failed to sync informer for %v", informerType))
+		}
+	}
+
+	nodesToPods := t.listNodesToPods()
+	for _, node := range t.listNodes() {
+		t.nodes = append(t.nodes, t.newNode(node, nodesToPods[node.Name]))
+	}
+
+	return t
+}
+
+func (t *Topology) notifyChanged() {
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Nodes returns a snapshot of the nodes. The snapshot is never modified, so
+// it can be used for a whole round.
+func (t *Topology) Nodes() []Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Node(nil), t.nodes...)
+}
+
+// Node returns a snapshot of the node at nodeIdx.
+func (t *Topology) Node(nodeIdx int) Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nodes[nodeIdx]
+}
+
+// Run applies topology changes as they are observed. supervise is started
+// in a new goroutine for every node added to the cluster.
+func (t *Topology) Run(supervise func(nodeIdx int)) {
+	for range t.changed {
+		t.update(supervise)
+	}
+}
+
+func (t *Topology) update(supervise func(nodeIdx int)) {
+
+	nodesToPods := t.listNodesToPods()
+	clusterNodes := make(map[string]*v1.Node)
+	for _, node := range t.listNodes() {
+		clusterNodes[node.Name] = node
+	}
+
+	// the nodes whose pods or address changed and the ones that were added
+	// or removed
+	updatedNodes := make([]Node, 0)
+	movedNodes := make([]Node, 0)
+	addedNodes := make([]int, 0)
+	removedNodes := make([]Node, 0)
+
+	// Run is the only writer of t.nodes, so the snapshot stays current while
+	// the new nodes are built
+	nodes := t.Nodes()
+	for i := range nodes {
+		if nodes[i].IsRemoved() {
+			continue
+		}
+
+		clusterNode, ok := clusterNodes[nodes[i].Name]
+		if !ok {
+			removedNodes = append(removedNodes, nodes[i])
+			continue
+		}
+		delete(clusterNodes, nodes[i].Name)
+
+		updated := t.newNode(clusterNode, nodesToPods[nodes[i].Name])
+		updated.conn = nodes[i].conn
+		if updated.IP != nodes[i].IP ||
+			updated.HostAgentNodePort != nodes[i].HostAgentNodePort {
+			movedNodes = append(movedNodes, updated)
+		}
+		if !reflect.DeepEqual(updated.Pods, nodes[i].Pods) {
+			updatedNodes = append(updatedNodes, updated)
+		}
+		nodes[i] = updated
+	}
+	for _, clusterNode := range clusterNodes {
+		addedNodes = append(addedNodes, len(nodes))
+		nodes = append(nodes,
+			t.newNode(clusterNode, nodesToPods[clusterNode.Name]))
+	}
+
+	t.mu.Lock()
+	t.nodes = nodes
+	t.mu.Unlock()
+
+	for _, node := range removedNodes {
+		slog.Info(fmt.Sprintf("Node %s (%s) was removed", node.Name, node.IP))
+		node.Remove()
+	}
+
+	// - Make the supervisors of moved nodes reconnect to the new address
+	for _, node := range movedNodes {
+		slog.Info(fmt.Sprintf("Node %s moved to %s:%d",
+			node.Name, node.IP, node.HostAgentNodePort))
+		node.Disconnect()
+	}
+
+	for _, nodeIdx := range addedNodes {
+		slog.Info(fmt.Sprintf("Node %s was added", nodes[nodeIdx].Name))
+		go supervise(nodeIdx)
+	}
+
+	// - Send updatePods to the host agents of the nodes whose pods changed
+	// (a host agent that is not connected gets them when it reconnects)
+	for _, node := range updatedNodes {
+		slog.Info(fmt.Sprintf("Pods on node %s changed: %v",
+			node.Name, node.Pods))
+		if node.getClient() == nil {
+			continue
+		}
+		err := node.UpdatePods()
+		if err != nil {
+			// make the supervisor reconnect and replay updatePods
+			node.Disconnect()
+		}
+	}
+}
+
+// newNode returns the Node for a cluster node running pods, with the NodePort
+// of its host agent from the service informer's cache. A node without one
+// (e.g. its service is not created yet) gets NodePort 0 and cannot connect
+// until an update finds it.
+func (t *Topology) newNode(node *v1.Node, pods map[string]Pod) Node {
+	services := t.factory.Core().V1().Services().Lister().Services("default")
+	nodePort, err := getHostAgentNodePort(*node, services.Get)
+	if err != nil {
+		slog.Warn(err.Error())
+	}
+	return newNode(node, pods, nodePort)
+}
+
+func (t *Topology) listNodes() []*v1.Node {
+	nodes, err := t.factory.Core().V1().Nodes().Lister().List(
+		labels.Everything())
+	check(err)
+	return nodes
+}
+
+func (t *Topology) listNodesToPods() map[string]map[string]Pod {
+	pods, err := t.factory.Core().V1().Pods().Lister().List(
+		labels.Everything())
+	check(err)
+	return getNodesToPodMap(pods, t.k8sClient.TenantLabel)
+}
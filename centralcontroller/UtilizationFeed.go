@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// UtilizationFeed merges the CPU Utilizations that the host agents of all
+// nodes push on their StreamCPUUtilizations streams.
+type UtilizationFeed struct {
+	topology      *Topology
+	samples       chan CPUUtil
+	healthChanged chan struct{}
+}
+
+// NewUtilizationFeed starts supervising the host agents of the nodes in
+// topology, including the ones added later, and returns the feed their CPU
+// Utilizations are pushed to.
+func NewUtilizationFeed(topology *Topology) *UtilizationFeed {
+	nodes := topology.Nodes()
+	feed := &UtilizationFeed{
+		topology:      topology,
+		samples:       make(chan CPUUtil, len(nodes)),
+		healthChanged: make(chan struct{}, 1),
+	}
+	for i := range nodes {
+		go feed.supervise(i)
+	}
+	go topology.Run(feed.supervise)
+	return feed
+}
+
+func (f *UtilizationFeed) notifyHealthChanged() {
+	select {
+	case f.healthChanged <- struct{}{}:
+	default:
+	}
+}
+
+// NextRound blocks until every healthy node has pushed a new sample since
+// the last round (and at least one node has), and returns the nodes of the
+// round with the CPU Utilizations, their statistics (see Estimator.go),
+// resource usages (see Resources.go) and unmanaged CPU Utilization of the
+// latest sample of each node. Nodes that did
+// not report this round have a nil entry (and 0 unmanaged). It returns no
+// nodes if ctx is done first.
+func (f *UtilizationFeed) NextRound(ctx context.Context) (
+	[]Node, []map[string]float64, []map[string]CPUStatistics,
+	[]map[string]ResourceUsages, []float64) {
+
+	roundStart := time.Now()
+	nodes := f.topology.Nodes()
+	nodeCPUUtilizations := make([]map[string]float64, len(nodes))
+	nodeCPUStatistics := make([]map[string]CPUStatistics, len(nodes))
+	nodeResourceUsages := make([]map[string]ResourceUsages, len(nodes))
+	nodeUnmanagedUtilizations := make([]float64, len(nodes))
+	for !roundComplete(nodes, nodeCPUUtilizations) {
+		select {
+		case cpuUtil := <-f.samples:
+			if cpuUtil.Node >= len(nodes) {
+				// added after the round started, joins the next round
+				continue
+			}
+			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
+			nodeCPUStatistics[cpuUtil.Node] = cpuUtil.CPUStatistics
+			nodeResourceUsages[cpuUtil.Node] = cpuUtil.ResourceUsages
+			nodeUnmanagedUtilizations[cpuUtil.Node] =
+				cpuUtil.UnmanagedUtilization
+			nodeUnmanagedLoadMetric.Set(cpuUtil.UnmanagedUtilization,
+				nodes[cpuUtil.Node].Name)
+			nodeCollectionLatencyMetric.Set(
+				time.Since(roundStart).Seconds(), nodes[cpuUtil.Node].Name)
+			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %v",
+				cpuUtil.Node, cpuUtil.CPUUtilizations))
+		case <-f.healthChanged:
+		case <-ctx.Done():
+			return nil, nil, nil, nil, nil
+		}
+	}
+	return nodes, nodeCPUUtilizations, nodeCPUStatistics, nodeResourceUsages,
+		nodeUnmanagedUtilizations
+}
+
+func roundComplete(
+	nodes []Node, nodeCPUUtilizations []map[string]float64) bool {
+
+	reported := 0
+	for i := range nodes {
+		if nodeCPUUtilizations[i] != nil {
+			reported++
+		} else if nodes[i].IsHealthy() {
+			return false
+		}
+	}
+	return reported > 0
+}
@@ -3,56 +3,89 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
+	"maps"
+	"math"
 	"net/http"
-	"net/url"
 	"os"
-	"regexp"
-	"sort"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"protocol"
 )
 
 const (
 	CFS_PERIOD_US     = 100000
-	CPUS_IN_NODE      = 2
 	MINIMUM_CPU_QUOTA = 1000
 
+	// cpu.shares of one core (as set by the kubelet) and the least cpu.shares
+	// of a pod
+	CPU_SHARES_PER_CORE = 1024
+	MINIMUM_CPU_SHARES  = 2
+
+	// the metrics are served on this port (see Metrics.go)
 	SERVER_PORT = "9988"
-	SERVER_TYPE = "tcp"
 
+	// defaults of the configuration, see Config.go
 	ROUNDS_FOR_ROLLING_AVG_OF_CPU_UTILS = 50
+	ESTIMATOR                           = "MEAN" // default of -estimator, see Estimator.go
+	EWMA_HALF_LIFE_ROUNDS               = 10
+	ESTIMATOR_PERCENTILE                = 90
+	HOLT_ALPHA                          = 0.5
+	HOLT_BETA                           = 0.3
+	LB_WEIGHT_THRESHOLD                 = 0   // see Damping.go
+	LB_WEIGHT_MAX_STEP                  = 100 // no limit
+	LB_WEIGHT_INTERPOLATION_ROUNDS      = 1   // at once
 	DURATION_FOR_ONE_ROUND_MS           = 1000
-	OVERHEAD                            = 5    // 10% overhead
-	POD_QUOTA_OVERHEAD                  = 10   // 5% overhead
+	CPU_UTILIZATION_WINDOW_MS           = 0    // one round
+	OVERHEAD                            = 5    // 5% of a core on every load
+	POD_QUOTA_OVERHEAD                  = 10   // 10% of the node's quota
 	NOISE                               = 2    // 2% noise
-	ENFORCEMENT                         = "LB" // CPU_QUOTA | CPU_SHARE | BOTH | NONE | LB
+	ENFORCEMENT                         = "LB" // default of -enforcement, see Enforcer.go
 	USE_PRESET_SHARES                   = false
-
-	DEFAULT_LB_WEIGHTS                  = ""
-	LOG_FILE_PREFIX                     = "/home/twaheed2/go/src/multiparty-lb/"
-	DURATION_THAT_THIS_FILE_WILL_RUN_MS = 80_000
+	SOLVER                              = "GO" // GO | GUROBI
+	TENANT_LABEL                        = "mplb.io/tenant"
+	GUROBI_SERVER_URL                   = "http://localhost:5000/"
+	SOLVER_TIMEOUT_MS                   = 500             // see Fallback.go
+	OBJECTIVE                           = "MIN_MAX_SPARE" // see Objective.go
+	NODE_NETWORK_BYTES_PER_SEC          = 0               // not constrained, see Resources.go
+
+	DEFAULT_LB_WEIGHTS = ""
+	LOG_FILE           = "" // stdout
+	KUBECONFIG         = "" // ~/.kube/config, or in-cluster
+	RUN_DURATION_MS    = 0  // forever
+	SHADOW_CONFIG      = "" // no shadow mode
+	LISTEN_ADDRESS     = ":" + SERVER_PORT
 )
 
 /*
 What does cc do:
+0. Watch the cluster's nodes and pods (see Topology.go)
 1. Connect to all host agents
 2. Send messages to host agents to update pod state
-3. Repeat the following:
-	- Get CPU Utilizations from host agents
-	- Solve the optimization problem by connection to Gurobi Optimizer
-	- Send the CPU shares to the host agents to be applied
+3. Subscribe to the CPU Utilizations pushed by the host agents
+	(1-3 are repeated per host agent whenever its connection is lost,
+	see NodeConnection.go)
+4. Repeat the following (with the Enforcer picked by -enforcement):
+	- Wait for every healthy host agent to push new CPU Utilizations
+	- Solve the optimization problem with the configured Solver
+	  (and with the candidate policy in shadow mode, see Shadow.go)
+	- Send the decision to the host agents to be applied
+5. On SIGINT/SIGTERM, at the end of the run duration or if a round panics,
+	reset the host agents to a neutral state, flush the log and disconnect
 */
 
 type Pod struct {
 	Name           string
 	AppName        string
+	Tenant         string // "" = no tenant label, only background load
 	FShare         float64
 	CGroupFilePath string
 }
@@ -64,59 +97,38 @@ type Node struct {
 	HostAgentNodePort int
 	Pods              map[string]Pod
 	MilliCores        int
+	MemoryBytes       int64
 
-	connection *net.Conn
-}
-
-func (n *Node) Connect() {
-	connection, err := net.Dial(SERVER_TYPE,
-		fmt.Sprintf("%s:%d", n.IP, n.HostAgentNodePort))
-	if err != nil {
-		panic(err)
-	}
-	n.connection = &connection
-}
-
-func (n *Node) Disconnect() {
-	(*n.connection).Close()
-}
-
-func (n *Node) SendMessageAndGetResponse(msg string) string {
-
-	slog.Info(fmt.Sprintf("conn: %v", n.connection))
-
-	_, err := (*n.connection).Write([]byte(msg))
-	if err != nil {
-		slog.Warn("Error sending:" + err.Error())
-	}
-	slog.Info("Sent: " + msg)
-	buffer := make([]byte, 4096)
-	mLen, err := (*n.connection).Read(buffer)
-	if err != nil {
-		slog.Warn("Error reading:" + err.Error())
-	}
-	slog.Info("Received: " + string(buffer[:mLen]))
-	return string(buffer[:mLen])
+	conn *nodeConn
 }
 
 type LogFile struct {
 	logWriter *bufio.Writer
+	file      *os.File // nil = stdout
 }
 
-func (l *LogFile) Initialize(logType string) {
-	var filename string
-	var runNum int
-	fmt.Println("Enter log folder's name and run number:")
-	fmt.Scan(&filename, &runNum)
-
-	logFileName := fmt.Sprintf(
-		"%s/%s/none_%s_%d", LOG_FILE_PREFIX, filename, logType, runNum)
+// Initialize opens the log file at path, or stdout if path is "".
+func (l *LogFile) Initialize(path string) {
+	if path == "" {
+		l.logWriter = bufio.NewWriter(os.Stdout)
+		return
+	}
 
-	logFile, err := os.Create(logFileName)
+	logFile, err := os.Create(path)
 	check(err)
+	l.file = logFile
 	l.logWriter = bufio.NewWriter(logFile)
 }
 
+// Close flushes the log and closes the log file.
+func (l *LogFile) Close() {
+	l.logWriter.Flush()
+	if l.file != nil {
+		l.file.Sync()
+		l.file.Close()
+	}
+}
+
 func (l *LogFile) Writeln(msg string) {
 	fmt.Fprintf(l.logWriter, "%s\n", msg)
 	l.logWriter.Flush()
@@ -124,338 +136,119 @@ func (l *LogFile) Writeln(msg string) {
 
 type CPUUtil struct {
 	Node            int
-	CPUUtilizations string
+	CPUUtilizations map[string]float64
+	// percentiles and other windows of the CPU Utilizations of the pods
+	// (see Estimator.go)
+	CPUStatistics map[string]CPUStatistics
+	// memory and network usage of the pods (see Resources.go)
+	ResourceUsages map[string]ResourceUsages
+	// CPU Utilization of the node outside the pods (system services, pods
+	// of other namespaces)
+	UnmanagedUtilization float64
 }
 
 func main() {
 
+	// centralcontroller replay ... replays a recorded run (see Replay.go)
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
+	// Read the config file and flags (see Config.go)
+	var err error
+	config, err = loadConfig(
+		flag.NewFlagSet("centralcontroller", flag.ExitOnError), os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err.Error())
+		os.Exit(2)
+	}
+	slog.Info(fmt.Sprintf("Configuration: %+v", config))
+
+	enforcer, err := NewEnforcer(config.Enforcement,
+		NewSolver(config), config)
+	check(err)
+	shadow, err := newShadowPolicy(config)
+	check(err)
+
 	// Initialize log file write
 	cpuLogFile := new(LogFile)
-	cpuLogFile.Initialize("CPU")
+	cpuLogFile.Initialize(config.LogFile)
 
 	// Initialize KubernetesClient
-	k8sClient := new(KubernetesClient)
-	k8sClient.Initialize()
+	k8sClient := &KubernetesClient{TenantLabel: config.TenantLabel}
+	check(k8sClient.Initialize(config.Kubeconfig))
 
-	// Initialize nodes
-	nodes := k8sClient.GetNodes()
+	// Initialize nodes and watch them and their pods for changes
+	topology := k8sClient.WatchTopology()
+	nodes := topology.Nodes()
+	tenantPolicies := k8sClient.WatchTenantPolicies()
 	fmt.Printf("Nodes:\n")
 	for i, node := range nodes {
 		fmt.Printf("Node %d:\n%v\n\n", i, node)
 	}
 
-	// Connect to all host agents
-	for i := range nodes {
-		nodes[i].Connect()
-	}
-
-	// Defer disconnecting from all host agents
-	defer func() {
-		for _, node := range nodes {
-			node.Disconnect()
-		}
+	// Serve the metrics of the rounds and the admin API
+	http.HandleFunc("/metrics", serveMetrics)
+	serveAdmin(http.DefaultServeMux, topology)
+	go func() {
+		err := http.ListenAndServe(config.ListenAddress, nil)
+		slog.Error("HTTP server stopped: " + err.Error())
 	}()
 
-	// Send messages to host agents to update pod state
-	for i := range nodes {
-		msg := "updatePods"
-		for podName, pod := range nodes[i].Pods {
-			msg += " " + podName + ":" + pod.CGroupFilePath
-		}
-		slog.Info("msg: " + msg)
-		response := nodes[i].SendMessageAndGetResponse(msg)
-		if response != "Success" {
-			panic("Failed to update pod state on node: " + nodes[i].IP)
-		}
+	// Connect to all host agents, update their pod state and subscribe to
+	// the CPU Utilizations they push
+	feed := NewUtilizationFeed(topology)
+
+	// Wait for the host agents to come up; the ones that do not are left
+	// out until they recover
+	waitUntilHealthy(nodes,
+		time.Duration(config.NodeStartupTimeoutMs)*time.Millisecond)
+
+	// update with the default values of the enforcement mechanism
+	enforcer.SetDefaults(nodes)
+
+	// Run until SIGINT/SIGTERM or the end of the run duration
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.RunDurationMs != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx,
+			time.Duration(config.RunDurationMs)*time.Millisecond)
+		defer cancel()
 	}
 
-	setDefaultLBWeights(nodes, cpuLogFile)
-
-	if ENFORCEMENT == "NONE" {
-
-		go ccWithNoEnforcement(cpuLogFile, nodes)
-
-	} else if ENFORCEMENT == "LB" {
-
-		go ccWithLBEnforcement(cpuLogFile, nodes)
-
-	} else {
-
-		// update with default values of the cpu quotas and shares
-		setDefaultCPUQuotas(nodes, cpuLogFile)
-		setDefaultCPUShares(nodes, cpuLogFile)
-
-		if ENFORCEMENT == "CPU_QUOTA" {
-			slog.Info("Enforcing CPU Quotas")
-			go ccWithCPUQuotas(cpuLogFile, nodes)
-		} else if ENFORCEMENT == "CPU_SHARE" {
-			slog.Info("Enforcing CPU Shares")
-			go ccWithCPUShares(cpuLogFile, nodes)
-		} else if ENFORCEMENT == "BOTH" {
-			slog.Info("Enforcing CPU Quotas and Shares")
-			go ccWithBoth(cpuLogFile, nodes)
-		} else {
-			panic("Invalid enforcement type")
-		}
-	}
-
-	time.Sleep(DURATION_THAT_THIS_FILE_WILL_RUN_MS * time.Millisecond)
-}
-
-func ccWithNoEnforcement(cpuLogFile *LogFile, nodes []Node) {
-
-	// Repeat the following:
-	// - Get CPU Utilizations from host agents
-	for {
-
-		// - Get CPU Utilizations from host agents
-		cpuUtilizationCh := make(chan CPUUtil)
-		for i := range nodes {
-			msg := "getCPUUtilizations"
-			go func(i int, node Node) {
-				cpuUtilizations := node.SendMessageAndGetResponse(msg)
-				cpuUtilizationCh <- CPUUtil{i, cpuUtilizations}
-			}(i, nodes[i])
-		}
-		nodeCPUUtilizations := make([]string, len(nodes))
-		for range nodes {
-			cpuUtil := <-cpuUtilizationCh
-			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
-			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %s",
-				cpuUtil.Node, cpuUtil.CPUUtilizations))
-		}
-
-		// log the CPU Utilizations and CPU Shares
-		cpuLogFile.Writeln(getLogFileFormatNoEnforcement(nodeCPUUtilizations))
-	}
-}
-
-func ccWithLBEnforcement(cpuLogFile *LogFile, nodes []Node) {
-
-	// Initialize past CPU Utilizations
-	roundsAppCPUUtils := make([]map[string]float64, 0)
-
-	// Repeat the following:
-	// - Get CPU Utilizations from host agents
-	for {
-
-		// - Get CPU Utilizations from host agents
-		cpuUtilizationCh := make(chan CPUUtil)
-		for i := range nodes {
-			msg := "getCPUUtilizations"
-			go func(i int, node Node) {
-				cpuUtilizations := node.SendMessageAndGetResponse(msg)
-				cpuUtilizationCh <- CPUUtil{i, cpuUtilizations}
-			}(i, nodes[i])
-		}
-		nodeCPUUtilizations := make([]string, len(nodes))
-		for range nodes {
-			cpuUtil := <-cpuUtilizationCh
-			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
-			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %s",
-				cpuUtil.Node, cpuUtil.CPUUtilizations))
-		}
-
-		// - Solve the optimization problem by connection to Gurobi Optimizer
-		lbWeights, newRoundsAppCPUUtils := getOptimalLBWeights(
-			nodes, nodeCPUUtilizations, roundsAppCPUUtils)
-		roundsAppCPUUtils = newRoundsAppCPUUtils
-
-		// log the CPU Utilizations and CPU Shares
-		cpuLogFile.Writeln(
-			getLogFileFormatLBEnforcement(nodeCPUUtilizations, lbWeights))
-
-		// lbWeights := getLBWeights()
-		// lbWeights := "profile:0.0|100.0 frontend:0.0|100.0 recommendation:100.0"
-		// - Send the CPU Quotas to the host agents to be applied
-		for i := range nodes {
-			msg := "applyLBWeights " + lbWeights
-			response := nodes[i].SendMessageAndGetResponse(msg)
-			if response != "Success" {
-				slog.Warn("Failed to apply CPU Quotas on node: " +
-					nodes[i].IP)
-			}
-		}
-	}
-}
-
-func ccWithCPUShares(cpuLogFile *LogFile, nodes []Node) {
-
-	// Initialize past CPU Utilizations
-	roundsAppCPUUtils := make([]map[string]float64, 0)
-
-	// Repeat the following:
-	// - Get CPU Utilizations from host agents
-	// - Solve the optimization problem by connection to Gurobi Optimizer
-	// - Send the CPU shares to the host agents to be applied
-	for {
-
-		// - Get CPU Utilizations from host agents
-		cpuUtilizationCh := make(chan CPUUtil)
-		for i := range nodes {
-			msg := "getCPUUtilizations"
-			go func(i int, node Node) {
-				cpuUtilizations := node.SendMessageAndGetResponse(msg)
-				cpuUtilizationCh <- CPUUtil{i, cpuUtilizations}
-			}(i, nodes[i])
-		}
-		nodeCPUUtilizations := make([]string, len(nodes))
-		for range nodes {
-			cpuUtil := <-cpuUtilizationCh
-			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
-			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %s",
-				cpuUtil.Node, cpuUtil.CPUUtilizations))
-		}
-
-		// - Solve the optimization problem by connection to Gurobi Optimizer
-		nodeCPUShares, newRoundsAppCPUUtils := getOptimalCPUShares(
-			nodeCPUUtilizations, roundsAppCPUUtils)
-		roundsAppCPUUtils = newRoundsAppCPUUtils
-
-		// log the CPU Utilizations and CPU Shares
-		cpuLogFile.Writeln(getLogFileFormat(nodeCPUUtilizations, nodeCPUShares))
-
-		// - Send the CPU shares to the host agents to be applied
-		if nodeCPUShares == nil {
-			slog.Warn("Failed to get optimal CPU shares")
-		} else {
-			for i := range nodes {
-				msg := "applyCPUShares " + nodeCPUShares[i]
-				response := nodes[i].SendMessageAndGetResponse(msg)
-				if response != "Success" {
-					slog.Warn("Failed to apply CPU shares on node: " +
-						nodes[i].IP)
-				}
+	slog.Info("Enforcing " + config.Enforcement)
+	enforcerDone := make(chan struct{})
+	go func() {
+		defer close(enforcerDone)
+		// leave the host agents in a neutral state even if a round panics
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error(fmt.Sprintf("Round failed: %v", r))
+				shutdown(topology, cpuLogFile)
+				panic(r)
 			}
-		}
-	}
-}
-
-func ccWithCPUQuotas(cpuLogFile *LogFile, nodes []Node) {
-
-	// Initialize past CPU Utilizations
-	roundsAppCPUUtils := make([]map[string]float64, 0)
-
-	// Repeat the following:
-	// - Get CPU Utilizations from host agents
-	// - Solve the optimization problem by connection to Gurobi Optimizer
-	// - Send the CPU shares to the host agents to be applied
-	for {
-
-		// - Get CPU Utilizations from host agents
-		cpuUtilizationCh := make(chan CPUUtil)
-		for i := range nodes {
-			msg := "getCPUUtilizations"
-			go func(i int, node Node) {
-				cpuUtilizations := node.SendMessageAndGetResponse(msg)
-				cpuUtilizationCh <- CPUUtil{i, cpuUtilizations}
-			}(i, nodes[i])
-		}
-		nodeCPUUtilizations := make([]string, len(nodes))
-		for range nodes {
-			cpuUtil := <-cpuUtilizationCh
-			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
-			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %s",
-				cpuUtil.Node, cpuUtil.CPUUtilizations))
-		}
-
-		// - Solve the optimization problem by connection to Gurobi Optimizer
-		nodeCPUQuotas, newRoundsAppCPUUtils := getOptimalCPUQuotas(
-			nodeCPUUtilizations, roundsAppCPUUtils)
-		roundsAppCPUUtils = newRoundsAppCPUUtils
-
-		// log the CPU Utilizations and CPU Quotas
-		cpuLogFile.Writeln(
-			getLogFileFormatForCPUQuotas(nodeCPUUtilizations, nodeCPUQuotas))
+		}()
+		runEnforcer(ctx, cpuLogFile, feed, tenantPolicies, enforcer, shadow)
+	}()
+	<-enforcerDone
 
-		// - Send the CPU Quotas to the host agents to be applied
-		if nodeCPUQuotas == nil {
-			slog.Warn("Failed to get optimal CPU Quotas")
-		} else {
-			for i := range nodes {
-				msg := "applyCPUQuotas " + nodeCPUQuotas[i]
-				response := nodes[i].SendMessageAndGetResponse(msg)
-				if response != "Success" {
-					slog.Warn("Failed to apply CPU Quotas on node: " +
-						nodes[i].IP)
-				}
-			}
-		}
-	}
+	slog.Info("Shutting down")
+	shutdown(topology, cpuLogFile)
 }
 
-func ccWithBoth(cpuLogFile *LogFile, nodes []Node) {
-
-	// Initialize past CPU Utilizations
-	roundsAppCPUUtils := make([]map[string]float64, 0)
-
-	// Repeat the following:
-	// - Get CPU Utilizations from host agents
-	// - Solve the optimization problem by connection to Gurobi Optimizer
-	// - Send the CPU shares to the host agents to be applied
-	for {
-
-		// - Get CPU Utilizations from host agents
-		cpuUtilizationCh := make(chan CPUUtil)
-		for i := range nodes {
-			msg := "getCPUUtilizations"
-			go func(i int, node Node) {
-				cpuUtilizations := node.SendMessageAndGetResponse(msg)
-				cpuUtilizationCh <- CPUUtil{i, cpuUtilizations}
-			}(i, nodes[i])
-		}
-		nodeCPUUtilizations := make([]string, len(nodes))
-		for range nodes {
-			cpuUtil := <-cpuUtilizationCh
-			nodeCPUUtilizations[cpuUtil.Node] = cpuUtil.CPUUtilizations
-			slog.Info(fmt.Sprintf("CPU Utilizations [Node %d]: %s",
-				cpuUtil.Node, cpuUtil.CPUUtilizations))
-		}
-
-		// - Solve the optimization problem by connection to Gurobi Optimizer
-		nodeCPUQuotas, newRoundsAppCPUUtils := getOptimalCPUQuotas(
-			nodeCPUUtilizations, roundsAppCPUUtils)
-		roundsAppCPUUtils = newRoundsAppCPUUtils
-
-		// log the CPU Utilizations and CPU Quotas
-		cpuLogFile.Writeln(
-			getLogFileFormatForCPUQuotas(nodeCPUUtilizations, nodeCPUQuotas))
-
-		// - Send the CPU Quotas to the host agents to be applied
-		if nodeCPUQuotas == nil {
-			slog.Warn("Failed to get optimal CPU Quotas")
-		} else {
-			for i := range nodes {
-				msg := "applyCPUQuotas " + nodeCPUQuotas[i]
-				response := nodes[i].SendMessageAndGetResponse(msg)
-				if response != "Success" {
-					slog.Warn("Failed to apply CPU Quotas on node: " +
-						nodes[i].IP)
-				}
-			}
-		}
-
-		// - Solve the optimization problem by connection to Gurobi Optimizer
-		nodeCPUShares, newRoundsAppCPUUtils := getOptimalCPUShares(
-			nodeCPUUtilizations, roundsAppCPUUtils)
-		roundsAppCPUUtils = newRoundsAppCPUUtils
-
-		// log the CPU Utilizations and CPU Shares
-		cpuLogFile.Writeln(getLogFileFormat(nodeCPUUtilizations, nodeCPUShares))
-
-		// - Send the CPU shares to the host agents to be applied
-		if nodeCPUShares == nil {
-			slog.Warn("Failed to get optimal CPU shares")
-		} else {
-			for i := range nodes {
-				msg := "applyCPUShares " + nodeCPUShares[i]
-				response := nodes[i].SendMessageAndGetResponse(msg)
-				if response != "Success" {
-					slog.Warn("Failed to apply CPU shares on node: " +
-						nodes[i].IP)
-				}
-			}
-		}
+// shutdown resets the host agents to a neutral state (see Reset in
+// protocol.go), flushes the log and disconnects from the host agents.
+func shutdown(topology *Topology, cpuLogFile *LogFile) {
+	nodes := topology.Nodes()
+	applyToHealthyNodes(nodes, "reset", func(i int, node *Node) error {
+		return node.Reset()
+	})
+	cpuLogFile.Close()
+	for _, node := range nodes {
+		node.Disconnect()
 	}
 }
 
@@ -470,335 +263,241 @@ func makeNoiseZero(
 }
 
 func getOptimalCPUQuotas(
-	nodeCPUUtilizations []string,
-	roundsAppCPUUtils []map[string]float64) ([]string, []map[string]float64) {
+	solver Solver,
+	nodes []Node,
+	nodeCPUUtilizations []map[string]float64,
+	nodeCPUStatistics []map[string]CPUStatistics,
+	nodeResourceUsages []map[string]ResourceUsages,
+	nodeUnmanagedUtilizations []float64,
+	tenantPolicies map[string]TenantPolicy,
+	pins map[string]Pin,
+	loadEstimators *LoadEstimators) (
+	[]map[string]int64, string, *LoadEstimators) {
 
 	// parse current cpu utilizations
-	currentAppUtils := getPerAppUtilizations(nodeCPUUtilizations)
-	effectiveAppUtils := makeNoiseZero(currentAppUtils, NOISE)
-	effectiveAppUtils = addOverhead(effectiveAppUtils, OVERHEAD)
-
-	// get rolling average
-	avgAppUtils, newRoundsAppCPUUtils := getRollingAverage(
-		effectiveAppUtils, roundsAppCPUUtils)
-
-	// avgAppUtils = map[int]float64{
-	// 	1: 300.0,
-	// 	2: 200.0,
-	// 	3: 100.0,
-	// }
-
-	// get weights from gurobi
-	gurobiResponse := getWeightsFromGurobi(200.0, avgAppUtils)
-
-	// get cpu shares
-	nodeCPUShares := getNodeCPUQuotas(gurobiResponse)
-
-	return nodeCPUShares, newRoundsAppCPUUtils
+	currentAppUtils := getPerTenantUtilizations(nodes, nodeCPUUtilizations)
+	setTenantMetric(tenantLoadMetric, currentAppUtils)
+	backgroundUtils := getBackgroundUtilizations(
+		nodes, nodeCPUUtilizations, nodeUnmanagedUtilizations)
+	resources := getRoundResources(
+		nodes, nodeCPUUtilizations, nodeResourceUsages)
+	setTenantResourceMetric(resources.tenantUsages)
+	effectiveAppUtils := makeNoiseZero(currentAppUtils, config.NoisePercent)
+	effectiveAppUtils = addOverhead(effectiveAppUtils, config.OverheadPercent,
+		config.TenantOverheadPercent)
+	tenantStatistics := getPerTenantCPUStatistics(nodes, nodeCPUUtilizations,
+		nodeCPUStatistics, effectiveAppUtils)
+
+	// get estimated loads (see Estimator.go)
+	avgAppUtils, newLoadEstimators := getLoadEstimates(
+		effectiveAppUtils, tenantStatistics, loadEstimators)
+	setTenantMetric(tenantLoadAverageMetric, avgAppUtils)
+
+	// get weights from the solver
+	// (only over the nodes that reported this round)
+	gurobiResponse, solvedBy := getGenericWeights(solver,
+		healthyNodes(nodes, nodeCPUUtilizations), avgAppUtils, backgroundUtils,
+		resources, tenantPolicies, pins)
+
+	// get cpu quotas
+	nodeCPUQuotas := getNodeCPUQuotas(nodes, gurobiResponse)
+	setPinnedCPUQuotas(nodes, nodeCPUQuotas, pins)
+
+	return nodeCPUQuotas, solvedBy, newLoadEstimators
 }
 
 func getOptimalLBWeights(
+	solver Solver,
 	nodes []Node,
-	nodeCPUUtilizations []string,
-	roundsAppCPUUtils []map[string]float64) (string, []map[string]float64) {
+	nodeCPUUtilizations []map[string]float64,
+	nodeCPUStatistics []map[string]CPUStatistics,
+	nodeResourceUsages []map[string]ResourceUsages,
+	nodeUnmanagedUtilizations []float64,
+	tenantPolicies map[string]TenantPolicy,
+	pins map[string]Pin,
+	loadEstimators *LoadEstimators) (string, string, *LoadEstimators) {
 
 	// parse current cpu utilizations
-	currentAppUtils := getPerAppUtilizations(nodeCPUUtilizations)
-	// effectiveAppUtils := makeNoiseZero(currentAppUtils, NOISE)
-	// effectiveAppUtils = addOverhead(effectiveAppUtils, OVERHEAD)
-
-	// get rolling average
-	avgAppUtils, newRoundsAppCPUUtils := getRollingAverage(
-		currentAppUtils, roundsAppCPUUtils)
-
-	// get weights from gurobi
-	gurobiResponse := getGenericWeightsFromGurobi(nodes, avgAppUtils)
-
-	lbWeights := parseGurobiResponse(gurobiResponse)
-
-	// return "profile:0.0|100.0 frontend:0.0|100.0 recommendation:100.0",
-	// 	newRoundsAppCPUUtils
-
-	return lbWeights, newRoundsAppCPUUtils
+	currentAppUtils := getPerTenantUtilizations(nodes, nodeCPUUtilizations)
+	setTenantMetric(tenantLoadMetric, currentAppUtils)
+	backgroundUtils := getBackgroundUtilizations(
+		nodes, nodeCPUUtilizations, nodeUnmanagedUtilizations)
+	resources := getRoundResources(
+		nodes, nodeCPUUtilizations, nodeResourceUsages)
+	setTenantResourceMetric(resources.tenantUsages)
+
+	tenantStatistics := getPerTenantCPUStatistics(nodes, nodeCPUUtilizations,
+		nodeCPUStatistics, currentAppUtils)
+
+	// get estimated loads (see Estimator.go)
+	avgAppUtils, newLoadEstimators := getLoadEstimates(
+		currentAppUtils, tenantStatistics, loadEstimators)
+	setTenantMetric(tenantLoadAverageMetric, avgAppUtils)
+
+	// get weights from the solver
+	// (only over the nodes that reported this round)
+	gurobiResponse, solvedBy := getGenericWeights(solver,
+		healthyNodes(nodes, nodeCPUUtilizations), avgAppUtils, backgroundUtils,
+		resources, tenantPolicies, pins)
+
+	lbWeights := parseGurobiResponse(gurobiResponse, pins)
+	recordLBWeights(gurobiResponse, pins)
+
+	return lbWeights, solvedBy, newLoadEstimators
 }
 
-func getValuesFromMapSortedByKeys(m map[string]float64) []float64 {
-	var keys []string
-	for k := range m {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+// parseGurobiResponse returns the LB weights of the pods of every tenant, in
+// percent of the load the solver assigned to the tenant (see lbweights.go in
+// protocol for the format).
+func parseGurobiResponse(
+	response GurobiGenericResponse, pins map[string]Pin) string {
 
-	var values []float64
-	for _, k := range keys {
-		values = append(values, m[k])
-	}
-	return values
-}
-
-func parseGurobiResponse(gurobiResponse string) string {
-	var response GurobiGenericResponse
-	err := json.Unmarshal([]byte(gurobiResponse), &response)
-	check(err)
-
-	lbWeights := ""
+	lbWeights := make(protocol.LBWeights)
 	for appName, podResult := range response.Result {
-		lbWeights += appName + ":"
-		sortedValues := getValuesFromMapSortedByKeys(podResult)
+		basis := getLBWeightBasis(appName, podResult, pins)
 		var appSum float64
-		for _, value := range sortedValues {
+		for _, value := range basis {
 			appSum += value
 		}
-		sortedWeights := make([]float64, len(sortedValues))
-		for i, value := range sortedValues {
+		lbWeights[appName] = make(map[string]float64, len(basis))
+		for podName, value := range basis {
 			if appSum == 0 {
-				sortedWeights[i] = 100.0 / float64(len(sortedValues))
+				lbWeights[appName][podName] = 100.0 / float64(len(basis))
 			} else {
-				sortedWeights[i] = (value * 100) / appSum
+				lbWeights[appName][podName] = (value * 100) / appSum
 			}
 		}
-
-		strSortedWeights := make([]string, len(sortedWeights))
-		for i, weight := range sortedWeights {
-			strSortedWeights[i] = fmt.Sprintf("%f", weight)
-		}
-		lbWeights += strings.Join(strSortedWeights, "|") + " "
 	}
-	return lbWeights
+	return lbWeights.String()
 }
 
 func getOptimalCPUShares(
-	nodeCPUUtilizations []string,
-	roundsAppCPUUtils []map[string]float64) ([]string, []map[string]float64) {
+	solver Solver,
+	nodes []Node,
+	nodeCPUUtilizations []map[string]float64,
+	nodeCPUStatistics []map[string]CPUStatistics,
+	nodeResourceUsages []map[string]ResourceUsages,
+	nodeUnmanagedUtilizations []float64,
+	tenantPolicies map[string]TenantPolicy,
+	pins map[string]Pin,
+	loadEstimators *LoadEstimators) (
+	[]map[string]int64, string, *LoadEstimators) {
 
 	// parse current cpu utilizations
-	currentAppUtils := getPerAppUtilizations(nodeCPUUtilizations)
-	effectiveAppUtils := makeNoiseZero(currentAppUtils, NOISE)
-	effectiveAppUtils = addOverhead(effectiveAppUtils, OVERHEAD)
-
-	// get rolling average
-	avgAppUtils, newRoundsAppCPUUtils := getRollingAverage(
-		effectiveAppUtils, roundsAppCPUUtils)
-
-	// avgAppUtils = map[int]float64{
-	// 	1: 300.0,
-	// 	2: 200.0,
-	// 	3: 100.0,
-	// }
-
-	// get weights from gurobi
-	gurobiResponse := getWeightsFromGurobi(200.0, avgAppUtils)
+	currentAppUtils := getPerTenantUtilizations(nodes, nodeCPUUtilizations)
+	setTenantMetric(tenantLoadMetric, currentAppUtils)
+	backgroundUtils := getBackgroundUtilizations(
+		nodes, nodeCPUUtilizations, nodeUnmanagedUtilizations)
+	resources := getRoundResources(
+		nodes, nodeCPUUtilizations, nodeResourceUsages)
+	setTenantResourceMetric(resources.tenantUsages)
+	effectiveAppUtils := makeNoiseZero(currentAppUtils, config.NoisePercent)
+	effectiveAppUtils = addOverhead(effectiveAppUtils, config.OverheadPercent,
+		config.TenantOverheadPercent)
+	tenantStatistics := getPerTenantCPUStatistics(nodes, nodeCPUUtilizations,
+		nodeCPUStatistics, effectiveAppUtils)
+
+	// get estimated loads (see Estimator.go)
+	avgAppUtils, newLoadEstimators := getLoadEstimates(
+		effectiveAppUtils, tenantStatistics, loadEstimators)
+	setTenantMetric(tenantLoadAverageMetric, avgAppUtils)
+
+	// get weights from the solver
+	// (only over the nodes that reported this round)
+	gurobiResponse, solvedBy := getGenericWeights(solver,
+		healthyNodes(nodes, nodeCPUUtilizations), avgAppUtils, backgroundUtils,
+		resources, tenantPolicies, pins)
 
 	// get cpu shares
-	nodeCPUShares := getNodeCPUShares(gurobiResponse)
+	nodeCPUShares := getNodeCPUShares(nodes, gurobiResponse)
 
-	return nodeCPUShares, newRoundsAppCPUUtils
+	return nodeCPUShares, solvedBy, newLoadEstimators
 }
 
-func addOverhead(
-	appUtils map[string]float64, overhead float64) map[string]float64 {
-	for appNum, util := range appUtils {
-		if appNum == "app3" {
-			appUtils[appNum] = util + overhead
+// addOverhead adds the overhead to the load of every tenant, or the tenant's
+// own overhead from tenantOverheads.
+func addOverhead(appUtils map[string]float64, overhead float64,
+	tenantOverheads map[string]float64) map[string]float64 {
+	for tenant, util := range appUtils {
+		if tenantOverhead, ok := tenantOverheads[tenant]; ok {
+			appUtils[tenant] = util + tenantOverhead
 		} else {
-			appUtils[appNum] = util + overhead*2
+			appUtils[tenant] = util + overhead
 		}
 	}
 	return appUtils
 }
 
-func getRollingAverage(
-	currentAppUtils map[string]float64,
-	roundsAppCPUUtils []map[string]float64) (map[string]float64, []map[string]float64) {
-
-	// update rounds
-	newRoundsAppCPUUtils := append(roundsAppCPUUtils, currentAppUtils)
-	if len(newRoundsAppCPUUtils) > ROUNDS_FOR_ROLLING_AVG_OF_CPU_UTILS {
-		newRoundsAppCPUUtils = newRoundsAppCPUUtils[1:]
-	}
-
-	// get avg utils
-	avgAppUtils := make(map[string]float64)
-	for _, appUtils := range newRoundsAppCPUUtils {
-		for appNum, util := range appUtils {
-			avgAppUtils[appNum] += util
-		}
-	}
-	for appNum := range avgAppUtils {
-		avgAppUtils[appNum] /= float64(len(newRoundsAppCPUUtils))
-	}
-
-	return avgAppUtils, newRoundsAppCPUUtils
-}
-
 type LogFileFormat struct {
 	Time            int64                         `json:"time"`
 	CPUUtilizations map[string]string             `json:"CPUUtilizations"`
 	CPUShares       map[string]string             `json:"CPUShares"`
 	CPUQuotas       map[string]string             `json:"CPUQuotas"`
 	LBWeights       map[string]map[string]float64 `json:"LBWeights"`
+	// memory and network usage of the pods (see Resources.go)
+	ResourceUsages map[string]ResourceUsages `json:"ResourceUsages,omitempty"`
+	// CPU Utilization of each node outside the pods
+	UnmanagedUtilizations map[string]float64 `json:"UnmanagedUtilizations,omitempty"`
+	// the LB weights of the solver, before damping (see Damping.go)
+	RawLBWeights map[string]map[string]float64 `json:"RawLBWeights,omitempty"`
+	// PRIMARY or FALLBACK by enforcer: which allocator the weights, shares
+	// or quotas come from (see Fallback.go)
+	SolvedBy map[string]string `json:"SolvedBy,omitempty"`
+	// what the candidate policy decided (see Shadow.go)
+	Shadow *ShadowLogFormat `json:"Shadow,omitempty"`
 }
 
-func getLogFileFormatNoEnforcement(nodeCPUUtilizations []string) string {
-
-	logFileFormat := LogFileFormat{
-		time.Now().UnixNano(),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]map[string]float64),
-	}
-
-	for _, nodeCPUUtil := range nodeCPUUtilizations {
-
-		podCPUtils := strings.Split(nodeCPUUtil, " ")
-
-		for _, podCPUUtil := range podCPUtils {
-			podUtilMap := strings.Split(podCPUUtil, ":")
-			podName, podUtil := podUtilMap[0], podUtilMap[1]
-			logFileFormat.CPUUtilizations[podName] = podUtil
-		}
-	}
-
-	logFileFormatStr, err := json.Marshal(logFileFormat)
-	check(err)
-
-	return string(logFileFormatStr)
-}
-
-func getLogFileFormatLBEnforcement(
-	nodeCPUUtilizations []string,
-	lbWeightsStr string) string {
-
-	logFileFormat := LogFileFormat{
-		time.Now().UnixNano(),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]map[string]float64),
-	}
-
-	for _, nodeCPUUtil := range nodeCPUUtilizations {
-
-		podCPUtils := strings.Split(nodeCPUUtil, " ")
-
-		for _, podCPUUtil := range podCPUtils {
-			podUtilMap := strings.Split(podCPUUtil, ":")
-			podName, podUtil := podUtilMap[0], podUtilMap[1]
-			logFileFormat.CPUUtilizations[podName] = podUtil
-		}
-	}
-
-	logFileFormat.LBWeights = parseLBWeightStr(lbWeightsStr)
-
-	logFileFormatStr, err := json.Marshal(logFileFormat)
-	check(err)
-
-	return string(logFileFormatStr)
-}
-
+// parseLBWeightStr returns the LB weights of the pods by tenant, or none if
+// lbWeightsStr is invalid.
 func parseLBWeightStr(lbWeightsStr string) map[string]map[string]float64 {
-
-	lbWeights := make(map[string]map[string]float64)
-
-	// example lbWeightsStr:
-	// 		"profile:0.0|100.0 frontend:0.0|100.0 recommendation:100.0"
-	lbWeightsStr = strings.TrimSpace(lbWeightsStr)
-	appWeights := strings.Split(lbWeightsStr, " ")
-	for _, appWeight := range appWeights {
-		appWeightMap := strings.Split(appWeight, ":")
-		appName := appWeightMap[0]
-		weights := strings.Split(appWeightMap[1], "|")
-		lbWeights[appName] = make(map[string]float64)
-		for replicaNum, weight := range weights {
-			lbWeights[appName][fmt.Sprintf("%s-%d", appName, replicaNum)] = stringToFloat(weight)
-		}
+	lbWeights, err := protocol.ParseLBWeights(lbWeightsStr)
+	if err != nil {
+		slog.Warn("Failed to parse LB Weights: " + err.Error())
+		return make(map[string]map[string]float64)
 	}
-
 	return lbWeights
 }
 
-func stringToFloat(str string) float64 {
-	f, err := strconv.ParseFloat(str, 64)
-	check(err)
-	return f
-}
-
-func getLogFileFormat(
-	nodeCPUUtilizations []string, nodeCPUShares []string) string {
-
-	logFileFormat := LogFileFormat{
-		time.Now().UnixNano(),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]map[string]float64),
+// newLogFileFormat returns an empty log line of the round at unixNano.
+func newLogFileFormat(unixNano int64) LogFileFormat {
+	return LogFileFormat{
+		Time:                  unixNano,
+		CPUUtilizations:       make(map[string]string),
+		CPUShares:             make(map[string]string),
+		CPUQuotas:             make(map[string]string),
+		LBWeights:             make(map[string]map[string]float64),
+		ResourceUsages:        make(map[string]ResourceUsages),
+		UnmanagedUtilizations: make(map[string]float64),
+		SolvedBy:              make(map[string]string),
 	}
+}
 
-	for _, nodeCPUUtil := range nodeCPUUtilizations {
+// getLogFileFormat returns the log line of a round with the CPU Utilizations
+// and resource usages, the values the enforcer decided and, in shadow mode,
+// what the candidate decided (nil otherwise).
+func getLogFileFormat(roundTime time.Time, round Round, enforcer Enforcer,
+	shadowLog *ShadowLogFormat) string {
 
-		podCPUtils := strings.Split(nodeCPUUtil, " ")
+	logFileFormat := newLogFileFormat(roundTime.UnixNano())
+	logFileFormat.Shadow = shadowLog
 
-		for _, podCPUUtil := range podCPUtils {
-			podUtilMap := strings.Split(podCPUUtil, ":")
-			podName, podUtil := podUtilMap[0], podUtilMap[1]
-			logFileFormat.CPUUtilizations[podName] = podUtil
+	for _, nodeCPUUtil := range round.NodeCPUUtilizations {
+		for podName, podUtil := range nodeCPUUtil {
+			logFileFormat.CPUUtilizations[podName] = fmt.Sprintf("%f", podUtil)
 		}
-
 	}
-
-	for _, nodeCPUShare := range nodeCPUShares {
-
-		podCPShares := strings.Split(nodeCPUShare, " ")
-
-		for _, podCPUShare := range podCPShares {
-			podShareMap := strings.Split(podCPUShare, ":")
-			podName, podShare := podShareMap[0], podShareMap[1]
-			logFileFormat.CPUShares[podName] = podShare
+	for _, podResources := range round.NodeResourceUsages {
+		for podName, podUsages := range podResources {
+			logFileFormat.ResourceUsages[podName] = podUsages
 		}
-
 	}
-
-	logFileFormatStr, err := json.Marshal(logFileFormat)
-	check(err)
-
-	return string(logFileFormatStr)
-}
-
-func getLogFileFormatForCPUQuotas(
-	nodeCPUUtilizations []string, nodeCPUQuotas []string) string {
-
-	logFileFormat := LogFileFormat{
-		time.Now().UnixNano(),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]string),
-		make(map[string]map[string]float64),
-	}
-
-	for _, nodeCPUUtil := range nodeCPUUtilizations {
-
-		podCPUtils := strings.Split(nodeCPUUtil, " ")
-
-		for _, podCPUUtil := range podCPUtils {
-			podUtilMap := strings.Split(podCPUUtil, ":")
-			podName, podUtil := podUtilMap[0], podUtilMap[1]
-			logFileFormat.CPUUtilizations[podName] = podUtil
+	for i, unmanaged := range round.NodeUnmanagedUtilizations {
+		if round.NodeCPUUtilizations[i] != nil {
+			logFileFormat.UnmanagedUtilizations[round.Nodes[i].Name] = unmanaged
 		}
-
 	}
 
-	for _, nodeCPUQuota := range nodeCPUQuotas {
-
-		podCPUQuotas := strings.Split(nodeCPUQuota, " ")
-
-		for _, podCPUQuota := range podCPUQuotas {
-			podQuotaMap := strings.Split(podCPUQuota, ":")
-			podName, podQuota := podQuotaMap[0], podQuotaMap[1]
-			logFileFormat.CPUQuotas[podName] = podQuota
-		}
-
-	}
+	enforcer.Log(&logFileFormat)
 
 	logFileFormatStr, err := json.Marshal(logFileFormat)
 	check(err)
@@ -812,170 +511,245 @@ func check(err error) {
 	}
 }
 
-func getPerAppUtilizations(nodeCPUUtilizations []string) map[string]float64 {
-
-	appUtils := make(map[string]float64)
-	for _, cpuUtil := range nodeCPUUtilizations {
+// getPerTenantUtilizations sums the CPU Utilizations of the pods of each
+// tenant. Pods without a tenant are left out (see getBackgroundUtilizations).
+func getPerTenantUtilizations(
+	nodes []Node, nodeCPUUtilizations []map[string]float64) map[string]float64 {
 
-		// example cpuUtil to parse: "cpuUtilizations app1-node1:45 app2-node1:69"
-
-		cpuUtilStrs := strings.Split(cpuUtil, " ")[1:]
-		for _, cpuUtilStr := range cpuUtilStrs {
-
-			util := strings.Split(cpuUtilStr, ":")
-			appName := util[0]
-
-			// get "app1-node1" from "app1-node1-0"
-			pattern := `^(.+)-\d+$`
-			// Compile the regex
-			re := regexp.MustCompile(pattern)
-			// Find the first match
-			match := re.FindStringSubmatch(util[0])
-
-			if len(match) > 1 {
-				// match[0] is the full match, match[1] is the first capturing group
-				appName = match[1]
+	tenantUtils := make(map[string]float64)
+	for i, cpuUtil := range nodeCPUUtilizations {
+		for podName, podUtil := range cpuUtil {
+			if pod, ok := nodes[i].Pods[podName]; ok && pod.Tenant != "" {
+				tenantUtils[pod.Tenant] += podUtil
 			}
-
-			podUtil, err := strconv.ParseFloat(util[1], 64)
-			check(err)
-
-			appUtils[appName] += podUtil
 		}
-
 	}
-	return appUtils
-}
-
-type GurobiResponse struct {
-	Status    int     `json:"status"`
-	App1Node1 float64 `json:"t00"`
-	App1Node2 float64 `json:"t01"`
-	App2Node2 float64 `json:"t11"`
-	App2Node3 float64 `json:"t12"`
-	App3Node1 float64 `json:"t20"`
-}
-
-func getWeightsFromGurobi(
-	hostCap float64, appUtils map[string]float64) string {
-
-	baseURL := "http://localhost:5000"
-	resource := "/"
-	params := url.Values{}
-	params.Add("host_cap", fmt.Sprintf("%f", hostCap))
-	params.Add("t0", fmt.Sprintf("%f", appUtils["app1"]))
-	params.Add("t1", fmt.Sprintf("%f", appUtils["app2"]))
-	params.Add("t2", fmt.Sprintf("%f", appUtils["app3"]))
-
-	u, _ := url.ParseRequestURI(baseURL)
-	u.Path = resource
-	u.RawQuery = params.Encode()
-	urlStr := fmt.Sprintf("%v", u)
-
-	res, err := http.Get(urlStr)
-	check(err)
-
-	resBody, err := io.ReadAll(res.Body)
-	check(err)
-
-	return string(resBody)
+	return tenantUtils
 }
 
-// JSON structs to send to the Gurobi Server
-type HostJSON struct {
-	Name string  `json:"name"`
-	Cap  float64 `json:"cap"`
-}
-type TenantJSON struct {
-	Name       string  `json:"name"`
-	Load       float64 `json:"load"`
-	FShareLoad float64 `json:"fshareload"`
-}
-type PodJSON struct {
-	Name   string `json:"name"`
-	Tenant string `json:"tenant"`
-	Host   string `json:"host"`
+// getBackgroundUtilizations sums the CPU Utilizations of the pods without a
+// tenant (e.g. consul, jaeger, the host agents) on each node, and of what
+// runs outside the pods (unmanaged: the system services and the pods of
+// other namespaces, e.g. kube-system daemons). This load is taken off the
+// node's capacity before it is shared among the tenants.
+func getBackgroundUtilizations(nodes []Node,
+	nodeCPUUtilizations []map[string]float64,
+	nodeUnmanagedUtilizations []float64) map[string]float64 {
+
+	backgroundUtils := make(map[string]float64)
+	for i, cpuUtil := range nodeCPUUtilizations {
+		if cpuUtil != nil && i < len(nodeUnmanagedUtilizations) {
+			backgroundUtils[nodes[i].Name] += nodeUnmanagedUtilizations[i]
+		}
+		for podName, podUtil := range cpuUtil {
+			if pod, ok := nodes[i].Pods[podName]; !ok || pod.Tenant == "" {
+				backgroundUtils[nodes[i].Name] += podUtil
+			}
+		}
+	}
+	return backgroundUtils
 }
 
-func getFShareLoad(nodes []Node, appName string) float64 {
+// getFShareLoad returns the tenant's fair share of the capacity (in percent
+// of one core) left on the nodes after their background load.
+func getFShareLoad(nodes []Node, hostCaps map[string]float64, tenant string) float64 {
 	totalUtil := 0.0
 	for _, node := range nodes {
-		fmt.Sprintf("checking node %s", node.Name)
+		slog.Debug(fmt.Sprintf("checking node %s", node.Name))
 		for _, pod := range node.Pods {
-			if pod.AppName == appName {
-				fmt.Sprintf("found pod %s util: %f\n", pod.Name, pod.FShare*float64(node.MilliCores))
-				totalUtil += pod.FShare * float64(node.MilliCores)
+			if pod.Tenant == tenant {
+				slog.Debug(fmt.Sprintf("found pod %s util: %f", pod.Name, pod.FShare*hostCaps[node.Name]))
+				totalUtil += pod.FShare * hostCaps[node.Name]
 			}
 		}
 	}
 
 	if totalUtil == 0 {
-		panic("total util is 0 for app " + appName)
+		slog.Warn("fair share is 0 for tenant " + tenant)
 	}
 	return totalUtil
 }
 
-type GurobiGenericResponse struct {
-	Status int                           `json:"status"`
-	Result map[string]map[string]float64 `json:"result"`
-}
+func getGenericWeights(
+	solver Solver,
+	nodes []Node, appUtils map[string]float64,
+	backgroundUtils map[string]float64,
+	resources roundResources,
+	tenantPolicies map[string]TenantPolicy,
+	pins map[string]Pin) (GurobiGenericResponse, string) {
 
-func getGenericWeightsFromGurobi(
-	nodes []Node, appUtils map[string]float64) string {
+	hostCaps, hostResources := getHostCapacities(
+		nodes, backgroundUtils, resources)
+
+	allPods := make([]PodJSON, 0)
+	for _, node := range nodes {
+		for _, pod := range node.Pods {
+			if pod.Tenant == "" {
+				continue
+			}
+			allPods = append(allPods, PodJSON{
+				Name:    pod.Name,
+				Tenant:  pod.Tenant,
+				Host:    node.Name,
+				MaxLoad: getPinnedMaxLoad(pod, pins),
+			})
+		}
+	}
+
+	// the load of the tenants with pinned LB weights is not available to
+	// the other tenants either (see Admin.go)
+	nodeCaps := maps.Clone(hostCaps)
+	pinnedLoads := getPinnedLoads(allPods, appUtils, pins)
+	pods := make([]PodJSON, 0, len(allPods))
+	for _, pod := range allPods {
+		if load, ok := pinnedLoads[pod.Tenant][pod.Name]; ok {
+			hostCaps[pod.Host] = math.Max(0, hostCaps[pod.Host]-load)
+			for resource, capacity := range hostResources[pod.Host] {
+				hostResources[pod.Host][resource] = math.Max(0, capacity-
+					load*resources.intensities[pod.Tenant][resource])
+			}
+			continue
+		}
+		pods = append(pods, pod)
+	}
 
 	hosts := make([]HostJSON, 0)
 	for _, node := range nodes {
 		hosts = append(hosts, HostJSON{
-			Name: node.Name,
-			Cap:  float64(node.MilliCores) / 10.0,
+			Name:      node.Name,
+			Cap:       hostCaps[node.Name],
+			Resources: hostResources[node.Name],
 		})
 	}
-	hostsJSON, err := json.Marshal(hosts)
-	check(err)
+
+	appNames := make([]string, 0, len(appUtils))
+	for appName := range appUtils {
+		if _, ok := pinnedLoads[appName]; !ok {
+			appNames = append(appNames, appName)
+		}
+	}
+	fshareLoads := getFShareLoads(nodes, hostCaps, appNames, tenantPolicies)
 
 	tenants := make([]TenantJSON, 0)
-	for appName, util := range appUtils {
+	for _, appName := range appNames {
 		tenants = append(tenants, TenantJSON{
-			Name:       appName,
-			Load:       util,
-			FShareLoad: getFShareLoad(nodes, appName),
+			Name: appName,
+			Load: math.Min(
+				getBurstCappedLoad(appName, appUtils[appName], tenantPolicies),
+				getMaxTenantLoad(appName, pods, hostCaps, hostResources,
+					resources.intensities[appName])),
+			FShareLoad: fshareLoads[appName],
+			Priority:   tenantPolicies[appName].Priority,
+			Resources:  resources.intensities[appName],
 		})
 	}
-	tenantsJSON, err := json.Marshal(tenants)
-	check(err)
 
-	pods := make([]PodJSON, 0)
-	for _, node := range nodes {
-		for _, pod := range node.Pods {
-			pods = append(pods, PodJSON{
-				Name:   pod.Name,
-				Tenant: pod.AppName,
-				Host:   node.Name,
-			})
-		}
+	solveStart := time.Now()
+	response, err := solver.Solve(context.Background(), hosts, tenants, pods)
+	solverLatencyMetric.Observe(time.Since(solveStart).Seconds())
+	solverStatusMetric.Set(float64(response.Status))
+
+	// the heuristic allocator takes over when the solver fails, times out or
+	// finds no optimal solution (see Fallback.go)
+	solvedBy := SOLVED_BY_PRIMARY
+	if err == nil && response.Status != SOLVER_STATUS_OPTIMAL {
+		err = fmt.Errorf("solver returned status %d", response.Status)
 	}
-	podsJSON, err := json.Marshal(pods)
-	check(err)
+	call := solverCall{Time: solveStart, Hosts: hosts, Tenants: tenants,
+		Pods: pods}
+	if err != nil {
+		slog.Warn("Solve failed, falling back to the heuristic allocator: " +
+			err.Error())
+		call.Error = err.Error()
+		response, _ = new(FallbackSolver).Solve(
+			context.Background(), hosts, tenants, pods)
+		solvedBy = SOLVED_BY_FALLBACK
+		solverFallbacksMetric.Inc()
+	}
+	call.Response = response
+	call.SolvedBy = solvedBy
 
-	baseURL := "http://localhost:5000/"
-	payload := fmt.Sprintf(
-		"[%s,%s,%s]", string(hostsJSON), string(tenantsJSON), string(podsJSON))
+	// the pinned tenants' pods keep their pinned share of the load
+	if response.Result == nil {
+		response.Result = make(map[string]map[string]float64)
+	}
+	for tenant, podLoads := range pinnedLoads {
+		response.Result[tenant] = podLoads
+	}
 
-	fmt.Printf("Payload sending to Gurobi: %s\n", payload)
+	call.Allocation = getAllocation(nodeCaps, allPods, response)
+	admin.recordSolve(call)
+	recordSolution(fshareLoads, call.Allocation)
 
-	resBody, err := sendPostRequest(baseURL, payload)
-	check(err)
+	return response, solvedBy
+}
+
+// getHostCapacities returns the CPU capacity of every node and the capacity
+// of the resources that scale with the load, by node name. The background
+// load and usage of a node is not available to the tenants, and nodes out
+// of memory get no load (see Resources.go).
+func getHostCapacities(nodes []Node, backgroundUtils map[string]float64,
+	resources roundResources) (map[string]float64, map[string]ResourceUsages) {
 
-	return string(resBody)
+	hostCaps := make(map[string]float64)
+	hostResources := make(map[string]ResourceUsages)
+	for _, node := range nodes {
+		hostCaps[node.Name] = math.Max(0,
+			float64(node.MilliCores)/10.0-backgroundUtils[node.Name])
+		hostResources[node.Name] = getHostResources(
+			node, resources.background[node.Name])
+		if isOutOfMemory(node, resources.background[node.Name],
+			resources.tenantMemory[node.Name]) {
+			slog.Warn(fmt.Sprintf(
+				"Node %s is out of memory, no load is balanced to it",
+				node.Name))
+			hostCaps[node.Name] = 0
+		}
+	}
+	return hostCaps, hostResources
 }
 
-func sendPostRequest(url, payload string) (string, error) {
-	// Send the POST request
-	response, err := http.Post(url, "application/json",
+// getMaxTenantLoad returns the most load the tenant's pods can take: on each
+// host, the pods of the tenant take at most their max loads and together the
+// host's capacity, of CPU and of the resources the tenant uses (intensities,
+// see Resources.go).
+func getMaxTenantLoad(tenant string, pods []PodJSON,
+	hostCaps map[string]float64, hostResources map[string]ResourceUsages,
+	intensities ResourceUsages) float64 {
+
+	hostMaxLoads := make(map[string]float64)
+	for _, pod := range pods {
+		if pod.Tenant != tenant {
+			continue
+		}
+		if pod.MaxLoad > 0 {
+			hostMaxLoads[pod.Host] += pod.MaxLoad
+		} else {
+			hostMaxLoads[pod.Host] = math.Inf(1)
+		}
+	}
+
+	maxLoad := 0.0
+	for host, hostMaxLoad := range hostMaxLoads {
+		maxLoad += math.Min(hostMaxLoad, math.Min(hostCaps[host],
+			resourceRoom(hostResources[host], intensities)))
+	}
+	return maxLoad
+}
+
+func sendPostRequest(ctx context.Context,
+	client *http.Client, url, payload string) (string, error) {
+	// Send the POST request, aborted when ctx is done
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url,
 		bytes.NewBuffer([]byte(payload)))
 	if err != nil {
 		return "", err
 	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
 	// Ensure the response body is closed after the function returns
 	defer response.Body.Close()
 
@@ -994,75 +768,121 @@ func sendPostRequest(url, payload string) (string, error) {
 	return string(body), nil
 }
 
-func getNodeCPUShares(gurobiResponse string) []string {
+// getNodeCPUShares splits the cpu.shares of each node (CPU_SHARES_PER_CORE
+// per core) among its pods in proportion to the load the solver assigned to
+// them. Nodes without a solution (e.g. unhealthy ones) get nil.
+func getNodeCPUShares(
+	nodes []Node, response GurobiGenericResponse) []map[string]int64 {
 
-	if USE_PRESET_SHARES {
-		return getPresetCPUShares()
+	if config.UsePresetShares {
+		return getPresetCPUShares(nodes)
 	}
 
-	var response GurobiResponse
-	err := json.Unmarshal([]byte(gurobiResponse), &response)
-	check(err)
-
-	if response.Status != 2 {
-		slog.Warn(fmt.Sprintf("gurobi returned status %d", response.Status))
+	if !hasSolution(response.Status) {
+		slog.Warn(fmt.Sprintf("solver returned status %d", response.Status))
 		return nil
-	} else {
-		nodeCPUShares := make([]string, 3)
-		nodeCPUShares[0] = fmt.Sprintf("%s:%f %s:%f",
-			"app1-node1",
-			(response.App1Node1*512)/(response.App1Node1+response.App3Node1),
-			"app3-node1",
-			(response.App3Node1*512)/(response.App1Node1+response.App3Node1))
-		nodeCPUShares[1] = fmt.Sprintf("%s:%f %s:%f",
-			"app1-node2",
-			(response.App1Node2*512)/(response.App1Node2+response.App2Node2),
-			"app2-node2",
-			(response.App2Node2*512)/(response.App1Node2+response.App2Node2))
-		nodeCPUShares[2] = fmt.Sprintf("%s:%f",
-			"app2-node3",
-			(response.App2Node3*512)/response.App2Node3)
-
-		// nodeCPUShares[0] = fmt.Sprintf("%s:%f %s:%f",
-		// 	"app1-node1",
-		// 	256.0,
-		// 	"app3-node1",
-		// 	256.0)
-		// nodeCPUShares[1] = fmt.Sprintf("%s:%f %s:%f",
-		// 	"app1-node2",
-		// 	256.0,
-		// 	"app2-node2",
-		// 	256.0)
-		// nodeCPUShares[2] = fmt.Sprintf("%s:%f",
-		// 	"app2-node3",
-		// 	256.0)
-
-		return nodeCPUShares
 	}
+
+	podLoads := getPodLoads(response)
+
+	nodeCPUShares := make([]map[string]int64, len(nodes))
+	for i, node := range nodes {
+		nodeLoad, ok := getNodeLoad(node, podLoads)
+		if !ok {
+			continue
+		}
+		nodeShares := float64(node.MilliCores) * CPU_SHARES_PER_CORE / 1000
+		nodeCPUShares[i] = make(map[string]int64)
+		tenantPods := getTenantPods(node)
+		for _, pod := range tenantPods {
+			nodeCPUShares[i][pod.Name] = getShares(
+				podLoads[pod.Name], nodeLoad, len(tenantPods), nodeShares)
+		}
+	}
+
+	return nodeCPUShares
 }
 
-func getQuota(appShare, nodeSum float64) int64 {
-	quota := int64((appShare * (CFS_PERIOD_US * CPUS_IN_NODE)) / (nodeSum))
-	if quota < 1000 {
-		quota = 1000
+// getShares returns the pod's part of nodeShares for its load, or an equal
+// part if no load was assigned on the node.
+func getShares(
+	podLoad, nodeLoad float64, numPods int, nodeShares float64) int64 {
+
+	shares := int64(nodeShares / float64(numPods))
+	if nodeLoad > 0 {
+		shares = int64((podLoad * nodeShares) / nodeLoad)
+	}
+	if shares < MINIMUM_CPU_SHARES {
+		shares = MINIMUM_CPU_SHARES
+	}
+	return shares
+}
+
+// getQuota returns the pod's part of the node's CFS quota for its load.
+func getQuota(podLoad, nodeLoad float64, milliCores int) int64 {
+	nodeQuota := float64(CFS_PERIOD_US*milliCores) / 1000
+	quota := int64(0)
+	if nodeLoad > 0 {
+		quota = int64((podLoad * nodeQuota) / nodeLoad)
+	}
+	if quota < MINIMUM_CPU_QUOTA {
+		quota = MINIMUM_CPU_QUOTA
 	}
-	podQuotaOverhead :=
-		(CFS_PERIOD_US * CPUS_IN_NODE) * (POD_QUOTA_OVERHEAD / 100.0)
+	podQuotaOverhead := nodeQuota * (config.PodQuotaOverheadPercent / 100.0)
 	return quota + int64(podQuotaOverhead)
 }
 
-func setDefaultLBWeights(nodes []Node, cpuLogFile *LogFile) {
+// getTenantPods returns the pods of the node that belong to a tenant; the
+// CPU of the other pods is left alone.
+func getTenantPods(node Node) []Pod {
+	tenantPods := make([]Pod, 0, len(node.Pods))
+	for _, pod := range node.Pods {
+		if pod.Tenant != "" {
+			tenantPods = append(tenantPods, pod)
+		}
+	}
+	return tenantPods
+}
 
-	lbWeights := DEFAULT_LB_WEIGHTS
+// getPodLoads flattens the solver's tenant -> pod -> load result
+func getPodLoads(response GurobiGenericResponse) map[string]float64 {
+	podLoads := make(map[string]float64)
+	for _, podResult := range response.Result {
+		for podName, load := range podResult {
+			podLoads[podName] = load
+		}
+	}
+	return podLoads
+}
+
+// getNodeLoad returns the total load assigned to the node's pods, and false
+// if the solver did not place any of them (the node was not solved for).
+func getNodeLoad(node Node, podLoads map[string]float64) (float64, bool) {
+	tenantPods := getTenantPods(node)
+	nodeLoad, solved := 0.0, len(tenantPods) == 0
+	for _, pod := range tenantPods {
+		if load, ok := podLoads[pod.Name]; ok {
+			nodeLoad += load
+			solved = true
+		}
+	}
+	return nodeLoad, solved
+}
+
+func setDefaultLBWeights(nodes []Node) {
+
+	lbWeights := config.DefaultLBWeights
 
 	// - Send the CPU Shares to the host agents to be applied
 	if lbWeights == "" {
 		slog.Warn("Failed to get optimal LB Weights")
 	} else {
 		for i := range nodes {
-			msg := "applyLBWeights " + lbWeights
-			response := nodes[i].SendMessageAndGetResponse(msg)
-			if response != "Success" {
+			if !nodes[i].IsHealthy() {
+				continue
+			}
+			err := nodes[i].ApplyLBWeights(lbWeights)
+			if err != nil {
 				slog.Warn("Failed to apply LB Weights on node: " +
 					nodes[i].IP)
 			}
@@ -1070,18 +890,20 @@ func setDefaultLBWeights(nodes []Node, cpuLogFile *LogFile) {
 	}
 }
 
-func setDefaultCPUQuotas(nodes []Node, cpuLogFile *LogFile) {
+func setDefaultCPUQuotas(nodes []Node) {
 
-	nodeCPUQuotas := getDefaultCPUQuotas()
+	nodeCPUQuotas := getDefaultCPUQuotas(nodes)
 
 	// - Send the CPU Quotas to the host agents to be applied
 	if nodeCPUQuotas == nil {
 		slog.Warn("Failed to get optimal CPU Quotas")
 	} else {
 		for i := range nodes {
-			msg := "applyCPUQuotas " + nodeCPUQuotas[i]
-			response := nodes[i].SendMessageAndGetResponse(msg)
-			if response != "Success" {
+			if !nodes[i].IsHealthy() {
+				continue
+			}
+			err := nodes[i].ApplyCPUQuotas(nodeCPUQuotas[i])
+			if err != nil {
 				slog.Warn("Failed to apply CPU Quotas on node: " +
 					nodes[i].IP)
 			}
@@ -1089,18 +911,20 @@ func setDefaultCPUQuotas(nodes []Node, cpuLogFile *LogFile) {
 	}
 }
 
-func setDefaultCPUShares(nodes []Node, cpuLogFile *LogFile) {
+func setDefaultCPUShares(nodes []Node) {
 
-	nodeCPUShares := getDefaultCPUShares()
+	nodeCPUShares := getDefaultCPUShares(nodes)
 
 	// - Send the CPU Shares to the host agents to be applied
 	if nodeCPUShares == nil {
 		slog.Warn("Failed to get optimal CPU Shares")
 	} else {
 		for i := range nodes {
-			msg := "applyCPUShares " + nodeCPUShares[i]
-			response := nodes[i].SendMessageAndGetResponse(msg)
-			if response != "Success" {
+			if !nodes[i].IsHealthy() {
+				continue
+			}
+			err := nodes[i].ApplyCPUShares(nodeCPUShares[i])
+			if err != nil {
 				slog.Warn("Failed to apply CPU Shares on node: " +
 					nodes[i].IP)
 			}
@@ -1108,70 +932,93 @@ func setDefaultCPUShares(nodes []Node, cpuLogFile *LogFile) {
 	}
 }
 
-func getDefaultCPUShares() []string {
-	return []string{
-		"app1-node1:256 app3-node1:256",
-		"app1-node2:256 app2-node2:256",
-		"app2-node3:512",
+// getDefaultCPUShares splits the cpu.shares of each node equally among its
+// pods
+func getDefaultCPUShares(nodes []Node) []map[string]int64 {
+	nodeCPUShares := make([]map[string]int64, len(nodes))
+	for i, node := range nodes {
+		nodeShares := float64(node.MilliCores) * CPU_SHARES_PER_CORE / 1000
+		nodeCPUShares[i] = make(map[string]int64)
+		tenantPods := getTenantPods(node)
+		for _, pod := range tenantPods {
+			nodeCPUShares[i][pod.Name] = getShares(
+				0, 0, len(tenantPods), nodeShares)
+		}
 	}
+	return nodeCPUShares
 }
 
-func getDefaultCPUQuotas() []string {
-	return []string{
-		"app1-node1:-1 app3-node1:-1",
-		"app1-node2:-1 app2-node2:-1",
-		"app2-node3:-1",
+// getDefaultCPUQuotas removes the CFS quota of every pod
+func getDefaultCPUQuotas(nodes []Node) []map[string]int64 {
+	nodeCPUQuotas := make([]map[string]int64, len(nodes))
+	for i, node := range nodes {
+		nodeCPUQuotas[i] = make(map[string]int64)
+		for _, pod := range getTenantPods(node) {
+			nodeCPUQuotas[i][pod.Name] = -1
+		}
 	}
+	return nodeCPUQuotas
 }
 
-func getPresetCPUShares() []string {
-	return []string{
-		"app1-node1:0 app3-node1:512",
-		"app1-node2:512 app2-node2:0",
-		"app2-node3:512",
-	}
+// getPresetCPUShares returns the cpu.shares of the pods preset in the config
+// (presetCPUShares), by node. Nodes without a preset pod get nil.
+func getPresetCPUShares(nodes []Node) []map[string]int64 {
+	return getPresets(nodes, config.PresetCPUShares)
 }
 
-func getPresetCPUQuotas() []string {
-	return []string{
-		fmt.Sprintf("app1-node1:%d app3-node1:%d",
-			MINIMUM_CPU_QUOTA, CFS_PERIOD_US*CPUS_IN_NODE),
-		fmt.Sprintf("app1-node2:%d app2-node2:%d",
-			CFS_PERIOD_US*CPUS_IN_NODE, MINIMUM_CPU_QUOTA),
-		fmt.Sprintf("app2-node3:%d",
-			CFS_PERIOD_US*CPUS_IN_NODE),
+// getPresetCPUQuotas returns the CFS quotas of the pods preset in the config
+// (presetCPUQuotas), by node. Nodes without a preset pod get nil.
+func getPresetCPUQuotas(nodes []Node) []map[string]int64 {
+	return getPresets(nodes, config.PresetCPUQuotas)
+}
+
+// getPresets splits the preset values of the pods by the node they run on.
+func getPresets(nodes []Node, presets map[string]int64) []map[string]int64 {
+	nodePresets := make([]map[string]int64, len(nodes))
+	for i, node := range nodes {
+		for _, pod := range getTenantPods(node) {
+			preset, ok := presets[pod.Name]
+			if !ok {
+				continue
+			}
+			if nodePresets[i] == nil {
+				nodePresets[i] = make(map[string]int64)
+			}
+			nodePresets[i][pod.Name] = preset
+		}
 	}
+	return nodePresets
 }
 
-func getNodeCPUQuotas(gurobiResponse string) []string {
+// getNodeCPUQuotas splits the CFS quota of each node (all its cores) among
+// its pods in proportion to the load the solver assigned to them. Nodes
+// without a solution (e.g. unhealthy ones) get nil.
+func getNodeCPUQuotas(
+	nodes []Node, response GurobiGenericResponse) []map[string]int64 {
 
-	if USE_PRESET_SHARES {
-		return getPresetCPUQuotas()
+	if config.UsePresetShares {
+		return getPresetCPUQuotas(nodes)
 	}
 
-	var response GurobiResponse
-	err := json.Unmarshal([]byte(gurobiResponse), &response)
-	check(err)
-
-	if response.Status != 2 {
-		slog.Warn(fmt.Sprintf("gurobi returned status %d", response.Status))
+	if !hasSolution(response.Status) {
+		slog.Warn(fmt.Sprintf("solver returned status %d", response.Status))
 		return nil
-	} else {
-		nodeCPUShares := make([]string, 3)
-		nodeCPUShares[0] = fmt.Sprintf("%s:%d %s:%d",
-			"app1-node1",
-			getQuota(response.App1Node1, response.App1Node1+response.App3Node1),
-			"app3-node1",
-			getQuota(response.App3Node1, response.App1Node1+response.App3Node1))
-		nodeCPUShares[1] = fmt.Sprintf("%s:%d %s:%d",
-			"app1-node2",
-			getQuota(response.App1Node2, response.App1Node2+response.App2Node2),
-			"app2-node2",
-			getQuota(response.App2Node2, response.App1Node2+response.App2Node2))
-		nodeCPUShares[2] = fmt.Sprintf("%s:%d",
-			"app2-node3",
-			getQuota(response.App2Node3, response.App2Node3))
-
-		return nodeCPUShares
 	}
+
+	podLoads := getPodLoads(response)
+
+	nodeCPUQuotas := make([]map[string]int64, len(nodes))
+	for i, node := range nodes {
+		nodeLoad, ok := getNodeLoad(node, podLoads)
+		if !ok {
+			continue
+		}
+		nodeCPUQuotas[i] = make(map[string]int64)
+		for _, pod := range getTenantPods(node) {
+			nodeCPUQuotas[i][pod.Name] = getQuota(
+				podLoads[pod.Name], nodeLoad, node.MilliCores)
+		}
+	}
+
+	return nodeCPUQuotas
 }
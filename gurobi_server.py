@@ -322,10 +322,12 @@ class Tenant:
         self.fshareload: float = fshare
 
 class Worker:
-    def __init__(self, name: str, tenant: str, host: str):
+    def __init__(self, name: str, tenant: str, host: str, maxload: float = 0.0):
         self.name: str = name
         self.tenant: str = tenant
         self.host: str = host
+        # most load the worker can be assigned (0 = no limit)
+        self.maxload: float = maxload
         
 def run_generic_model(
     _hosts: List[Host],
@@ -354,7 +356,8 @@ def run_generic_model(
     # set variables for the workers
     w = {}   
     for worker in _workers:
-        w[worker.name] = m.addVar(lb=0.0, vtype=GRB.CONTINUOUS,
+        ub = worker.maxload if worker.maxload > 0 else GRB.INFINITY
+        w[worker.name] = m.addVar(lb=0.0, ub=ub, vtype=GRB.CONTINUOUS,
                            name=f"w_{worker.name}")
     
     print(w)
@@ -489,7 +492,8 @@ def run_generic_model(
 def run_from_json(hosts, tenants, workers):
     hosts = [Host(h["name"], h["cap"]) for h in hosts]
     tenants = [Tenant(t["name"], t["load"], t["fshareload"]) for t in tenants]
-    workers = [Worker(w["name"], w["tenant"], w["host"]) for w in workers]
+    workers = [Worker(w["name"], w["tenant"], w["host"], w.get("maxload", 0.0))
+               for w in workers]
     return run_generic_model(hosts, tenants, workers)
     
 # test run for the 3-node scenario on the newly written generic model func
@@ -1,4 +1,5 @@
 set -e
 
-docker build -t ghcr.io/talha-waheed/hostagent:latest .
+# build from the repo root so that the shared protocol package is in context
+docker build -t ghcr.io/talha-waheed/hostagent:latest -f Dockerfile ..
 docker push ghcr.io/talha-waheed/hostagent:latest
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// cgroupfs of the host, mounted into the host agent's container
+	CGROUP_ROOT = "/host/sys/fs/cgroup"
+
+	// cpu.shares range of cgroup v1 and cpu.weight range of cgroup v2
+	MIN_CPU_SHARES = 2
+	MAX_CPU_SHARES = 262144
+	MIN_CPU_WEIGHT = 1
+	MAX_CPU_WEIGHT = 10000
+
+	// cgroup files read by the host agent are a single line or a few
+	// "<key> <value>" lines
+	CGROUP_FILE_BUFFER_SIZE = 4096
+
+	// keys of the files of the host's own cgroups in cgroupFiles, which are
+	// never pod paths
+	SYSTEM_CGROUP_KEY = "/system.slice"
+	PODS_CGROUP_KEY   = "/kubepods"
+)
+
+/*
+CGroup reads and sets the CPU controls of pods, and reads their memory and
+processes, on cgroup v1 and cgroup v2 (unified hierarchy) hosts. Pods are identified by the cgroup path relative
+to kubepods that the CC sends with updatePods, e.g. "burstable/pod<uid>".
+
+	             cgroup v1                 cgroup v2
+	usage        cpuacct.usage (ns)        cpu.stat usage_usec
+	shares       cpu.shares                cpu.weight (converted)
+	quota        cpu.cfs_quota_us          cpu.max ("max" for no quota)
+	memory       memory.usage_in_bytes     memory.current
+	             - total_inactive_file     - inactive_file
+	               (memory.stat)             (memory.stat)
+	processes    cgroup.procs of the pod's cgroup and its children
+	containers   the cgroups directly below the pod's (usage and processes
+	             as above)
+
+The CPU usage of the host's system services (system.slice) and of all the
+pods (kubepods or kubepods.slice) is read the same way as a pod's.
+
+Both the cgroupfs and the systemd cgroup driver layouts of kubepods are
+supported. All paths are relative to root, so a fake cgroupfs directory tree
+can be used instead of the host's.
+
+The usage files are read every sample, so they are kept open (see
+cgroupFiles) until the pod is released. The contents of the files that are
+set are recorded before their first write (see cgroupOriginals), so that
+Restore can undo everything the host agent did.
+*/
+type CGroup interface {
+	// GetCPUUsage returns the total CPU time used by the pod in nanoseconds
+	GetCPUUsage(podPath string) (int64, error)
+	// GetMemoryWorkingSet returns the memory working set of the pod in bytes:
+	// its memory usage less its inactive file cache, as the kubelet counts it
+	GetMemoryWorkingSet(podPath string) (int64, error)
+	// GetPIDs returns the PIDs of the processes of the pod
+	GetPIDs(podPath string) ([]int, error)
+	// GetContainers returns the names of the cgroups of the pod's containers
+	GetContainers(podPath string) ([]string, error)
+	// GetContainerPIDs returns the PIDs of the processes of one of the pod's
+	// containers
+	GetContainerPIDs(podPath, container string) ([]int, error)
+	// GetContainerCPUUsage returns the total CPU time used by one of the
+	// pod's containers in nanoseconds
+	GetContainerCPUUsage(podPath, container string) (int64, error)
+	// GetSystemCPUUsage returns the total CPU time used by the host's system
+	// services (system.slice) in nanoseconds
+	GetSystemCPUUsage() (int64, error)
+	// GetPodsCPUUsage returns the total CPU time used by all the pods of the
+	// host, known to the CC or not, in nanoseconds
+	GetPodsCPUUsage() (int64, error)
+	// SetCPUShares sets the pod's relative CPU weight in cpu.shares units
+	SetCPUShares(podPath string, shares int64) error
+	// SetCPUQuota sets the pod's CFS quota in microseconds per period
+	// (-1 = no quota)
+	SetCPUQuota(podPath string, quotaUs int64) error
+	// Restore writes back the CPU controls of every pod as they were before
+	// they were first set
+	Restore() error
+	// Release closes the files kept open for the pod and forgets its
+	// original CPU controls
+	Release(podPath string)
+}
+
+// NewCGroup detects the cgroup version mounted at root.
+func NewCGroup(root string) CGroup {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return &cgroupV2{root: root, files: newCGroupFiles(),
+			originals: newCGroupOriginals()}
+	}
+	return &cgroupV1{root: root, files: newCGroupFiles(),
+		originals: newCGroupOriginals()}
+}
+
+// ================================ cgroup v1 ================================
+
+type cgroupV1 struct {
+	root      string
+	files     *cgroupFiles
+	originals *cgroupOriginals
+}
+
+func (c *cgroupV1) GetCPUUsage(podPath string) (int64, error) {
+	return c.readCPUUsage(podPath, "", func(base string) (string, error) {
+		return getPodCGroupDir(base, podPath)
+	})
+}
+
+func (c *cgroupV1) GetContainerCPUUsage(
+	podPath, container string) (int64, error) {
+
+	return c.readCPUUsage(podPath, container,
+		func(base string) (string, error) {
+			podDir, err := getPodCGroupDir(base, podPath)
+			return filepath.Join(podDir, container), err
+		})
+}
+
+func (c *cgroupV1) GetSystemCPUUsage() (int64, error) {
+	return c.readCPUUsage(SYSTEM_CGROUP_KEY, "",
+		func(base string) (string, error) {
+			return findCGroupDir(base, "system.slice")
+		})
+}
+
+func (c *cgroupV1) GetPodsCPUUsage() (int64, error) {
+	return c.readCPUUsage(PODS_CGROUP_KEY, "",
+		func(base string) (string, error) {
+			return findCGroupDir(base, "kubepods", "kubepods.slice")
+		})
+}
+
+// readCPUUsage returns the cpuacct.usage of the cgroup that dir finds under
+// the cpuacct hierarchy, kept open under key (and container, if any).
+func (c *cgroupV1) readCPUUsage(key, container string,
+	dir func(base string) (string, error)) (int64, error) {
+
+	fileName := filepath.Join(container, "cpuacct.usage")
+	usage, err := c.files.read(key, fileName, func() (string, error) {
+		cgroupDir, err := dir(filepath.Join(c.root, "cpuacct"))
+		if err != nil {
+			// cpu and cpuacct are usually co-mounted as cpu,cpuacct
+			cgroupDir, err = dir(filepath.Join(c.root, "cpu"))
+		}
+		return filepath.Join(cgroupDir, "cpuacct.usage"), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(usage), 10, 64)
+}
+
+func (c *cgroupV1) GetMemoryWorkingSet(podPath string) (int64, error) {
+	resolve := func(fileName string) func() (string, error) {
+		return func() (string, error) {
+			podDir, err := getPodCGroupDir(
+				filepath.Join(c.root, "memory"), podPath)
+			return filepath.Join(podDir, fileName), err
+		}
+	}
+
+	usage, err := c.files.read(podPath, "memory.usage_in_bytes",
+		resolve("memory.usage_in_bytes"))
+	if err != nil {
+		return 0, err
+	}
+	stat, err := c.files.read(podPath, "memory.stat", resolve("memory.stat"))
+	if err != nil {
+		return 0, err
+	}
+	return getWorkingSet(usage, stat, "total_inactive_file")
+}
+
+func (c *cgroupV1) GetPIDs(podPath string) ([]int, error) {
+	podDir, err := getPodCGroupDir(filepath.Join(c.root, "cpu"), podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getCGroupPIDs(podDir)
+}
+
+func (c *cgroupV1) GetContainers(podPath string) ([]string, error) {
+	podDir, err := getPodCGroupDir(filepath.Join(c.root, "cpu"), podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getChildCGroups(podDir)
+}
+
+func (c *cgroupV1) GetContainerPIDs(
+	podPath, container string) ([]int, error) {
+
+	podDir, err := getPodCGroupDir(filepath.Join(c.root, "cpu"), podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getCGroupPIDs(filepath.Join(podDir, container))
+}
+
+func (c *cgroupV1) SetCPUShares(podPath string, shares int64) error {
+	podDir, err := getPodCGroupDir(filepath.Join(c.root, "cpu"), podPath)
+	if err != nil {
+		return err
+	}
+	return c.originals.write(podPath, filepath.Join(podDir, "cpu.shares"),
+		strconv.FormatInt(shares, 10))
+}
+
+func (c *cgroupV1) SetCPUQuota(podPath string, quotaUs int64) error {
+	podDir, err := getPodCGroupDir(filepath.Join(c.root, "cpu"), podPath)
+	if err != nil {
+		return err
+	}
+	return c.originals.write(podPath, filepath.Join(podDir, "cpu.cfs_quota_us"),
+		strconv.FormatInt(quotaUs, 10))
+}
+
+func (c *cgroupV1) Restore() error {
+	return c.originals.restore()
+}
+
+func (c *cgroupV1) Release(podPath string) {
+	c.files.release(podPath)
+	c.originals.release(podPath)
+}
+
+// ================================ cgroup v2 ================================
+
+type cgroupV2 struct {
+	root      string
+	files     *cgroupFiles
+	originals *cgroupOriginals
+}
+
+func (c *cgroupV2) GetCPUUsage(podPath string) (int64, error) {
+	return c.readCPUUsage(podPath, "", func() (string, error) {
+		return getPodCGroupDir(c.root, podPath)
+	})
+}
+
+func (c *cgroupV2) GetContainerCPUUsage(
+	podPath, container string) (int64, error) {
+
+	return c.readCPUUsage(podPath, container, func() (string, error) {
+		podDir, err := getPodCGroupDir(c.root, podPath)
+		return filepath.Join(podDir, container), err
+	})
+}
+
+func (c *cgroupV2) GetSystemCPUUsage() (int64, error) {
+	return c.readCPUUsage(SYSTEM_CGROUP_KEY, "", func() (string, error) {
+		return findCGroupDir(c.root, "system.slice")
+	})
+}
+
+func (c *cgroupV2) GetPodsCPUUsage() (int64, error) {
+	return c.readCPUUsage(PODS_CGROUP_KEY, "", func() (string, error) {
+		return findCGroupDir(c.root, "kubepods", "kubepods.slice")
+	})
+}
+
+// readCPUUsage returns the usage_usec in cpu.stat of the cgroup dir finds,
+// in nanoseconds, kept open under key (and container, if any).
+func (c *cgroupV2) readCPUUsage(key, container string,
+	dir func() (string, error)) (int64, error) {
+
+	fileName := filepath.Join(container, "cpu.stat")
+	stat, err := c.files.read(key, fileName, func() (string, error) {
+		cgroupDir, err := dir()
+		return filepath.Join(cgroupDir, "cpu.stat"), err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	usageUs, err := getStatValue(stat, "usage_usec")
+	if err != nil {
+		return 0, fmt.Errorf("cpu.stat of %s: %w", key, err)
+	}
+	return usageUs * 1000, nil
+}
+
+func (c *cgroupV2) GetMemoryWorkingSet(podPath string) (int64, error) {
+	resolve := func(fileName string) func() (string, error) {
+		return func() (string, error) {
+			podDir, err := getPodCGroupDir(c.root, podPath)
+			return filepath.Join(podDir, fileName), err
+		}
+	}
+
+	usage, err := c.files.read(podPath, "memory.current",
+		resolve("memory.current"))
+	if err != nil {
+		return 0, err
+	}
+	stat, err := c.files.read(podPath, "memory.stat", resolve("memory.stat"))
+	if err != nil {
+		return 0, err
+	}
+	return getWorkingSet(usage, stat, "inactive_file")
+}
+
+func (c *cgroupV2) GetPIDs(podPath string) ([]int, error) {
+	podDir, err := getPodCGroupDir(c.root, podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getCGroupPIDs(podDir)
+}
+
+func (c *cgroupV2) GetContainers(podPath string) ([]string, error) {
+	podDir, err := getPodCGroupDir(c.root, podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getChildCGroups(podDir)
+}
+
+func (c *cgroupV2) GetContainerPIDs(
+	podPath, container string) ([]int, error) {
+
+	podDir, err := getPodCGroupDir(c.root, podPath)
+	if err != nil {
+		return nil, err
+	}
+	return getCGroupPIDs(filepath.Join(podDir, container))
+}
+
+func (c *cgroupV2) SetCPUShares(podPath string, shares int64) error {
+	podDir, err := getPodCGroupDir(c.root, podPath)
+	if err != nil {
+		return err
+	}
+	return c.originals.write(podPath, filepath.Join(podDir, "cpu.weight"),
+		strconv.FormatInt(cpuSharesToWeight(shares), 10))
+}
+
+func (c *cgroupV2) SetCPUQuota(podPath string, quotaUs int64) error {
+	podDir, err := getPodCGroupDir(c.root, podPath)
+	if err != nil {
+		return err
+	}
+
+	// cpu.max is "<quota> <period>"; writing only the quota keeps the period
+	quota := "max"
+	if quotaUs >= 0 {
+		quota = strconv.FormatInt(quotaUs, 10)
+	}
+	return c.originals.write(podPath, filepath.Join(podDir, "cpu.max"), quota)
+}
+
+func (c *cgroupV2) Restore() error {
+	return c.originals.restore()
+}
+
+func (c *cgroupV2) Release(podPath string) {
+	c.files.release(podPath)
+	c.originals.release(podPath)
+}
+
+// cpuSharesToWeight converts cgroup v1 cpu.shares to cgroup v2 cpu.weight
+// the same way the kubelet and runc do, mapping [2, 262144] to [1, 10000].
+func cpuSharesToWeight(shares int64) int64 {
+	if shares < MIN_CPU_SHARES {
+		shares = MIN_CPU_SHARES
+	} else if shares > MAX_CPU_SHARES {
+		shares = MAX_CPU_SHARES
+	}
+	return MIN_CPU_WEIGHT + ((shares-MIN_CPU_SHARES)*(MAX_CPU_WEIGHT-MIN_CPU_WEIGHT))/
+		(MAX_CPU_SHARES-MIN_CPU_SHARES)
+}
+
+// ================================= Helpers =================================
+
+// getPodCGroupDir returns the directory of the pod's cgroup under the
+// hierarchy mounted at base, for the cgroupfs driver layout
+// (kubepods/burstable/pod<uid>) or the systemd driver layout
+// (kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice).
+func getPodCGroupDir(base, podPath string) (string, error) {
+
+	cgroupfsDir := filepath.Join(base, "kubepods", podPath)
+	if _, err := os.Stat(cgroupfsDir); err == nil {
+		return cgroupfsDir, nil
+	}
+
+	// "burstable/pod<uid>" -> qos "burstable", pod "pod<uid>"
+	qos, pod := filepath.Split(podPath)
+	qos = strings.Trim(qos, "/")
+	pod = strings.ReplaceAll(pod, "-", "_")
+
+	systemdDir := filepath.Join(base, "kubepods.slice")
+	if qos == "" {
+		systemdDir = filepath.Join(systemdDir, "kubepods-"+pod+".slice")
+	} else {
+		systemdDir = filepath.Join(systemdDir,
+			"kubepods-"+qos+".slice", "kubepods-"+qos+"-"+pod+".slice")
+	}
+	if _, err := os.Stat(systemdDir); err == nil {
+		return systemdDir, nil
+	}
+
+	return "", fmt.Errorf("no cgroup found for pod %s (tried %s and %s)",
+		podPath, cgroupfsDir, systemdDir)
+}
+
+// findCGroupDir returns the first of the cgroups names under base that
+// exists.
+func findCGroupDir(base string, names ...string) (string, error) {
+	for _, name := range names {
+		dir := filepath.Join(base, name)
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup found under %s (tried %s)",
+		base, strings.Join(names, ", "))
+}
+
+// getStatValue returns the value of key in a stat file with one
+// "<key> <value>" pair per line (cpu.stat, memory.stat).
+func getStatValue(stat, key string) (int64, error) {
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.New("no " + key)
+}
+
+// getWorkingSet returns the memory usage less the inactive file cache
+// (inactiveKey in memory.stat), like the kubelet's working set.
+func getWorkingSet(usage, stat, inactiveKey string) (int64, error) {
+	usageBytes, err := strconv.ParseInt(strings.TrimSpace(usage), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	inactive, err := getStatValue(stat, inactiveKey)
+	if err != nil {
+		return 0, fmt.Errorf("memory.stat: %w", err)
+	}
+	if inactive > usageBytes {
+		return 0, nil
+	}
+	return usageBytes - inactive, nil
+}
+
+// getCGroupPIDs returns the PIDs in the cgroup.procs of dir and of the
+// cgroups below it (the containers of a pod).
+func getCGroupPIDs(dir string) ([]int, error) {
+	pids := make([]int, 0)
+	err := filepath.WalkDir(dir, func(
+		path string, entry fs.DirEntry, err error) error {
+
+		if err != nil || entry.IsDir() || entry.Name() != "cgroup.procs" {
+			// cgroups of exited containers may be gone while walking
+			return nil
+		}
+		procs, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		for _, field := range strings.Fields(string(procs)) {
+			pid, err := strconv.Atoi(field)
+			if err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return nil
+	})
+	if err == nil && len(pids) == 0 {
+		err = errors.New("no processes in " + dir)
+	}
+	return pids, err
+}
+
+// getChildCGroups returns the names of the cgroups directly below dir.
+func getChildCGroups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			children = append(children, entry.Name())
+		}
+	}
+	return children, nil
+}
+
+// cgroupFiles keeps cgroup files open for reading, so that reading one costs
+// a single pread instead of an open, read and close.
+type cgroupFiles struct {
+	mu sync.Mutex
+	// pod path -> file name -> open file
+	files map[string]map[string]*os.File
+}
+
+func newCGroupFiles() *cgroupFiles {
+	return &cgroupFiles{files: make(map[string]map[string]*os.File)}
+}
+
+// read returns the contents of the pod's file fileName, opening the path
+// returned by resolve if the file is not open yet.
+func (f *cgroupFiles) read(podPath, fileName string,
+	resolve func() (string, error)) (string, error) {
+
+	file, err := f.open(podPath, fileName, resolve)
+	if err != nil {
+		return "", err
+	}
+
+	// cgroup files are regenerated on every read from offset 0
+	buf := make([]byte, CGROUP_FILE_BUFFER_SIZE)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		// the cgroup may be gone (pod restarted); reopen on the next read
+		f.close(podPath, fileName, file)
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func (f *cgroupFiles) open(podPath, fileName string,
+	resolve func() (string, error)) (*os.File, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if file := f.files[podPath][fileName]; file != nil {
+		return file, nil
+	}
+
+	path, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.files[podPath] == nil {
+		f.files[podPath] = make(map[string]*os.File)
+	}
+	f.files[podPath][fileName] = file
+	return file, nil
+}
+
+func (f *cgroupFiles) close(podPath, fileName string, file *os.File) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.files[podPath][fileName] == file {
+		delete(f.files[podPath], fileName)
+	}
+	file.Close()
+}
+
+func (f *cgroupFiles) release(podPath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, file := range f.files[podPath] {
+		file.Close()
+	}
+	delete(f.files, podPath)
+}
+
+// cgroupOriginals records the contents of the cgroup files the host agent
+// writes, as they were before its first write to each.
+type cgroupOriginals struct {
+	mu sync.Mutex
+	// pod path -> file path -> original contents
+	files map[string]map[string]string
+}
+
+func newCGroupOriginals() *cgroupOriginals {
+	return &cgroupOriginals{files: make(map[string]map[string]string)}
+}
+
+// write writes value to the pod's file path, recording its contents first if
+// they are not recorded yet.
+func (o *cgroupOriginals) write(podPath, path, value string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.files[podPath][path]; !ok {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if o.files[podPath] == nil {
+			o.files[podPath] = make(map[string]string)
+		}
+		o.files[podPath][path] = strings.TrimSpace(string(original))
+	}
+	return writeOSFile(path, value)
+}
+
+// restore writes the original contents back to every file written since the
+// last restore, and returns the errors of the files it could not restore.
+func (o *cgroupOriginals) restore() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	errs := make([]error, 0)
+	for podPath, files := range o.files {
+		for path, original := range files {
+			err := writeOSFile(path, original)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("pod %s: %w", podPath, err))
+			}
+		}
+	}
+	o.files = make(map[string]map[string]string)
+	return errors.Join(errs...)
+}
+
+// release forgets the original contents of the pod's files (e.g. when the
+// pod is gone and its files with it).
+func (o *cgroupOriginals) release(podPath string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.files, podPath)
+}
+
+// writeOSFile writes value to a cgroup file. Writes only happen when the CC
+// applies shares or quotas, so the file is not kept open.
+func writeOSFile(writePath string, value string) error {
+	return os.WriteFile(writePath, []byte(value+"\n"), 0644)
+}
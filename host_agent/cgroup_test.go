@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const testPodPath = "burstable/pod1234-abcd"
+
+// writeFakeFile writes contents to the file at the path relative to root,
+// creating its directories.
+func writeFakeFile(t testing.TB, root, path, contents string) {
+	t.Helper()
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFakeFile returns the contents of the file at the path relative to root.
+func readFakeFile(t testing.TB, root, path string) string {
+	t.Helper()
+	contents, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+// fakeCGroupV2 returns a cgroup v2 tree under a temporary directory with the
+// testPodPath pod in the cgroupfs driver layout, and the pod's directory.
+func fakeCGroupV2(t testing.TB) (root, podDir string) {
+	t.Helper()
+	root = t.TempDir()
+	podDir = filepath.Join("kubepods", testPodPath)
+	writeFakeFile(t, root, "cgroup.controllers", "cpuset cpu io memory pids\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "cpu.stat"),
+		"usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "cpu.weight"), "100\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "cpu.max"), "max 100000\n")
+	return root, podDir
+}
+
+// fakeCGroupV1 returns a cgroup v1 tree under a temporary directory, with cpu
+// and cpuacct co-mounted, with the testPodPath pod in the cgroupfs driver
+// layout, and the pod's directory under the cpu hierarchy.
+func fakeCGroupV1(t testing.TB) (root, podDir string) {
+	t.Helper()
+	root = t.TempDir()
+	podDir = filepath.Join("cpu", "kubepods", testPodPath)
+	writeFakeFile(t, root, filepath.Join(podDir, "cpuacct.usage"), "1500000\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "cpu.shares"), "1024\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "cpu.cfs_quota_us"), "-1\n")
+	return root, podDir
+}
+
+// cgroupVersions are the fake trees of both cgroup versions, with what the
+// host agent reads from and writes to them.
+var cgroupVersions = []struct {
+	name string
+	fake func(t testing.TB) (root, podDir string)
+	// file of the CPU usage of a cgroup and its contents for usage (ns)
+	usageFile     string
+	usageContents func(usage int64) string
+	// files of the CPU controls and their contents after setting 2048
+	// shares, a quota of 50000us and no quota, and before
+	sharesFile, quotaFile         string
+	shares, quota, noQuota        string
+	originalShares, originalQuota string
+}{
+	{
+		name:      "v1",
+		fake:      fakeCGroupV1,
+		usageFile: "cpuacct.usage",
+		usageContents: func(usage int64) string {
+			return fmt.Sprintf("%d\n", usage)
+		},
+		sharesFile:     "cpu.shares",
+		quotaFile:      "cpu.cfs_quota_us",
+		shares:         "2048\n",
+		quota:          "50000\n",
+		noQuota:        "-1\n",
+		originalShares: "1024\n",
+		originalQuota:  "-1\n",
+	},
+	{
+		name:      "v2",
+		fake:      fakeCGroupV2,
+		usageFile: "cpu.stat",
+		usageContents: func(usage int64) string {
+			return fmt.Sprintf("usage_usec %d\nuser_usec 0\nsystem_usec 0\n",
+				usage/1000)
+		},
+		sharesFile:     "cpu.weight",
+		quotaFile:      "cpu.max",
+		shares:         "79\n",
+		quota:          "50000\n",
+		noQuota:        "max\n",
+		originalShares: "100\n",
+		originalQuota:  "max 100000\n",
+	},
+}
+
+func TestNewCGroupDetectsVersion(t *testing.T) {
+	v2Root, _ := fakeCGroupV2(t)
+	if _, ok := NewCGroup(v2Root).(*cgroupV2); !ok {
+		t.Errorf("NewCGroup with cgroup.controllers is not cgroup v2")
+	}
+
+	v1Root := t.TempDir()
+	writeFakeFile(t, v1Root, "cpu/kubepods/cpu.shares", "1024\n")
+	if _, ok := NewCGroup(v1Root).(*cgroupV1); !ok {
+		t.Errorf("NewCGroup without cgroup.controllers is not cgroup v1")
+	}
+}
+
+func TestGetPodCGroupDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		podPath string
+		// directory of the pod created, relative to the base
+		dir string
+	}{
+		{"cgroupfs", "burstable/pod1234-abcd", "kubepods/burstable/pod1234-abcd"},
+		{"cgroupfs guaranteed", "pod1234-abcd", "kubepods/pod1234-abcd"},
+		{"systemd", "burstable/pod1234-abcd",
+			"kubepods.slice/kubepods-burstable.slice/" +
+				"kubepods-burstable-pod1234_abcd.slice"},
+		{"systemd guaranteed", "pod1234-abcd",
+			"kubepods.slice/kubepods-pod1234_abcd.slice"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			want := filepath.Join(base, test.dir)
+			if err := os.MkdirAll(want, 0755); err != nil {
+				t.Fatal(err)
+			}
+			got, err := getPodCGroupDir(base, test.podPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("getPodCGroupDir = %s, want %s", got, want)
+			}
+		})
+	}
+
+	if _, err := getPodCGroupDir(t.TempDir(), testPodPath); err == nil {
+		t.Errorf("getPodCGroupDir of a missing pod did not fail")
+	}
+}
+
+func TestCPUSharesToWeight(t *testing.T) {
+	tests := []struct {
+		shares, weight int64
+	}{
+		{0, MIN_CPU_WEIGHT},
+		{MIN_CPU_SHARES, MIN_CPU_WEIGHT},
+		{1024, 39},
+		{MAX_CPU_SHARES, MAX_CPU_WEIGHT},
+		{MAX_CPU_SHARES + 1, MAX_CPU_WEIGHT},
+	}
+	for _, test := range tests {
+		if weight := cpuSharesToWeight(test.shares); weight != test.weight {
+			t.Errorf("cpuSharesToWeight(%d) = %d, want %d",
+				test.shares, weight, test.weight)
+		}
+	}
+}
+
+func TestCGroupGetCPUUsage(t *testing.T) {
+	for _, version := range cgroupVersions {
+		t.Run(version.name, func(t *testing.T) {
+			root, podDir := version.fake(t)
+			// the system slice and the kubepods cgroup are siblings of the
+			// same hierarchy
+			base := filepath.Dir(filepath.Dir(podDir))
+			systemFile := filepath.Join(filepath.Dir(base), "system.slice",
+				version.usageFile)
+			podsFile := filepath.Join(base, version.usageFile)
+			writeFakeFile(t, root, systemFile, version.usageContents(7000000))
+			writeFakeFile(t, root, podsFile, version.usageContents(9000000))
+			cgroup := NewCGroup(root)
+
+			for what, get := range map[string]func() (int64, error){
+				"pod":    func() (int64, error) { return cgroup.GetCPUUsage(testPodPath) },
+				"system": cgroup.GetSystemCPUUsage,
+				"pods":   cgroup.GetPodsCPUUsage,
+			} {
+				want := map[string]int64{
+					"pod": 1500000, "system": 7000000, "pods": 9000000}[what]
+				if usage, err := get(); err != nil || usage != want {
+					t.Errorf("%s CPU usage = %d (%v), want %d",
+						what, usage, err, want)
+				}
+			}
+
+			// the file kept open is read again
+			writeFakeFile(t, root, filepath.Join(podDir, version.usageFile),
+				version.usageContents(2500000))
+			if usage, _ := cgroup.GetCPUUsage(testPodPath); usage != 2500000 {
+				t.Errorf("pod CPU usage after a change = %d, want %d",
+					usage, 2500000)
+			}
+		})
+	}
+}
+
+func TestCGroupSetCPUControls(t *testing.T) {
+	for _, version := range cgroupVersions {
+		t.Run(version.name, func(t *testing.T) {
+			root, podDir := version.fake(t)
+			cgroup := NewCGroup(root)
+			assertFile := func(fileName, want string) {
+				t.Helper()
+				got := readFakeFile(t, root, filepath.Join(podDir, fileName))
+				if got != want {
+					t.Errorf("%s = %q, want %q", fileName, got, want)
+				}
+			}
+
+			if err := cgroup.SetCPUShares(testPodPath, 2048); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(version.sharesFile, version.shares)
+			if err := cgroup.SetCPUQuota(testPodPath, 50000); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(version.quotaFile, version.quota)
+			if err := cgroup.SetCPUQuota(testPodPath, -1); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(version.quotaFile, version.noQuota)
+		})
+	}
+}
+
+func TestCGroupRestore(t *testing.T) {
+	for _, version := range cgroupVersions {
+		t.Run(version.name, func(t *testing.T) {
+			root, podDir := version.fake(t)
+			cgroup := NewCGroup(root)
+			sharesFile := filepath.Join(podDir, version.sharesFile)
+
+			if err := cgroup.SetCPUShares(testPodPath, 2048); err != nil {
+				t.Fatal(err)
+			}
+			if err := cgroup.SetCPUQuota(testPodPath, 20000); err != nil {
+				t.Fatal(err)
+			}
+			// only the contents before the first write are restored
+			if err := cgroup.SetCPUQuota(testPodPath, 30000); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cgroup.Restore(); err != nil {
+				t.Fatal(err)
+			}
+			for file, want := range map[string]string{
+				version.sharesFile: version.originalShares,
+				version.quotaFile:  version.originalQuota,
+			} {
+				got := readFakeFile(t, root, filepath.Join(podDir, file))
+				if got != want {
+					t.Errorf("restored %s = %q, want %q", file, got, want)
+				}
+			}
+
+			// a released pod is not restored
+			if err := cgroup.SetCPUShares(testPodPath, 2048); err != nil {
+				t.Fatal(err)
+			}
+			cgroup.Release(testPodPath)
+			if err := cgroup.Restore(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFakeFile(t, root, sharesFile); got != version.shares {
+				t.Errorf("%s of a released pod = %q, want %q",
+					version.sharesFile, got, version.shares)
+			}
+		})
+	}
+}
+
+// BenchmarkGetCPUUsage reads cpu.stat through the file kept open by
+// cgroupFiles, as every sample does.
+func BenchmarkGetCPUUsage(b *testing.B) {
+	root, _ := fakeCGroupV2(b)
+	cgroup := NewCGroup(root)
+	for i := 0; i < b.N; i++ {
+		if _, err := cgroup.GetCPUUsage(testPodPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadCPUStat reads cpu.stat with an open, read and close every
+// time, to compare with BenchmarkGetCPUUsage.
+func BenchmarkReadCPUStat(b *testing.B) {
+	root, _ := fakeCGroupV2(b)
+	for i := 0; i < b.N; i++ {
+		podDir, err := getPodCGroupDir(root, testPodPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stat, err := os.ReadFile(filepath.Join(podDir, "cpu.stat"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := getStatValue(string(stat), "usage_usec"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSetCPUShares writes cpu.weight with writeOSFile.
+func BenchmarkSetCPUShares(b *testing.B) {
+	root, _ := fakeCGroupV2(b)
+	cgroup := NewCGroup(root)
+	for i := 0; i < b.N; i++ {
+		shares := int64(MIN_CPU_SHARES + i%1024)
+		if err := cgroup.SetCPUShares(testPodPath, shares); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadCPUStatWithCat reads cpu.stat the way the host agent did
+// before the native reads, forking cat every time.
+func BenchmarkReadCPUStatWithCat(b *testing.B) {
+	root, podDir := fakeCGroupV2(b)
+	path := filepath.Join(root, podDir, "cpu.stat")
+	for i := 0; i < b.N; i++ {
+		if _, err := catCPUUsage(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSetCPUSharesWithScript writes cpu.weight the way the host agent
+// did before the native writes, running writetofile.sh in bash every time.
+func BenchmarkSetCPUSharesWithScript(b *testing.B) {
+	root, podDir := fakeCGroupV2(b)
+	script := writeToFileScript(b)
+	path := filepath.Join(root, podDir, "cpu.weight")
+	for i := 0; i < b.N; i++ {
+		weight := cpuSharesToWeight(int64(MIN_CPU_SHARES + i%1024))
+		if err := scriptWrite(script, path, weight); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// pods on the node of BenchmarkRound
+const BENCHMARK_ROUND_PODS = 30
+
+// BenchmarkRound is the cgroup work of one round on a node of
+// BENCHMARK_ROUND_PODS pods: reading the CPU usage of every pod and setting
+// its shares, natively and by forking cat and bash as before.
+func BenchmarkRound(b *testing.B) {
+	root, podPaths := fakeCGroupV2Pods(b, BENCHMARK_ROUND_PODS)
+
+	b.Run("native", func(b *testing.B) {
+		cgroup := NewCGroup(root)
+		for i := 0; i < b.N; i++ {
+			for _, podPath := range podPaths {
+				if _, err := cgroup.GetCPUUsage(podPath); err != nil {
+					b.Fatal(err)
+				}
+				shares := int64(MIN_CPU_SHARES + i%1024)
+				if err := cgroup.SetCPUShares(podPath, shares); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("fork", func(b *testing.B) {
+		script := writeToFileScript(b)
+		for i := 0; i < b.N; i++ {
+			for _, podPath := range podPaths {
+				podDir, err := getPodCGroupDir(root, podPath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = catCPUUsage(filepath.Join(podDir, "cpu.stat"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				weight := cpuSharesToWeight(int64(MIN_CPU_SHARES + i%1024))
+				err = scriptWrite(
+					script, filepath.Join(podDir, "cpu.weight"), weight)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// fakeCGroupV2Pods returns a cgroup v2 tree like fakeCGroupV2's with n
+// burstable pods, and their pod paths.
+func fakeCGroupV2Pods(b *testing.B, n int) (root string, podPaths []string) {
+	b.Helper()
+	root, _ = fakeCGroupV2(b)
+	for i := 0; i < n; i++ {
+		podPath := fmt.Sprintf("burstable/pod%04d-abcd", i)
+		podDir := filepath.Join("kubepods", podPath)
+		writeFakeFile(b, root, filepath.Join(podDir, "cpu.stat"),
+			"usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n")
+		writeFakeFile(b, root, filepath.Join(podDir, "cpu.weight"), "100\n")
+		podPaths = append(podPaths, podPath)
+	}
+	return root, podPaths
+}
+
+// catCPUUsage returns the usage_usec in the cpu.stat file at path, read with
+// cat.
+func catCPUUsage(path string) (int64, error) {
+	out, err := exec.Command("cat", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	return getStatValue(string(out), "usage_usec")
+}
+
+// writeToFileScript returns the path of a copy of the writetofile.sh the host
+// agent ran to write cgroup files.
+func writeToFileScript(b *testing.B) string {
+	b.Helper()
+	dir := b.TempDir()
+	writeFakeFile(b, dir, "writetofile.sh", "#!/usr/bin/bash\n\necho $1 > $2\n")
+	return filepath.Join(dir, "writetofile.sh")
+}
+
+// scriptWrite writes value to the file at path with the writetofile.sh
+// script.
+func scriptWrite(script, path string, value int64) error {
+	return exec.Command(
+		"bash", script, strconv.FormatInt(value, 10), path).Run()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+/*
+ccLease is how long the host agent keeps what the CC applied after losing
+touch with it. Every call of the CC renews the lease, and so does every
+message sent on a StreamCPUUtilizations stream. An open stream alone does
+not: to a partitioned CC, or over a half-open connection, the stream stays
+open until the server's keepalive (CC_KEEPALIVE_TIME_MS) closes it, but
+nothing sent on it gets through. When the lease runs out (the CC exited,
+crashed or cannot reach the node for CC_LEASE_MS), onExpire resets the host
+agent to a neutral state, as if the CC had called Reset. The lease only runs
+out once per loss of touch.
+*/
+type ccLease struct {
+	period   time.Duration
+	onExpire func() error
+
+	mu        sync.Mutex
+	lastTouch time.Time
+	expired   bool
+}
+
+func newCCLease(period time.Duration, onExpire func() error) *ccLease {
+	// nothing to reset before the CC first calls
+	return &ccLease{period: period, onExpire: onExpire, expired: true}
+}
+
+func (l *ccLease) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastTouch = time.Now()
+	l.expired = false
+}
+
+// Run checks the lease forever, calling onExpire when it runs out.
+func (l *ccLease) Run() {
+
+	slog.Info(fmt.Sprintf("CC lease is %v", l.period))
+
+	ticker := time.NewTicker(l.period / 10)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.check()
+	}
+}
+
+// check calls onExpire if the lease ran out since the last check.
+func (l *ccLease) check() {
+	l.mu.Lock()
+	expired := !l.expired && time.Since(l.lastTouch) > l.period
+	if expired {
+		l.expired = true
+	}
+	l.mu.Unlock()
+
+	if expired {
+		slog.Warn("CC lease expired, resetting")
+		l.onExpire()
+	}
+}
+
+// unaryInterceptor renews the lease on every unary call of the CC
+func (l *ccLease) unaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	l.touch()
+	return handler(ctx, req)
+}
+
+// streamInterceptor renews the lease on every message sent to the CC on a
+// stream
+func (l *ccLease) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	l.touch()
+	return handler(srv, &leaseStream{ServerStream: stream, lease: l})
+}
+
+// leaseStream is a stream of the CC that renews the lease on every message
+// sent successfully.
+type leaseStream struct {
+	grpc.ServerStream
+	lease *ccLease
+}
+
+func (s *leaseStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.lease.touch()
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// fakeServerStream is a stream whose sends fail with err.
+type fakeServerStream struct {
+	grpc.ServerStream
+	err error
+}
+
+func (s *fakeServerStream) SendMsg(m interface{}) error {
+	return s.err
+}
+
+func TestCCLeaseRenewedBySends(t *testing.T) {
+	resets := 0
+	lease := newCCLease(time.Minute, func() error {
+		resets++
+		return nil
+	})
+	// the lease was last renewed longer ago than its period
+	age := func() {
+		lease.mu.Lock()
+		lease.lastTouch = time.Now().Add(-2 * time.Minute)
+		lease.mu.Unlock()
+	}
+
+	fake := &fakeServerStream{}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		// a message sent renews the lease
+		age()
+		if err := stream.SendMsg(nil); err != nil {
+			t.Fatal(err)
+		}
+		lease.check()
+		if resets != 0 {
+			t.Errorf("reset after a message was sent")
+		}
+
+		// the stream is still open, but nothing gets through (e.g. the CC
+		// is partitioned): the lease runs out, once
+		fake.err = errors.New("transport is closing")
+		age()
+		if err := stream.SendMsg(nil); err == nil {
+			t.Errorf("send did not fail")
+		}
+		lease.check()
+		lease.check()
+		if resets != 1 {
+			t.Errorf("reset %d times with the stream open, want 1", resets)
+		}
+		return nil
+	}
+	if err := lease.streamInterceptor(nil, fake,
+		&grpc.StreamServerInfo{}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// a call of the CC renews it again
+	lease.unaryInterceptor(nil, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	lease.check()
+	if resets != 1 {
+		t.Errorf("reset %d times after a call, want 1", resets)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,11 +10,12 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
+	"protocol"
 	"sync"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 const (
@@ -22,22 +25,41 @@ const (
 	LB_SERVER_PORT              = 9989
 	CPU_UTILIZATION_INTERVAL_MS = 100
 	DEFAULT_LB_WEIGHTS          = ""
+
+	// the CPU usage of every pod is sampled every CPU_SAMPLE_INTERVAL_MS,
+	// and the samples of the last CPU_SAMPLE_HISTORY_MS are kept
+	CPU_SAMPLE_INTERVAL_MS = 100
+	CPU_SAMPLE_HISTORY_MS  = 60_000
+
+	// the host agent resets when it has not heard from the CC for this long
+	// (see lease.go)
+	CC_LEASE_MS = 30_000
+	// the connection of the CC is pinged after CC_KEEPALIVE_TIME_MS without
+	// activity and closed if the ping is not answered within
+	// CC_KEEPALIVE_TIMEOUT_MS, well within CC_LEASE_MS
+	CC_KEEPALIVE_TIME_MS    = 10_000
+	CC_KEEPALIVE_TIMEOUT_MS = 5_000
 )
 
 /*
 What does this server do:
 
-1. Listen for connections from CC
-2. When a connection is received, handle the connection in a new goroutine
-3. In the goroutine, read the message from the connection
-4. If the message is an request to update the pod state, update agent's pod
-	state, and send a success/failure response
+1. Serve the HostAgent gRPC service (defined in the shared protocol package)
+	for the CC
+2. If the call is a request to update the pod state, update agent's pod
+	state, and return a success/failure response
 	(state would contain list of podnames to uid mappings in the node)
-4. If the message is a request for the server to apply CPU shares,
-	apply the CPU shares in the kernel, and send a success/failure response
-5. If the message is a request for the server to get CPU utilizations,
-	send the CPU utilizations for each pod
-6. Repeat from 3. indefinitely (until connection is closed)
+3. If the call is a request for the server to apply CPU shares/quotas or
+	LB weights, apply them, and return a success/failure response
+4. If the call subscribes to CPU utilizations, send the CPU utilizations
+	for each pod over the requested window on the stream every interval,
+	until the CC cancels it
+5. In the background, sample the CPU usage, memory and network of each pod
+	(see sampler.go), and send the memory and network usage with the CPU
+	utilizations
+6. If the CC asks for a reset, or has not been in touch for CC_LEASE_MS
+	(see lease.go), restore the cgroup values of the pods from before they
+	were first set and stop serving LB weights
 */
 
 type SafeLBWeights struct {
@@ -71,22 +93,46 @@ func startServerForCC(lbWeights *SafeLBWeights) {
 		os.Exit(1)
 	}
 
-	defer server.Close()
-
 	fmt.Println(
 		"Listening on " + CC_SERVER_HOST + fmt.Sprintf(":%d", CC_SERVER_PORT))
 	fmt.Println("Waiting for client...")
 
-	for {
-		connection, err := server.Accept()
-		if err != nil {
-			fmt.Println("Error accepting: ", err.Error())
-			os.Exit(1)
-		}
-		fmt.Println("client connected")
-		go processClient(connection, lbWeights)
-	}
+	cgroup := NewCGroup(CGROUP_ROOT)
+	fmt.Printf("Using %T at %s\n", cgroup, CGROUP_ROOT)
 
+	hostAgent := &hostAgentServer{
+		lbWeights: lbWeights,
+		cgroup:    cgroup,
+		network:   newNetworkReader(PROC_ROOT, cgroup),
+		sidecars:  newSidecarReader(PROC_ROOT, cgroup),
+		podUIDs:   make(map[string]string),
+	}
+	hostAgent.sampler = newCPUSampler(cgroup, hostAgent.network,
+		hostAgent.sidecars, hostAgent.getPodUIDs,
+		CPU_SAMPLE_INTERVAL_MS*time.Millisecond,
+		CPU_SAMPLE_HISTORY_MS*time.Millisecond)
+	go hostAgent.sampler.Run()
+
+	lease := newCCLease(CC_LEASE_MS*time.Millisecond, hostAgent.reset)
+	go lease.Run()
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(lease.unaryInterceptor),
+		grpc.StreamInterceptor(lease.streamInterceptor),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    CC_KEEPALIVE_TIME_MS * time.Millisecond,
+			Timeout: CC_KEEPALIVE_TIMEOUT_MS * time.Millisecond,
+		}),
+		// the CC may ping as often as the host agent does
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             CC_KEEPALIVE_TIME_MS * time.Millisecond,
+			PermitWithoutStream: true,
+		}))
+	protocol.RegisterHostAgentServer(grpcServer, hostAgent)
+	if err := grpcServer.Serve(server); err != nil {
+		fmt.Println("Error serving: ", err.Error())
+		os.Exit(1)
+	}
 }
 
 func listenForReqsFromLB(lbWeights *SafeLBWeights) {
@@ -123,352 +169,277 @@ func listenForReqsFromLB(lbWeights *SafeLBWeights) {
 
 }
 
-func processClient(connection net.Conn, lbWeights *SafeLBWeights) {
-
-	defer connection.Close()
-
-	podUIDs := make(map[string]string)
-
-	for {
+// hostAgentServer implements protocol.HostAgentServer
+type hostAgentServer struct {
+	lbWeights *SafeLBWeights
+	cgroup    CGroup
+	network   *networkReader
+	sidecars  *sidecarReader
+	sampler   *cpuSampler
 
-		msgFromCC, err := readMsgFromConnection(connection)
-		if err != nil {
-			fmt.Println("Error reading:", err.Error())
-			break
-		}
-		slog.Info("Received: " + msgFromCC)
-
-		msgType := strings.Split(msgFromCC, " ")[0]
-
-		if msgType == "updatePods" {
-			newPodUIDs, ok := getNewPods(msgFromCC)
-			if ok {
-				podUIDs = newPodUIDs
-			}
-			sendSuccessOrFailResponse(connection, ok)
-
-		} else if msgType == "applyLBWeights" {
-			ok := updateLBWeights(podUIDs, msgFromCC, lbWeights)
-			sendSuccessOrFailResponse(connection, ok)
-
-		} else if msgType == "applyCPUShares" {
-			ok := applyCPUShares(podUIDs, msgFromCC)
-			sendSuccessOrFailResponse(connection, ok)
-
-		} else if msgType == "applyCPUQuotas" {
-			ok := applyCPUQuotas(podUIDs, msgFromCC)
-			sendSuccessOrFailResponse(connection, ok)
-
-		} else if msgType == "getCPUUtilizations" {
-			cpuUtilizations := getCPUUtilizations(podUIDs)
-			sendMsgToConnection(connection, cpuUtilizations)
-
-		} else {
-			// unknown message type
-			sendMsgToConnection(connection, "Unknown message type")
-		}
-	}
+	mu      sync.Mutex
+	podUIDs map[string]string
+}
 
-	slog.Warn("Client disconnected")
+func (s *hostAgentServer) getPodUIDs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.podUIDs
 }
 
-func getNewPods(msg string) (map[string]string, bool) {
-	// parse the message and update the state
-	// return true if successful, false otherwise
+func (s *hostAgentServer) Version(
+	ctx context.Context, req *protocol.Empty) (*protocol.VersionResponse, error) {
 
-	// example message to parse: "updateState pod1:uid1 pod2:uid2"
+	return &protocol.VersionResponse{Version: protocol.VERSION}, nil
+}
 
-	podUIDs := make(map[string]string)
-	podStrs := strings.Split(msg, " ")[1:]
-	for _, podStr := range podStrs {
-		podNameToUID := strings.Split(podStr, ":")
-		if len(podNameToUID) != 2 {
-			return podUIDs, false
+func (s *hostAgentServer) UpdatePods(
+	ctx context.Context, req *protocol.UpdatePodsRequest) (
+	*protocol.Empty, error) {
+
+	s.mu.Lock()
+	oldPodUIDs := s.podUIDs
+	s.podUIDs = req.Pods
+	s.mu.Unlock()
+
+	// close the cgroup files of pods that are gone or were restarted
+	for podName, uid := range oldPodUIDs {
+		if req.Pods[podName] != uid {
+			s.cgroup.Release(uid)
+			s.network.Release(uid)
+			s.sidecars.Release(uid)
 		}
-		podUIDs[podNameToUID[0]] = podNameToUID[1]
 	}
 
-	slog.Info("Updated pods: " + fmt.Sprintf("%v", podUIDs))
+	slog.Info("Updated pods: " + fmt.Sprintf("%v", req.Pods))
 
-	return podUIDs, true
+	return &protocol.Empty{}, nil
 }
 
-func applyCPUQuotas(podUIDs map[string]string, msg string) bool {
-	// parse the message and apple CPU quota
-	// return true if successful, false otherwise
-
-	podQuotas, ok := parsePodShares(msg)
-	if !ok {
-		return false
-	}
+func (s *hostAgentServer) ApplyLBWeights(
+	ctx context.Context, req *protocol.ApplyLBWeightsRequest) (
+	*protocol.Empty, error) {
 
-	for podName, share := range podQuotas {
+	ok := updateLBWeights(req.Weights, s.lbWeights)
+	return successOrFailure(ok, "applyLBWeights")
+}
 
-		fileName := "/host/sys/fs/cgroup/cpu/kubepods/" +
-			podUIDs[podName] + "/cpu.cfs_quota_us"
-		// fileName := "/Users/twaheed2/go/src/host_agent/" +
-		// 	podUIDs[podName]
+func (s *hostAgentServer) ApplyCPUShares(
+	ctx context.Context, req *protocol.ApplyCPUSharesRequest) (
+	*protocol.Empty, error) {
 
-		// err := os.WriteFile(fileName, []byte(share+"\n"), 0644)
-		// if err != nil {
-		// 	slog.Warn(err.Error())
-		// 	return false
-		// }
+	err := applyCPUShares(s.cgroup, s.getPodUIDs(), req.Shares)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.Empty{}, nil
+}
 
-		slog.Info(fmt.Sprintf("%s %s %s %s",
-			"bash", "./writetofile.sh", share, fileName))
+func (s *hostAgentServer) ApplyCPUQuotas(
+	ctx context.Context, req *protocol.ApplyCPUQuotasRequest) (
+	*protocol.Empty, error) {
 
-		cmd := exec.Command("bash", "./writetofile.sh", share, fileName)
-		_, err := cmd.Output()
-		if err != nil {
-			slog.Warn(err.Error())
-			return false
-		}
+	err := applyCPUQuotas(s.cgroup, s.getPodUIDs(), req.Quotas)
+	if err != nil {
+		return nil, err
 	}
+	return &protocol.Empty{}, nil
+}
 
-	slog.Info("Applied CPU shares: " + fmt.Sprintf("%v", podQuotas))
+func (s *hostAgentServer) Reset(
+	ctx context.Context, req *protocol.Empty) (*protocol.Empty, error) {
 
-	return true
+	err := s.reset()
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.Empty{}, nil
 }
 
-func updateLBWeights(
-	podUIDs map[string]string, msg string, lbWeights *SafeLBWeights) bool {
-	// parse the message and update lb weights
-	// return true if successful, false otherwise
+// reset restores the cgroup values of the pods from before they were first
+// set and clears the LB weights.
+func (s *hostAgentServer) reset() error {
 
-	// newLBWeights, ok := parseLBWeights(msg)
-	// if !ok {
-	// 	return false
-	// }
+	s.lbWeights.mu.Lock()
+	s.lbWeights.weights = DEFAULT_LB_WEIGHTS
+	s.lbWeights.mu.Unlock()
 
-	lbWeights.mu.Lock()
-	lbWeights.weights = msg
-	lbWeights.mu.Unlock()
+	err := s.cgroup.Restore()
+	if err != nil {
+		slog.Warn("Failed to restore cgroups: " + err.Error())
+		return err
+	}
 
-	slog.Info("Updated LB weights: " + msg)
+	slog.Info("Reset cgroups and LB weights")
 
-	return true
+	return nil
 }
 
-func applyCPUShares(podUIDs map[string]string, msg string) bool {
-	// parse the message and apple CPU shares
-	// return true if successful, false otherwise
+func (s *hostAgentServer) StreamCPUUtilizations(
+	req *protocol.StreamCPUUtilizationsRequest,
+	stream protocol.CPUUtilizationsStreamServer) error {
 
-	podShares, ok := parsePodShares(msg)
-	if !ok {
-		return false
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval < CPU_UTILIZATION_INTERVAL_MS*time.Millisecond {
+		interval = CPU_UTILIZATION_INTERVAL_MS * time.Millisecond
 	}
 
-	for podName, share := range podShares {
-
-		fileName := "/host/sys/fs/cgroup/cpu/kubepods/" +
-			podUIDs[podName] + "/cpu.shares"
-		// fileName := "/Users/twaheed2/go/src/host_agent/" +
-		// 	podUIDs[podName]
-
-		// err := os.WriteFile(fileName, []byte(share+"\n"), 0644)
-		// if err != nil {
-		// 	slog.Warn(err.Error())
-		// 	return false
-		// }
-
-		slog.Info(fmt.Sprintf("%s %s %s %s",
-			"bash", "./writetofile.sh", share, fileName))
+	// average over the whole interval unless asked otherwise
+	window := time.Duration(req.WindowMs) * time.Millisecond
+	if window <= 0 {
+		window = interval
+	} else if window > CPU_SAMPLE_HISTORY_MS*time.Millisecond {
+		window = CPU_SAMPLE_HISTORY_MS * time.Millisecond
+	}
 
-		cmd := exec.Command("bash", "./writetofile.sh", share, fileName)
-		_, err := cmd.Output()
-		if err != nil {
-			slog.Warn(err.Error())
-			return false
+	// the other windows, by the window asked for
+	windows := make(map[int64]time.Duration, len(req.WindowsMs))
+	for _, windowMs := range req.WindowsMs {
+		windows[windowMs] = time.Duration(windowMs) * time.Millisecond
+		if windows[windowMs] > CPU_SAMPLE_HISTORY_MS*time.Millisecond {
+			windows[windowMs] = CPU_SAMPLE_HISTORY_MS * time.Millisecond
 		}
 	}
 
-	slog.Info("Applied CPU shares: " + fmt.Sprintf("%v", podShares))
-
-	return true
-}
-
-func parsePodShares(msg string) (map[string]string, bool) {
+	slog.Info(fmt.Sprintf(
+		"CC subscribed to CPU utilizations every %v over %v (percentiles %v, "+
+			"windows %v ms)", interval, window, req.Percentiles, req.WindowsMs))
 
-	// example message to parse: "applyCPUShares pod1:45 pod2:69"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	podShares := make(map[string]string)
-	podStrs := strings.Split(msg, " ")[1:]
-	for _, podStr := range podStrs {
-		podNameToShare := strings.Split(podStr, ":")
-		if len(podNameToShare) != 2 {
-			return podShares, false
+	unmanagedErr := ""
+	for {
+		podUIDs := s.getPodUIDs()
+		utilizations, percentiles, podErrors := s.sampler.getCPUUtilizations(
+			podUIDs, window, req.Percentiles)
+		var windowUtilizations map[int64]map[string]float64
+		if len(windows) > 0 {
+			windowUtilizations = make(map[int64]map[string]float64, len(windows))
+			for windowMs, window := range windows {
+				windowUtilizations[windowMs], _, _ =
+					s.sampler.getCPUUtilizations(podUIDs, window, nil)
+			}
 		}
-		share, err := strconv.ParseFloat(podNameToShare[1], 64)
-		if err != nil {
-			return podShares, false
+		memoryWorkingSets, networkBytesPerSec := s.sampler.getResourceUsages(
+			podUIDs, window)
+		unmanaged, err := s.sampler.getUnmanagedUtilization(podUIDs, window)
+		if err != nil && err.Error() != unmanagedErr {
+			// once, not every interval
+			slog.Warn("No unmanaged CPU utilization: " + err.Error())
+			unmanagedErr = err.Error()
 		}
-		podShares[podNameToShare[0]] = fmt.Sprintf("%d", int64(share))
-	}
-
-	return podShares, true
-}
-
-func parseLBWeights(msg string) (map[string]float64, bool) {
-
-	// example message to parse: "updateLBWeights svcA:45.5|69.22 svcB:54.7|44.1"
-
-	weights := make(map[string]float64)
-	podStrs := strings.Split(msg, " ")[1:]
-	for _, podStr := range podStrs {
-		podNameToShare := strings.Split(podStr, ":")
-		if len(podNameToShare) != 2 {
-			return weights, false
+		sample := &protocol.CPUUtilizationSample{
+			Time:                 time.Now().UnixNano(),
+			Utilizations:         utilizations,
+			Percentiles:          percentiles,
+			WindowUtilizations:   windowUtilizations,
+			Errors:               podErrors,
+			MemoryWorkingSets:    memoryWorkingSets,
+			NetworkBytesPerSec:   networkBytesPerSec,
+			UnmanagedUtilization: unmanaged,
 		}
-		share, err := strconv.ParseFloat(podNameToShare[1], 64)
-		if err != nil {
-			return weights, false
+		if err := stream.Send(sample); err != nil {
+			slog.Warn("Error sending CPU utilizations: " + err.Error())
+			return err
 		}
-		weights[podNameToShare[0]] = share
-	}
 
-	return weights, true
+		select {
+		case <-stream.Context().Done():
+			slog.Warn("CC unsubscribed from CPU utilizations")
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
 
-func getOSFile(readPath string) (string, error) {
-
-	// Reliable, but really really slow.
-	out, err := exec.Command("cat", readPath).Output()
-	return string(out), err
-
-	// file, err := os.Open(readPath)
-	// if err != nil {
-	// 	fmt.Printf("Error opening file: %v\n", err)
-	// 	return "", err
-	// }
-	// defer file.Close()
-
-	// readBuf := make([]byte, 4096)
-	// readFile := file
-
-	// // Seek should tell us the new offset (0) and no err.
-	// bytesRead := 0
-	// _, err = readFile.Seek(0, 0)
-
-	// // Loop until N > 0 AND err != EOF && err != timeout.
-	// if err == nil {
-	// 	n := 0
-	// 	for {
-	// 		n, err = readFile.Read(readBuf)
-	// 		bytesRead += n
-	// 		if os.IsTimeout(err) {
-	// 			// bail out.
-	// 			bytesRead = 0
-	// 			break
-	// 		}
-	// 		if err == io.EOF {
-	// 			// Success!
-	// 			break
-	// 		}
-	// 		// Any other err means 'keep trying to read.'
-	// 	}
-	// }
-
-	// return string(readBuf), err
+func successOrFailure(ok bool, msgType string) (*protocol.Empty, error) {
+	if !ok {
+		return nil, errors.New("failed to " + msgType)
+	}
+	return &protocol.Empty{}, nil
 }
 
-func getCPUUtilizations(podUIDs map[string]string) string {
-
-	response := "utils:"
-
-	initialCPUUtils := make(map[string]int64)
-	finalCPUUtils := make(map[string]int64)
-
-	for podName, uid := range podUIDs {
-		initialCPUUtils[podName] = getPodCPUUtil(uid)
+func applyCPUQuotas(
+	cgroup CGroup, podUIDs map[string]string, podQuotas map[string]int64) error {
+	// apply CPU quotas
+	// return the errors of the pods they could not be applied to
+
+	podErrors := make([]error, 0)
+	for podName, quota := range podQuotas {
+		uid, ok := podUIDs[podName]
+		if !ok {
+			podErrors = append(podErrors,
+				errors.New("pod "+podName+": unknown pod"))
+			continue
+		}
+		err := cgroup.SetCPUQuota(uid, quota)
+		if err != nil {
+			podErrors = append(podErrors,
+				fmt.Errorf("pod %s: %w", podName, err))
+		}
 	}
-	intialTime := time.Now().UnixNano()
-
-	time.Sleep(CPU_UTILIZATION_INTERVAL_MS * time.Millisecond)
 
-	for podName, uid := range podUIDs {
-		finalCPUUtils[podName] = getPodCPUUtil(uid)
+	if len(podErrors) > 0 {
+		err := errors.Join(podErrors...)
+		slog.Warn("Failed to apply CPU quotas: " + err.Error())
+		return err
 	}
-	timeElapsed := time.Now().UnixNano() - intialTime
 
-	for podName := range podUIDs {
-		response += fmt.Sprintf(" %s:%f",
-			podName,
-			(float64(finalCPUUtils[podName]-initialCPUUtils[podName])/
-				float64(timeElapsed))*100)
-	}
+	slog.Info("Applied CPU quotas: " + fmt.Sprintf("%v", podQuotas))
 
-	return response
+	return nil
 }
 
-// // pathExists checks if a given path exists and is either a file or a directory.
-// func pathExists(path string) bool {
-// 	_, err := os.Stat(path)
-// 	if os.IsNotExist(err) {
-// 		return false
-// 	}
-// 	return err == nil
-// }
-
-// func getPodcgroupFilePath(uid string) string {
-// 	qosClass := []string{"burstable/", "besteffort/", ""}
-// 	for _, class := range qosClass {
-// 		fileName := "/sys/fs/cgroup/cpu/kubepods/" + class + "pod" + uid
-// 		if pathExists(fileName) {
-// 			return fileName
-// 		} else {
-// 			slog.Warn("No cgroup path found: " + fileName)
-// 		}
-// 	}
-// 	slog.Warn("No cgroup file found for pod: " + uid)
-// 	return "/sys/fs/cgroup/cpu/kubepods/" + qosClass[0] + "pod" + uid
-// }
-
-func getPodCPUUtil(uid string) int64 {
-	// get the CPU utilization of the pod
-	// return the CPU utilization
-
-	// read the file and return the value
-	fileName := "/host/sys/fs/cgroup/cpu/kubepods/" + uid + "/cpuacct.usage"
-
-	cpuUtil, err := getOSFile(fileName)
+func updateLBWeights(weights string, lbWeights *SafeLBWeights) bool {
+	// update lb weights
+	// return true if successful, false otherwise
+
+	// the weights are served to the load balancers as they are, so that
+	// plugins that only know the positional format keep working while the
+	// CC sends it (see lbweights.go in protocol)
+	_, err := protocol.ParseLBWeights(weights)
 	if err != nil {
-		slog.Warn(err.Error())
-		return -1
+		slog.Warn("Invalid LB weights: " + err.Error())
+		return false
 	}
 
-	slog.Info("CPU Utilization [" + uid + "]: \"" + cpuUtil + "\"")
-
-	cpuUtilStr := strings.Trim(cpuUtil, "\n")
+	lbWeights.mu.Lock()
+	lbWeights.weights = weights
+	lbWeights.mu.Unlock()
 
-	cpuUtilInt64, err := strconv.ParseInt(cpuUtilStr, 10, 64)
-	if err != nil {
-		slog.Warn(err.Error())
-		return -1
-	}
+	slog.Info("Updated LB weights: " + weights)
 
-	return cpuUtilInt64
+	return true
 }
 
-func sendSuccessOrFailResponse(connection net.Conn, ok bool) {
-	if ok {
-		sendMsgToConnection(connection, "Success")
-	} else {
-		sendMsgToConnection(connection, "Failure")
+func applyCPUShares(
+	cgroup CGroup, podUIDs map[string]string, podShares map[string]int64) error {
+	// apply CPU shares
+	// return the errors of the pods they could not be applied to
+
+	podErrors := make([]error, 0)
+	for podName, cpuShare := range podShares {
+		uid, ok := podUIDs[podName]
+		if !ok {
+			podErrors = append(podErrors,
+				errors.New("pod "+podName+": unknown pod"))
+			continue
+		}
+		err := cgroup.SetCPUShares(uid, cpuShare)
+		if err != nil {
+			podErrors = append(podErrors,
+				fmt.Errorf("pod %s: %w", podName, err))
+		}
 	}
-}
 
-func readMsgFromConnection(connection net.Conn) (string, error) {
-	buffer := make([]byte, 4096)
-	mLen, err := connection.Read(buffer)
-	return string(buffer[:mLen]), err
-}
-
-func sendMsgToConnection(connection net.Conn, msg string) {
-	_, err := connection.Write([]byte(msg))
-	if err != nil {
-		fmt.Println("Error writing:", err.Error())
-	} else {
-		slog.Info("Sent: " + msg)
+	if len(podErrors) > 0 {
+		err := errors.Join(podErrors...)
+		slog.Warn("Failed to apply CPU shares: " + err.Error())
+		return err
 	}
+
+	slog.Info("Applied CPU shares: " + fmt.Sprintf("%v", podShares))
+
+	return nil
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// proc filesystem of the host, mounted into the host agent's container
+const PROC_ROOT = "/host/proc"
+
+// errHostNetwork is returned for pods on the host network, whose network
+// bytes are the host's
+var errHostNetwork = errors.New("pod uses the host network")
+
+/*
+networkReader reads the network bytes of pods from the proc filesystem of the
+host: the bytes received and sent on every interface of the pod's network
+namespace but loopback, from /proc/<pid>/net/dev of a process of the pod.
+
+The PID read through is kept for each pod until reading through it fails
+(e.g. the process exited), so that the pod's cgroups are only walked for its
+processes when needed (see CGroup.GetPIDs).
+*/
+type networkReader struct {
+	procRoot string
+	cgroup   CGroup
+	// network namespace of the host ("" if unknown)
+	hostNetNS string
+
+	mu sync.Mutex
+	// cgroup path -> PID
+	pids map[string]int
+}
+
+func newNetworkReader(procRoot string, cgroup CGroup) *networkReader {
+	hostNetNS, _ := os.Readlink(filepath.Join(procRoot, "1", "ns", "net"))
+	return &networkReader{
+		procRoot:  procRoot,
+		cgroup:    cgroup,
+		hostNetNS: hostNetNS,
+		pids:      make(map[string]int),
+	}
+}
+
+// GetNetworkBytes returns the total bytes received and sent by the pod.
+func (r *networkReader) GetNetworkBytes(podPath string) (int64, error) {
+	r.mu.Lock()
+	pid, ok := r.pids[podPath]
+	r.mu.Unlock()
+
+	if ok {
+		bytes, err := r.read(pid)
+		if err == nil || errors.Is(err, errHostNetwork) {
+			return bytes, err
+		}
+	}
+
+	pids, err := r.cgroup.GetPIDs(podPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, pid := range pids {
+		var bytes int64
+		bytes, err = r.read(pid)
+		if err == nil || errors.Is(err, errHostNetwork) {
+			r.mu.Lock()
+			r.pids[podPath] = pid
+			r.mu.Unlock()
+			return bytes, err
+		}
+	}
+	// the error of the last process
+	return 0, err
+}
+
+// Release forgets the PID of the pod.
+func (r *networkReader) Release(podPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pids, podPath)
+}
+
+func (r *networkReader) read(pid int) (int64, error) {
+	procDir := filepath.Join(r.procRoot, strconv.Itoa(pid))
+
+	// the namespaces of other users' processes can only be read with
+	// CAP_SYS_PTRACE; without it, host network pods cannot be told apart
+	if r.hostNetNS != "" {
+		netNS, err := os.Readlink(filepath.Join(procDir, "ns", "net"))
+		if err == nil && netNS == r.hostNetNS {
+			return 0, errHostNetwork
+		}
+	}
+
+	netDev, err := os.ReadFile(filepath.Join(procDir, "net", "dev"))
+	if err != nil {
+		return 0, err
+	}
+	return parseNetDev(string(netDev))
+}
+
+// parseNetDev returns the bytes received and sent on all the interfaces of a
+// /proc/<pid>/net/dev but loopback. After two header lines, every line is
+//
+//	<interface>: <rx bytes> <7 more rx fields> <tx bytes> <7 more tx fields>
+func parseNetDev(netDev string) (int64, error) {
+	total := int64(0)
+	for _, line := range strings.Split(netDev, "\n") {
+		iface, counters, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+		for _, field := range []string{fields[0], fields[8]} {
+			bytes, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			total += bytes
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+What does the CPU sampler do:
+
+1. Every sampling interval, read the cumulative CPU usage of every pod and of
+	its istio-proxy container (see sidecar.go), the memory working set and
+	the cumulative network bytes of every pod, and the cumulative CPU usage
+	of the system services and of all the pods of the host, and record them
+	in a ring buffer holding the last history/interval samples
+2. When asked for the CPU utilizations over a window, compute them from the
+	samples in that window, without reading any cgroup files:
+	- the average utilization of each pod over the window, less its
+	  istio-proxy container's
+	- percentiles of the utilizations between consecutive samples
+	- the average memory working set and network bytes per second of each pod
+	- the average utilization of what is not the tenants' load (unmanaged):
+	  the system services, the pods of the host not in the window's
+	  utilizations and the istio-proxy containers of the ones that are
+*/
+
+// cpuUsageSample is the cumulative CPU usage (ns) of every pod and of its
+// istio-proxy container (sidecars), the memory working set (bytes) and the
+// cumulative network bytes of every pod at one time. Usage is keyed by cgroup
+// path, so that a restarted pod (new cgroup) does not produce a negative
+// delta. A pod whose sidecar, memory or network could not be read is only
+// left out of sidecars, memory or network. system and pods are the CPU usage
+// of the system services and of all the pods of the host (hostErr if they
+// could not be read).
+type cpuUsageSample struct {
+	time     time.Time
+	usage    map[string]int64
+	sidecars map[string]int64
+	memory   map[string]int64
+	network  map[string]int64
+	errors   map[string]string
+	system   int64
+	pods     int64
+	hostErr  error
+}
+
+// cpuUsageRing is a fixed size ring buffer of samples, oldest first.
+type cpuUsageRing struct {
+	samples []cpuUsageSample
+	start   int
+	size    int
+}
+
+func newCPUUsageRing(capacity int) *cpuUsageRing {
+	return &cpuUsageRing{samples: make([]cpuUsageSample, capacity)}
+}
+
+// push adds a sample, overwriting the oldest one when the ring is full
+func (r *cpuUsageRing) push(sample cpuUsageSample) {
+	if r.size < len(r.samples) {
+		r.samples[(r.start+r.size)%len(r.samples)] = sample
+		r.size++
+		return
+	}
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// at returns the i-th oldest sample
+func (r *cpuUsageRing) at(i int) cpuUsageSample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+type cpuSampler struct {
+	cgroup     CGroup
+	network    *networkReader
+	sidecars   *sidecarReader
+	getPodUIDs func() map[string]string
+	interval   time.Duration
+
+	mu   sync.Mutex
+	ring *cpuUsageRing
+}
+
+// newCPUSampler returns a sampler that reads the usage of the pods returned
+// by getPodUIDs every interval and keeps the samples of the last history.
+func newCPUSampler(cgroup CGroup, network *networkReader,
+	sidecars *sidecarReader, getPodUIDs func() map[string]string,
+	interval time.Duration, history time.Duration) *cpuSampler {
+
+	return &cpuSampler{
+		cgroup:     cgroup,
+		network:    network,
+		sidecars:   sidecars,
+		getPodUIDs: getPodUIDs,
+		interval:   interval,
+		ring:       newCPUUsageRing(int(history/interval) + 1),
+	}
+}
+
+// Run samples the CPU usage of the pods forever.
+func (s *cpuSampler) Run() {
+
+	slog.Info(fmt.Sprintf("Sampling CPU usage every %v", s.interval))
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sample()
+		<-ticker.C
+	}
+}
+
+func (s *cpuSampler) sample() {
+
+	sample := cpuUsageSample{
+		usage:    make(map[string]int64),
+		sidecars: make(map[string]int64),
+		memory:   make(map[string]int64),
+		network:  make(map[string]int64),
+		errors:   make(map[string]string),
+	}
+
+	readStart := time.Now()
+	sample.system, sample.hostErr = s.cgroup.GetSystemCPUUsage()
+	if sample.hostErr == nil {
+		sample.pods, sample.hostErr = s.cgroup.GetPodsCPUUsage()
+	}
+	for _, uid := range s.getPodUIDs() {
+		usage, err := s.cgroup.GetCPUUsage(uid)
+		if err != nil {
+			sample.errors[uid] = err.Error()
+			continue
+		}
+		sample.usage[uid] = usage
+
+		sidecar, err := s.sidecars.GetSidecarCPUUsage(uid)
+		if err == nil {
+			sample.sidecars[uid] = sidecar
+		}
+		memory, err := s.cgroup.GetMemoryWorkingSet(uid)
+		if err == nil {
+			sample.memory[uid] = memory
+		}
+		network, err := s.network.GetNetworkBytes(uid)
+		if err == nil {
+			sample.network[uid] = network
+		}
+	}
+	sample.time = time.Now()
+
+	if readDuration := time.Since(readStart); readDuration > s.interval/2 {
+		slog.Warn(fmt.Sprintf("Reading CPU usage of %d pods took %v",
+			len(sample.usage)+len(sample.errors), readDuration))
+	}
+
+	s.mu.Lock()
+	s.ring.push(sample)
+	s.mu.Unlock()
+}
+
+// getCPUUtilizations returns the average CPU utilization (percent of one
+// core) of each pod over the last window, from its first sample in the
+// window, and the requested percentiles (0-100) of its utilization between
+// consecutive samples in the window. Pods without two samples in the window,
+// the latest one among them, are reported in podErrors instead.
+func (s *cpuSampler) getCPUUtilizations(podUIDs map[string]string,
+	window time.Duration, percentiles []float64) (
+	utilizations map[string]float64,
+	podPercentiles map[string][]float64,
+	podErrors map[string]string) {
+
+	utilizations = make(map[string]float64)
+	podPercentiles = make(map[string][]float64)
+	podErrors = make(map[string]string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ring.size == 0 {
+		for podName := range podUIDs {
+			podErrors[podName] = "no CPU usage sampled yet"
+		}
+		return
+	}
+
+	latest := s.ring.at(s.ring.size - 1)
+	first := s.windowStart(window)
+
+	for podName, uid := range podUIDs {
+
+		if err, ok := latest.errors[uid]; ok {
+			podErrors[podName] = err
+			continue
+		}
+		if !hasUsage(latest, uid) {
+			// e.g. a pod added since the latest sample
+			podErrors[podName] = "no CPU usage sampled yet"
+			continue
+		}
+
+		// - Utilization between each pair of consecutive samples of the pod
+		var firstSample, prevSample *cpuUsageSample
+		intervalUtils := make([]float64, 0)
+		for i := first; i < s.ring.size; i++ {
+			sample := s.ring.at(i)
+			if _, ok := sample.usage[uid]; !ok {
+				continue
+			}
+			if prevSample != nil {
+				intervalUtils = append(intervalUtils,
+					getCPUUtilization(*prevSample, sample, uid))
+			} else {
+				firstSample = &sample
+			}
+			prevSample = &sample
+		}
+
+		if len(intervalUtils) == 0 {
+			podErrors[podName] = fmt.Sprintf(
+				"fewer than 2 CPU usage samples in the last %v", window)
+			continue
+		}
+
+		// - Average utilization over the window
+		utilizations[podName] = getCPUUtilization(*firstSample, latest, uid)
+
+		if len(percentiles) > 0 {
+			sort.Float64s(intervalUtils)
+			values := make([]float64, len(percentiles))
+			for i, p := range percentiles {
+				values[i] = getPercentile(intervalUtils, p)
+			}
+			podPercentiles[podName] = values
+		}
+	}
+
+	return
+}
+
+// getResourceUsages returns the average memory working set (bytes) and the
+// network bytes received and sent per second of each pod over the last
+// window. Pods whose memory or network could not be read in the window (or
+// not twice, for the network) are left out.
+func (s *cpuSampler) getResourceUsages(podUIDs map[string]string,
+	window time.Duration) (
+	memoryWorkingSets map[string]float64,
+	networkBytesPerSec map[string]float64) {
+
+	memoryWorkingSets = make(map[string]float64)
+	networkBytesPerSec = make(map[string]float64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ring.size == 0 {
+		return
+	}
+	first := s.windowStart(window)
+
+	for podName, uid := range podUIDs {
+		memorySum, memoryCount := 0.0, 0
+		var firstNetwork, lastNetwork *cpuUsageSample
+		for i := first; i < s.ring.size; i++ {
+			sample := s.ring.at(i)
+			if memory, ok := sample.memory[uid]; ok {
+				memorySum += float64(memory)
+				memoryCount++
+			}
+			if _, ok := sample.network[uid]; ok {
+				if firstNetwork == nil {
+					firstNetwork = &sample
+				}
+				lastNetwork = &sample
+			}
+		}
+
+		if memoryCount > 0 {
+			memoryWorkingSets[podName] = memorySum / float64(memoryCount)
+		}
+		if firstNetwork != nil {
+			elapsed := lastNetwork.time.Sub(firstNetwork.time).Seconds()
+			if elapsed > 0 {
+				// counters start over if the pod's network namespace does
+				networkBytesPerSec[podName] = math.Max(0, float64(
+					lastNetwork.network[uid]-firstNetwork.network[uid])/elapsed)
+			}
+		}
+	}
+
+	return
+}
+
+// getUnmanagedUtilization returns the CPU utilization (percent of one core)
+// over the last window of what is not the load of the pods in podUIDs: the
+// system services, and the pods of the host less the usage of the pods in
+// podUIDs that getCPUUtilizations reports (their istio-proxy containers left
+// in). The pods it does not report (without two samples in the window) are
+// left in whole, so no usage is counted twice.
+func (s *cpuSampler) getUnmanagedUtilization(podUIDs map[string]string,
+	window time.Duration) (float64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ring.size == 0 {
+		return 0, errors.New("no CPU usage sampled yet")
+	}
+	start := s.windowStart(window)
+	first := s.ring.at(start)
+	latest := s.ring.at(s.ring.size - 1)
+	if err := errors.Join(first.hostErr, latest.hostErr); err != nil {
+		return 0, err
+	}
+	elapsed := latest.time.Sub(first.time).Nanoseconds()
+	if elapsed <= 0 {
+		return 0, fmt.Errorf(
+			"fewer than 2 CPU usage samples in the last %v", window)
+	}
+
+	unmanaged := (latest.system - first.system) + (latest.pods - first.pods)
+	for _, uid := range podUIDs {
+		if _, ok := latest.usage[uid]; !ok {
+			continue
+		}
+		// - The same span as getCPUUtilizations: from the pod's first sample
+		for i := start; i < s.ring.size-1; i++ {
+			if from := s.ring.at(i); hasUsage(from, uid) {
+				unmanaged -= getPodCPUUsage(from, latest, uid)
+				break
+			}
+		}
+	}
+	return math.Max(0, float64(unmanaged)/float64(elapsed)*100), nil
+}
+
+// windowStart returns the index of the oldest sample in the last window.
+// s.mu must be held and the ring not empty.
+func (s *cpuSampler) windowStart(window time.Duration) int {
+	latest := s.ring.at(s.ring.size - 1)
+	first := s.ring.size - 1
+	for first > 0 && !s.ring.at(first-1).time.Before(latest.time.Add(-window)) {
+		first--
+	}
+	return first
+}
+
+// getCPUUtilization returns the CPU utilization (percent of one core) of the
+// pod with cgroup path uid between two samples, less its istio-proxy
+// container's.
+func getCPUUtilization(from, to cpuUsageSample, uid string) float64 {
+	elapsed := to.time.Sub(from.time).Nanoseconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return math.Max(0,
+		float64(getPodCPUUsage(from, to, uid))/float64(elapsed)*100)
+}
+
+// getPodCPUUsage returns the CPU time (ns) used by the pod with cgroup path
+// uid between two samples, less its istio-proxy container's if both samples
+// have it (and it did not restart in between).
+func getPodCPUUsage(from, to cpuUsageSample, uid string) int64 {
+	usage := to.usage[uid] - from.usage[uid]
+	fromSidecar, fromOK := from.sidecars[uid]
+	toSidecar, toOK := to.sidecars[uid]
+	if fromOK && toOK && toSidecar >= fromSidecar {
+		usage -= toSidecar - fromSidecar
+	}
+	return usage
+}
+
+func hasUsage(sample cpuUsageSample, uid string) bool {
+	_, ok := sample.usage[uid]
+	return ok
+}
+
+// getPercentile returns the p-th percentile (0-100) of sorted values,
+// interpolating linearly between the closest ranks.
+func getPercentile(sorted []float64, p float64) float64 {
+	rank := math.Min(math.Max(p, 0), 100) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
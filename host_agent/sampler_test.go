@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// cpuSecondsNs is s seconds of CPU time in nanoseconds.
+func cpuSecondsNs(s float64) int64 {
+	return int64(s * float64(time.Second))
+}
+
+func assertNear(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-6 {
+		t.Errorf("%s = %f, want %f", what, got, want)
+	}
+}
+
+// testSampler returns a sampler holding three samples a second apart: pod a
+// with an istio-proxy container throughout, pod b from the second sample and
+// pod c only in the last one.
+func testSampler() *cpuSampler {
+	s := newCPUSampler(nil, nil, nil, nil, time.Second, 10*time.Second)
+	start := time.Unix(0, 0)
+	s.ring.push(cpuUsageSample{
+		time:     start,
+		usage:    map[string]int64{"a": 0},
+		sidecars: map[string]int64{"a": 0},
+	})
+	s.ring.push(cpuUsageSample{
+		time:     start.Add(time.Second),
+		usage:    map[string]int64{"a": cpuSecondsNs(0.6), "b": 0},
+		sidecars: map[string]int64{"a": cpuSecondsNs(0.1)},
+		system:   cpuSecondsNs(0.2),
+		pods:     cpuSecondsNs(1),
+	})
+	s.ring.push(cpuUsageSample{
+		time: start.Add(2 * time.Second),
+		usage: map[string]int64{"a": cpuSecondsNs(1.2),
+			"b": cpuSecondsNs(0.3), "c": cpuSecondsNs(0.5)},
+		sidecars: map[string]int64{"a": cpuSecondsNs(0.2)},
+		system:   cpuSecondsNs(0.4),
+		pods:     cpuSecondsNs(2.5),
+	})
+	return s
+}
+
+func TestSamplerUtilizationsLessSidecars(t *testing.T) {
+	s := testSampler()
+	podUIDs := map[string]string{"pod-a": "a", "pod-b": "b", "pod-c": "c"}
+
+	utilizations, _, podErrors := s.getCPUUtilizations(
+		podUIDs, 2*time.Second, nil)
+	// 1.2s less the sidecar's 0.2s over 2s
+	assertNear(t, "utilization of pod-a", utilizations["pod-a"], 50)
+	// 0.3s over the second it was sampled
+	assertNear(t, "utilization of pod-b", utilizations["pod-b"], 30)
+	if _, ok := podErrors["pod-c"]; !ok {
+		t.Errorf("pod-c with a single sample is not in the errors")
+	}
+
+	// the usage of the host less what the utilizations report: 2.9s - 1s -
+	// 0.3s, the sidecar of a and all of c included
+	unmanaged, err := s.getUnmanagedUtilization(podUIDs, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNear(t, "unmanaged utilization", unmanaged, 80)
+}
+
+func TestGetPodCPUUsageSidecarRestart(t *testing.T) {
+	from := cpuUsageSample{
+		usage:    map[string]int64{"a": 1000},
+		sidecars: map[string]int64{"a": 400},
+	}
+	to := cpuUsageSample{
+		usage:    map[string]int64{"a": 2000},
+		sidecars: map[string]int64{"a": 100},
+	}
+	// a sidecar counter that went back cannot be subtracted
+	if usage := getPodCPUUsage(from, to, "a"); usage != 1000 {
+		t.Errorf("usage = %d, want 1000", usage)
+	}
+	to.sidecars["a"] = 700
+	if usage := getPodCPUUsage(from, to, "a"); usage != 700 {
+		t.Errorf("usage = %d, want 700", usage)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often a pod without an istio-proxy container is looked at again,
+	// as the sidecar starts after the init containers
+	SIDECAR_LOOKUP_INTERVAL = 30 * time.Second
+)
+
+// commands of the processes of the istio-proxy container
+var istioProxyCommands = map[string]bool{"pilot-agent": true, "envoy": true}
+
+// errNoSidecar is returned for pods without an istio-proxy container
+var errNoSidecar = errors.New("pod has no istio-proxy container")
+
+/*
+sidecarReader reads the CPU usage of the istio-proxy container of pods, which
+is not the tenant's load. The container is the one of the pod's containers
+(see CGroup.GetContainers) running pilot-agent or envoy, by the command name
+in /proc/<pid>/comm of its processes.
+
+The container found is kept for each pod until reading its usage fails (e.g.
+the container restarted in a new cgroup). A pod found without one is only
+looked at again after SIDECAR_LOOKUP_INTERVAL.
+*/
+type sidecarReader struct {
+	procRoot string
+	cgroup   CGroup
+
+	mu sync.Mutex
+	// cgroup path -> istio-proxy container ("" if none)
+	containers map[string]string
+	// cgroup path -> time it was found without one
+	lookedUp map[string]time.Time
+}
+
+func newSidecarReader(procRoot string, cgroup CGroup) *sidecarReader {
+	return &sidecarReader{
+		procRoot:   procRoot,
+		cgroup:     cgroup,
+		containers: make(map[string]string),
+		lookedUp:   make(map[string]time.Time),
+	}
+}
+
+// GetSidecarCPUUsage returns the total CPU time used by the pod's
+// istio-proxy container in nanoseconds, or errNoSidecar.
+func (r *sidecarReader) GetSidecarCPUUsage(podPath string) (int64, error) {
+	r.mu.Lock()
+	container, ok := r.containers[podPath]
+	lookedUp := r.lookedUp[podPath]
+	r.mu.Unlock()
+
+	if ok && container != "" {
+		usage, err := r.cgroup.GetContainerCPUUsage(podPath, container)
+		if err == nil {
+			return usage, nil
+		}
+	}
+	if ok && container == "" && time.Since(lookedUp) < SIDECAR_LOOKUP_INTERVAL {
+		return 0, errNoSidecar
+	}
+
+	container, err := r.find(podPath)
+	r.mu.Lock()
+	r.containers[podPath] = container
+	r.lookedUp[podPath] = time.Now()
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return r.cgroup.GetContainerCPUUsage(podPath, container)
+}
+
+// Release forgets the istio-proxy container of the pod.
+func (r *sidecarReader) Release(podPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.containers, podPath)
+	delete(r.lookedUp, podPath)
+}
+
+// find returns the pod's container running istio-proxy.
+func (r *sidecarReader) find(podPath string) (string, error) {
+	containers, err := r.cgroup.GetContainers(podPath)
+	if err != nil {
+		return "", err
+	}
+	for _, container := range containers {
+		pids, err := r.cgroup.GetContainerPIDs(podPath, container)
+		if err != nil {
+			// e.g. the pause container's, or an exited init container's
+			continue
+		}
+		for _, pid := range pids {
+			comm, err := os.ReadFile(
+				filepath.Join(r.procRoot, strconv.Itoa(pid), "comm"))
+			if err == nil && istioProxyCommands[strings.TrimSpace(string(comm))] {
+				return container, nil
+			}
+		}
+	}
+	return "", errNoSidecar
+}
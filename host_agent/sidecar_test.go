@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// fakeContainer adds a container running a process with command comm to the
+// pod of a fake cgroup v2 tree and to a fake proc filesystem.
+func fakeContainer(t *testing.T, root, podDir, procRoot, container,
+	pid, comm, usageUsec string) {
+
+	t.Helper()
+	dir := filepath.Join(podDir, container)
+	writeFakeFile(t, root, filepath.Join(dir, "cgroup.procs"), pid+"\n")
+	writeFakeFile(t, root, filepath.Join(dir, "cpu.stat"),
+		"usage_usec "+usageUsec+"\n")
+	writeFakeFile(t, procRoot, filepath.Join(pid, "comm"), comm+"\n")
+}
+
+func TestSidecarReader(t *testing.T) {
+	root, podDir := fakeCGroupV2(t)
+	procRoot := t.TempDir()
+	fakeContainer(t, root, podDir, procRoot, "pause", "10", "pause", "1")
+	fakeContainer(t, root, podDir, procRoot, "app", "11", "python3", "900")
+	fakeContainer(t, root, podDir, procRoot, "proxy", "12", "pilot-agent", "300")
+
+	reader := newSidecarReader(procRoot, NewCGroup(root))
+	usage, err := reader.GetSidecarCPUUsage(testPodPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 300_000 {
+		t.Errorf("usage = %d, want %d", usage, 300_000)
+	}
+
+	// the container is kept: its usage is read without looking again
+	writeFakeFile(t, procRoot, filepath.Join("12", "comm"), "sleep\n")
+	writeFakeFile(t, root, filepath.Join(podDir, "proxy", "cpu.stat"),
+		"usage_usec 400\n")
+	if usage, _ := reader.GetSidecarCPUUsage(testPodPath); usage != 400_000 {
+		t.Errorf("usage = %d, want %d", usage, 400_000)
+	}
+
+	reader.Release(testPodPath)
+	if _, err := reader.GetSidecarCPUUsage(testPodPath); !errors.Is(
+		err, errNoSidecar) {
+		t.Errorf("error = %v, want %v", err, errNoSidecar)
+	}
+}
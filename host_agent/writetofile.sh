@@ -1,3 +0,0 @@
-#!/usr/bin/bash
-
-echo $1 > $2
\ No newline at end of file
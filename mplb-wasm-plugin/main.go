@@ -39,6 +39,9 @@ const (
 	DEFAULT_HASH_MOD = 10
 
 	KEY_MATCH_DISTRIBUTION = "slate_match_distribution"
+
+	// services with LB weights, separated by spaces
+	KEY_LB_WEIGHT_SERVICES = "mplb_lb_weight_services"
 )
 
 var (
@@ -356,8 +359,8 @@ func (ctx *httpContext) OnHttpRequestHeaders(int, bool) types.Action {
 		// if headerErr != nil {
 		// 	proxywasm.LogCriticalf("Error adding header: %v", headerErr)
 		// }
-		if err != nil {
-			// no rules available yet.
+		if err != nil || len(weightsStr) == 0 {
+			// no rules available yet (or any more).
 			proxywasm.LogCriticalf("Removing x-lb-endpt")
 			headerErr := proxywasm.RemoveHttpRequestHeader("x-lb-endpt")
 			if headerErr != nil {
@@ -365,18 +368,17 @@ func (ctx *httpContext) OnHttpRequestHeaders(int, bool) types.Action {
 			}
 		} else {
 			// draw from distribution
+			endpoints, weights, err := parseLBWeights(dst, string(weightsStr))
+			if err != nil {
+				proxywasm.LogCriticalf("Couldn't parse weight: %v", err)
+				return types.ActionContinue
+			}
 			coin := rand.Float64()
 			total := 0.0
-			weights := strings.Split(string(weightsStr), "|")
-			for endpointNum, weight := range weights {
-				pct, err := strconv.ParseFloat(weight, 64)
-				if err != nil {
-					proxywasm.LogCriticalf("Couldn't parse weight: %v", err)
-					return types.ActionContinue
-				}
+			for endpointNum, pct := range weights {
 				total += pct / 100.0
 				if coin <= total {
-					header := fmt.Sprintf("%s-%d", dst, endpointNum)
+					header := endpoints[endpointNum]
 					proxywasm.LogCriticalf("Setting x-lb-endpt:" + header)
 					headerErr := proxywasm.ReplaceHttpRequestHeader(
 						"x-lb-endpt", header)
@@ -536,8 +538,11 @@ func OnTickHttpCallResponse(numHeaders, bodySize, numTrailers int) {
 
 	if status >= 400 {
 		proxywasm.LogCriticalf("received ERROR http call response, status %v body size: %d", hdrs, bodySize)
+		return
 	}
 	if bodySize == 0 {
+		// no weights, e.g. after the host agent was reset
+		clearDroppedLBWeights(nil)
 		return
 	}
 
@@ -548,20 +553,20 @@ func OnTickHttpCallResponse(numHeaders, bodySize, numTrailers int) {
 	}
 
 	body := string(respBody)
-	// example response body: "svcA:45.5|69.22 svcB:54.7|44.1 "
-	body = strings.TrimSpace(body)
-	if body == "" {
-		return
-	}
-	svcInfos := strings.Split(body, " ")
+	// example response body: "svcA:svcA-0=45.5,svcA-1=54.5 svcB:svcB-x7k2p=100.0"
+	// (or the old positional "svcA:45.5|54.5 svcB:100.0")
+	svcInfos := strings.Fields(body)
+	clearDroppedLBWeights(svcInfos)
 	for _, svcInfo := range svcInfos {
-		svcInfoSplit := strings.Split(svcInfo, ":")
-		if len(svcInfoSplit) != 2 {
+		svcName, svcWeights, err := splitSvcInfo(svcInfo)
+		if err != nil {
 			proxywasm.LogCriticalf("received invalid http call response, svcInfo: %s", svcInfo)
 			continue
 		}
-		svcName := svcInfoSplit[0]
-		svcWeights := svcInfoSplit[1]
+		if _, _, err := parseLBWeights(svcName, svcWeights); err != nil {
+			proxywasm.LogCriticalf("received invalid weights for %v: %v", svcName, err)
+			continue
+		}
 		proxywasm.LogCriticalf("setting outbound request weights %v: %v", svcName, svcWeights)
 		if err := proxywasm.SetSharedData(svcName, []byte(svcWeights), 0); err != nil {
 			proxywasm.LogCriticalf("unable to set shared data for endpoint distribution %v: %v", svcName, err)
@@ -569,6 +574,82 @@ func OnTickHttpCallResponse(numHeaders, bodySize, numTrailers int) {
 	}
 }
 
+// clearDroppedLBWeights clears the weights of the services that had weights
+// but are not in svcInfos any more, so that their requests are no longer
+// routed by stale weights, and records the services in svcInfos.
+func clearDroppedLBWeights(svcInfos []string) {
+	svcNames := make([]string, 0, len(svcInfos))
+	for _, svcInfo := range svcInfos {
+		svcNames = append(svcNames, strings.Split(svcInfo, ":")[0])
+	}
+
+	lastSvcNames, _, err := proxywasm.GetSharedData(KEY_LB_WEIGHT_SERVICES)
+	if err == nil {
+		for _, lastSvcName := range strings.Fields(string(lastSvcNames)) {
+			dropped := true
+			for _, svcName := range svcNames {
+				if svcName == lastSvcName {
+					dropped = false
+					break
+				}
+			}
+			if dropped {
+				proxywasm.LogCriticalf("clearing outbound request weights %v", lastSvcName)
+				if err := proxywasm.SetSharedData(lastSvcName, []byte{}, 0); err != nil {
+					proxywasm.LogCriticalf("unable to clear shared data for endpoint distribution %v: %v", lastSvcName, err)
+				}
+			}
+		}
+	}
+
+	if err := proxywasm.SetSharedData(KEY_LB_WEIGHT_SERVICES, []byte(strings.Join(svcNames, " ")), 0); err != nil {
+		proxywasm.LogCriticalf("unable to set shared data for services with weights: %v", err)
+	}
+}
+
+// splitSvcInfo splits the weights of a service in a response body,
+// "svc:weights", into the service and its weights.
+func splitSvcInfo(svcInfo string) (string, string, error) {
+	svcInfoSplit := strings.Split(svcInfo, ":")
+	if len(svcInfoSplit) != 2 || svcInfoSplit[0] == "" {
+		return "", "", fmt.Errorf("invalid weights of a service %q", svcInfo)
+	}
+	return svcInfoSplit[0], svcInfoSplit[1], nil
+}
+
+// parseLBWeights returns the endpoints (pod names, the values of x-lb-endpt)
+// of svc and their weights in percent. weights are either named,
+// "svc-0=45.5,svc-x7k2p=54.5", or positional, "45.5|54.5", where the i-th
+// weight is the weight of svc-i.
+func parseLBWeights(svc string, weights string) ([]string, []float64, error) {
+	var endpoints []string
+	var pcts []float64
+	if strings.Contains(weights, "=") {
+		for _, endpointWeight := range strings.Split(weights, ",") {
+			endpointWeightSplit := strings.Split(endpointWeight, "=")
+			if len(endpointWeightSplit) != 2 || endpointWeightSplit[0] == "" {
+				return nil, nil, fmt.Errorf("invalid weight %q", endpointWeight)
+			}
+			pct, err := strconv.ParseFloat(endpointWeightSplit[1], 64)
+			if err != nil {
+				return nil, nil, err
+			}
+			endpoints = append(endpoints, endpointWeightSplit[0])
+			pcts = append(pcts, pct)
+		}
+		return endpoints, pcts, nil
+	}
+	for endpointNum, weight := range strings.Split(weights, "|") {
+		pct, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		endpoints = append(endpoints, fmt.Sprintf("%s-%d", svc, endpointNum))
+		pcts = append(pcts, pct)
+	}
+	return endpoints, pcts, nil
+}
+
 // IncrementSharedData increments the value of the shared data at the given key. The data is
 // stored as a little endian uint64. if the key doesn't exist, it is created with the value 1.
 func IncrementSharedData(key string, amount int64) {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lbWeightsVector is a test vector of the LB weights of one service, shared
+// with the parser of the controller and host agent
+// (protocol/lbweights_test.go), so that the two parsers do not drift.
+type lbWeightsVector struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+	// service and weights of its pods, if In is valid
+	Svc   string             `json:"svc"`
+	Want  map[string]float64 `json:"want"`
+	Error bool               `json:"error"`
+}
+
+func TestParseLBWeights(t *testing.T) {
+	data, err := os.ReadFile(
+		filepath.Join("..", "protocol", "testdata", "lbweights.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []lbWeightsVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vector := range vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			// as OnTickHttpCallResponse and the request path parse them
+			svc, weights, err := splitSvcInfo(vector.In)
+			var endpoints []string
+			var pcts []float64
+			if err == nil {
+				endpoints, pcts, err = parseLBWeights(svc, weights)
+			}
+			if vector.Error {
+				if err == nil {
+					t.Errorf("%q parsed to %v %v, want an error",
+						vector.In, endpoints, pcts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if svc != vector.Svc || len(endpoints) != len(vector.Want) {
+				t.Fatalf("%q parsed to %s %v %v, want %s:%v",
+					vector.In, svc, endpoints, pcts, vector.Svc, vector.Want)
+			}
+			for i, endpoint := range endpoints {
+				if want, ok := vector.Want[endpoint]; !ok || pcts[i] != want {
+					t.Errorf("weight of %s = %f, want %f (%t)",
+						endpoint, pcts[i], want, ok)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+/*
+Hand-written equivalent of protoc generated gRPC code for the HostAgent
+service. Messages are marshalled with the "json" codec registered below.
+*/
+
+const (
+	CODEC_NAME   = "json"
+	SERVICE_NAME = "protocol.HostAgent"
+
+	VERSION_METHOD                 = "/" + SERVICE_NAME + "/Version"
+	UPDATE_PODS_METHOD             = "/" + SERVICE_NAME + "/UpdatePods"
+	APPLY_LB_WEIGHTS_METHOD        = "/" + SERVICE_NAME + "/ApplyLBWeights"
+	APPLY_CPU_SHARES_METHOD        = "/" + SERVICE_NAME + "/ApplyCPUShares"
+	APPLY_CPU_QUOTAS_METHOD        = "/" + SERVICE_NAME + "/ApplyCPUQuotas"
+	RESET_METHOD                   = "/" + SERVICE_NAME + "/Reset"
+	STREAM_CPU_UTILIZATIONS_METHOD = "/" + SERVICE_NAME + "/StreamCPUUtilizations"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CODEC_NAME
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// ================================= Server ==================================
+
+type HostAgentServer interface {
+	Version(context.Context, *Empty) (*VersionResponse, error)
+	UpdatePods(context.Context, *UpdatePodsRequest) (*Empty, error)
+	ApplyLBWeights(context.Context, *ApplyLBWeightsRequest) (*Empty, error)
+	ApplyCPUShares(context.Context, *ApplyCPUSharesRequest) (*Empty, error)
+	ApplyCPUQuotas(context.Context, *ApplyCPUQuotasRequest) (*Empty, error)
+	Reset(context.Context, *Empty) (*Empty, error)
+	StreamCPUUtilizations(
+		*StreamCPUUtilizationsRequest, CPUUtilizationsStreamServer) error
+}
+
+type CPUUtilizationsStreamServer interface {
+	Send(*CPUUtilizationSample) error
+	grpc.ServerStream
+}
+
+type cpuUtilizationsStreamServer struct {
+	grpc.ServerStream
+}
+
+func (s *cpuUtilizationsStreamServer) Send(sample *CPUUtilizationSample) error {
+	return s.ServerStream.SendMsg(sample)
+}
+
+func RegisterHostAgentServer(s *grpc.Server, srv HostAgentServer) {
+	s.RegisterService(&hostAgentServiceDesc, srv)
+}
+
+// methodHandler is the handler type of grpc.MethodDesc
+type methodHandler = func(srv interface{}, ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+// unaryHandler adapts a typed unary method of HostAgentServer to a
+// grpc.MethodDesc handler.
+func unaryHandler[Req any, Resp any](
+	fullMethod string,
+	call func(HostAgentServer, context.Context, *Req) (*Resp, error),
+) methodHandler {
+
+	return func(srv interface{}, ctx context.Context,
+		dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(HostAgentServer), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(HostAgentServer), ctx, req.(*Req))
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func streamCPUUtilizationsHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(StreamCPUUtilizationsRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(HostAgentServer).StreamCPUUtilizations(
+		req, &cpuUtilizationsStreamServer{stream})
+}
+
+var hostAgentServiceDesc = grpc.ServiceDesc{
+	ServiceName: SERVICE_NAME,
+	HandlerType: (*HostAgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Version",
+			Handler:    unaryHandler(VERSION_METHOD, HostAgentServer.Version),
+		},
+		{
+			MethodName: "UpdatePods",
+			Handler:    unaryHandler(UPDATE_PODS_METHOD, HostAgentServer.UpdatePods),
+		},
+		{
+			MethodName: "ApplyLBWeights",
+			Handler: unaryHandler(
+				APPLY_LB_WEIGHTS_METHOD, HostAgentServer.ApplyLBWeights),
+		},
+		{
+			MethodName: "ApplyCPUShares",
+			Handler: unaryHandler(
+				APPLY_CPU_SHARES_METHOD, HostAgentServer.ApplyCPUShares),
+		},
+		{
+			MethodName: "ApplyCPUQuotas",
+			Handler: unaryHandler(
+				APPLY_CPU_QUOTAS_METHOD, HostAgentServer.ApplyCPUQuotas),
+		},
+		{
+			MethodName: "Reset",
+			Handler:    unaryHandler(RESET_METHOD, HostAgentServer.Reset),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamCPUUtilizations",
+			Handler:       streamCPUUtilizationsHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// ================================= Client ==================================
+
+// Dial creates a client connection to a host agent at addr ("host:port").
+// The connection is established lazily and re-established by gRPC when lost.
+func Dial(addr string) (*grpc.ClientConn, error) {
+	return grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CODEC_NAME)))
+}
+
+type HostAgentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHostAgentClient(cc grpc.ClientConnInterface) *HostAgentClient {
+	return &HostAgentClient{cc}
+}
+
+// Version returns the VERSION of the API the host agent serves.
+func (c *HostAgentClient) Version(ctx context.Context) (int, error) {
+	resp := new(VersionResponse)
+	if err := c.cc.Invoke(ctx, VERSION_METHOD, new(Empty), resp); err != nil {
+		return 0, err
+	}
+	return resp.Version, nil
+}
+
+func (c *HostAgentClient) UpdatePods(
+	ctx context.Context, req *UpdatePodsRequest) error {
+	return c.cc.Invoke(ctx, UPDATE_PODS_METHOD, req, new(Empty))
+}
+
+func (c *HostAgentClient) ApplyLBWeights(
+	ctx context.Context, req *ApplyLBWeightsRequest) error {
+	return c.cc.Invoke(ctx, APPLY_LB_WEIGHTS_METHOD, req, new(Empty))
+}
+
+func (c *HostAgentClient) ApplyCPUShares(
+	ctx context.Context, req *ApplyCPUSharesRequest) error {
+	return c.cc.Invoke(ctx, APPLY_CPU_SHARES_METHOD, req, new(Empty))
+}
+
+func (c *HostAgentClient) ApplyCPUQuotas(
+	ctx context.Context, req *ApplyCPUQuotasRequest) error {
+	return c.cc.Invoke(ctx, APPLY_CPU_QUOTAS_METHOD, req, new(Empty))
+}
+
+// Reset asks the host agent to undo everything it applied (see protocol.go).
+func (c *HostAgentClient) Reset(ctx context.Context) error {
+	return c.cc.Invoke(ctx, RESET_METHOD, new(Empty), new(Empty))
+}
+
+type CPUUtilizationsStreamClient interface {
+	Recv() (*CPUUtilizationSample, error)
+	grpc.ClientStream
+}
+
+type cpuUtilizationsStreamClient struct {
+	grpc.ClientStream
+}
+
+func (s *cpuUtilizationsStreamClient) Recv() (*CPUUtilizationSample, error) {
+	sample := new(CPUUtilizationSample)
+	if err := s.ClientStream.RecvMsg(sample); err != nil {
+		return nil, err
+	}
+	return sample, nil
+}
+
+func (c *HostAgentClient) StreamCPUUtilizations(
+	ctx context.Context, req *StreamCPUUtilizationsRequest) (
+	CPUUtilizationsStreamClient, error) {
+
+	stream, err := c.cc.NewStream(ctx,
+		&hostAgentServiceDesc.Streams[0], STREAM_CPU_UTILIZATIONS_METHOD)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &cpuUtilizationsStreamClient{stream}, nil
+}
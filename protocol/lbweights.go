@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+LB weights are sent by the CC to the host agents (ApplyLBWeightsRequest) and
+served by the host agents to the load balancers as one line with the weights
+of the pods of every service, keyed by pod name:
+
+	profile:profile-7fd495b998-xf95m=25.0,profile-7fd495b998-k2x7q=75.0 frontend:frontend-0=100.0
+
+The Wasm plugin sets the x-lb-endpt header of a request to the pod it draws.
+
+The old positional format, where the i-th weight is the weight of pod
+<service>-<i> (the StatefulSet ordinal), is still accepted:
+
+	profile:25.0|75.0 frontend:100.0
+*/
+
+// LBWeights are the LB weights (percent) of the pods by service and pod.
+type LBWeights map[string]map[string]float64
+
+// ParseLBWeights parses LB weights in the named or the positional format.
+// Positional weights are keyed by the pod names <service>-<i>.
+func ParseLBWeights(s string) (LBWeights, error) {
+	lbWeights := make(LBWeights)
+	for _, svcWeights := range strings.Fields(s) {
+		svc, weights, ok := strings.Cut(svcWeights, ":")
+		if !ok || svc == "" || weights == "" {
+			return nil, fmt.Errorf("invalid LB weights of a service: %q",
+				svcWeights)
+		}
+
+		podWeights := make(map[string]float64)
+		if strings.Contains(weights, "=") {
+			for _, podWeight := range strings.Split(weights, ",") {
+				pod, weight, ok := strings.Cut(podWeight, "=")
+				if !ok || pod == "" {
+					return nil, fmt.Errorf("invalid LB weight of %s: %q",
+						svc, podWeight)
+				}
+				value, err := strconv.ParseFloat(weight, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid LB weight of %s: %w",
+						pod, err)
+				}
+				podWeights[pod] = value
+			}
+		} else {
+			for i, weight := range strings.Split(weights, "|") {
+				value, err := strconv.ParseFloat(weight, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid LB weight of %s-%d: %w",
+						svc, i, err)
+				}
+				podWeights[fmt.Sprintf("%s-%d", svc, i)] = value
+			}
+		}
+		lbWeights[svc] = podWeights
+	}
+	return lbWeights, nil
+}
+
+// String returns the LB weights in the named format, sorted by service and
+// pod.
+func (w LBWeights) String() string {
+	svcs := make([]string, 0, len(w))
+	for svc := range w {
+		svcs = append(svcs, svc)
+	}
+	sort.Strings(svcs)
+
+	svcWeights := make([]string, 0, len(w))
+	for _, svc := range svcs {
+		pods := make([]string, 0, len(w[svc]))
+		for pod := range w[svc] {
+			pods = append(pods, pod)
+		}
+		sort.Strings(pods)
+
+		podWeights := make([]string, len(pods))
+		for i, pod := range pods {
+			podWeights[i] = fmt.Sprintf("%s=%f", pod, w[svc][pod])
+		}
+		svcWeights = append(svcWeights,
+			svc+":"+strings.Join(podWeights, ","))
+	}
+	return strings.Join(svcWeights, " ")
+}
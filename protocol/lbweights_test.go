@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lbWeightsVector is a test vector of the LB weights of one service, shared
+// with the parser of the Wasm plugin (mplb-wasm-plugin/main_test.go).
+type lbWeightsVector struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+	// service and weights of its pods, if In is valid
+	Svc   string             `json:"svc"`
+	Want  map[string]float64 `json:"want"`
+	Error bool               `json:"error"`
+}
+
+func readLBWeightsVectors(t *testing.T) []lbWeightsVector {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "lbweights.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []lbWeightsVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func TestParseLBWeights(t *testing.T) {
+	for _, vector := range readLBWeightsVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			lbWeights, err := ParseLBWeights(vector.In)
+			if vector.Error {
+				if err == nil {
+					t.Errorf("ParseLBWeights(%q) = %v, want an error",
+						vector.In, lbWeights)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lbWeights) != 1 || len(lbWeights[vector.Svc]) != len(vector.Want) {
+				t.Fatalf("ParseLBWeights(%q) = %v, want %s:%v",
+					vector.In, lbWeights, vector.Svc, vector.Want)
+			}
+			for pod, want := range vector.Want {
+				if got, ok := lbWeights[vector.Svc][pod]; !ok || got != want {
+					t.Errorf("weight of %s = %f (%t), want %f",
+						pod, got, ok, want)
+				}
+			}
+
+			// the named format of String is parsed back to the same weights
+			again, err := ParseLBWeights(lbWeights.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for pod, want := range vector.Want {
+				if got := again[vector.Svc][pod]; got != want {
+					t.Errorf("weight of %s after String = %f, want %f",
+						pod, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLBWeightsServices(t *testing.T) {
+	lbWeights, err := ParseLBWeights(
+		" profile:profile-0=25.0,profile-1=75.0  frontend:100.0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lbWeights) != 2 || lbWeights["profile"]["profile-1"] != 75 ||
+		lbWeights["frontend"]["frontend-0"] != 100 {
+		t.Errorf("ParseLBWeights = %v", lbWeights)
+	}
+
+	// one invalid service fails all of them
+	if _, err := ParseLBWeights("profile:25.0|75.0 frontend:"); err == nil {
+		t.Errorf("ParseLBWeights with an invalid service did not fail")
+	}
+}
@@ -0,0 +1,89 @@
+package protocol
+
+/*
+Control API between the central controller (CC) and the host agents.
+
+The host agent serves the HostAgent gRPC service (see grpc.go) on its cc
+port. The CC pushes pod state and enforcement decisions with unary calls,
+and subscribes to a server-streaming feed of per-pod CPU utilizations.
+
+Reset (with an Empty request) puts the host agent back in a neutral state:
+the cgroup files of the pods get the values they had before the host agent
+first wrote them, and no LB weights are served. The host agent also resets on
+its own when the CC has not been in touch for its lease period.
+
+Messages are the plain Go structs below, encoded as JSON by the codec
+registered in grpc.go, so both binaries share one definition of the API.
+
+The API is versioned by VERSION: the CC asks the host agent for its version
+(Version) when it connects, and does not use host agents of another version.
+Bump VERSION on every change that older peers cannot handle.
+*/
+
+// VERSION of the API; 1 was the length-prefixed protocol before gRPC
+const VERSION = 2
+
+type Empty struct{}
+
+// VersionResponse is the VERSION of the API the host agent serves.
+type VersionResponse struct {
+	Version int `json:"version"`
+}
+
+// UpdatePodsRequest replaces the set of pods the host agent manages.
+type UpdatePodsRequest struct {
+	// pod name -> cgroup path relative to kubepods, e.g. "burstable/pod<uid>"
+	Pods map[string]string `json:"pods"`
+}
+
+// ApplyLBWeightsRequest sets the weights served to the load balancers.
+type ApplyLBWeightsRequest struct {
+	// e.g. "profile:profile-0=0.0,profile-1=100.0 recommendation:recommendation-0=100.0"
+	// or the old "profile:0.0|100.0 recommendation:100.0" (see lbweights.go)
+	Weights string `json:"weights"`
+}
+
+// ApplyCPUSharesRequest sets cpu.shares for each pod.
+type ApplyCPUSharesRequest struct {
+	Shares map[string]int64 `json:"shares"`
+}
+
+// ApplyCPUQuotasRequest sets cpu.cfs_quota_us for each pod (-1 = no quota).
+type ApplyCPUQuotasRequest struct {
+	Quotas map[string]int64 `json:"quotas"`
+}
+
+// StreamCPUUtilizationsRequest subscribes to CPU utilization samples.
+type StreamCPUUtilizationsRequest struct {
+	// time between two samples sent on the stream
+	IntervalMs int64 `json:"intervalMs"`
+	// utilizations are averaged over the last WindowMs (0 = IntervalMs)
+	WindowMs int64 `json:"windowMs,omitempty"`
+	// percentiles (0-100) of the utilization within the window to send
+	Percentiles []float64 `json:"percentiles,omitempty"`
+	// more windows to average the utilizations over, e.g. 1000, 5000 and
+	// 30000, sent in WindowUtilizations
+	WindowsMs []int64 `json:"windowsMs,omitempty"`
+}
+
+type CPUUtilizationSample struct {
+	// unix time in nanoseconds at which the sample was taken
+	Time int64 `json:"time"`
+	// pod name -> CPU utilization in percent of one core
+	Utilizations map[string]float64 `json:"utilizations"`
+	// pod name -> the requested percentiles, in the order of the request
+	Percentiles map[string][]float64 `json:"percentiles,omitempty"`
+	// window in WindowsMs -> pod name -> CPU utilization averaged over it
+	WindowUtilizations map[int64]map[string]float64 `json:"windowUtilizations,omitempty"`
+	// pod name -> why its CPU utilization could not be read; such pods are
+	// not in Utilizations
+	Errors map[string]string `json:"errors,omitempty"`
+	// pod name -> memory working set in bytes, averaged over the window
+	MemoryWorkingSets map[string]float64 `json:"memoryWorkingSets,omitempty"`
+	// pod name -> bytes received and sent per second over the window
+	NetworkBytesPerSec map[string]float64 `json:"networkBytesPerSec,omitempty"`
+	// CPU utilization in percent of one core of what runs on the host
+	// besides the pods in UpdatePodsRequest: its system services and the
+	// pods of other namespaces (0 if it could not be read)
+	UnmanagedUtilization float64 `json:"unmanagedUtilization,omitempty"`
+}